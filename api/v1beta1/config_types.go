@@ -21,59 +21,241 @@ import (
 	cfg "sigs.k8s.io/controller-runtime/pkg/config/v1alpha1"
 )
 
-// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
-
-// ConfigSpec defines the desired state of Config
+// ConfigSpec defines the settings of the operator, read from the config file or from an OperatorConfig in the cluster
 type ConfigSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	OIDC OIDCOptions `json:"oidc,omitempty"`
+
+	// Name of the EKS cluster the operator runs in, required for EKS Pod Identity
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// Default identity mode for Roles which do not set one, defaults to IRSA
+	IdentityMode IdentityMode `json:"identityMode,omitempty"`
+
+	// Plan the IAM changes for every Role in its status, without making them
+	DryRun bool `json:"dryRun,omitempty"`
+
+	// How long a Role is reported Propagating, rather than Ready, after its IAM role changes, as IAM changes take
+	// time to become visible everywhere. Defaults to 10s
+	PropagationSettlePeriod metav1.Duration `json:"propagationSettlePeriod,omitempty"`
+
+	// How often every Role is reconciled again, finding and repairing drift of its IAM role, defaults to 10m
+	ResyncInterval metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// Number of RoleRevisions kept for each Role, recording the policies applied to its IAM role, defaults to 10
+	RevisionHistoryLimit int `json:"revisionHistoryLimit,omitempty"`
+
+	// Garbage collection of IAM roles created for Roles which no longer exist
+	GarbageCollection GarbageCollectionOptions `json:"garbageCollection,omitempty"`
 
-	// Foo is an example field of Config. Edit config_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Validation of the actions in Role statements against a catalog of IAM actions
+	ActionValidation ActionValidationOptions `json:"actionValidation,omitempty"`
+
+	// Linting of the rendered policies of Roles for overly broad permissions
+	PolicyLint PolicyLintOptions `json:"policyLint,omitempty"`
+
+	RoleNameOptions RoleNameOptions `json:"roleNameOptions,omitempty"`
+
+	InlinePolicyNameOptions InlinePolicyNameOptions `json:"inlinePolicyNameOptions,omitempty"`
 }
 
-// ConfigStatus defines the observed state of Config
+// ConfigStatus defines the observed state of an OperatorConfig
 type ConfigStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Generation of the OperatorConfig last applied (or rejected) by the operator
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions describing whether the spec was applied. Ready is False (with the reason ValidationFailed) when
+	// the spec is invalid, in which case the operator keeps the settings it last applied
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
 
+// Reasons of the Ready condition set on OperatorConfigs, besides ValidationFailed and RetryableError
+const (
+	ReasonConfigApplied = "Applied"
+)
+
 //+kubebuilder:object:root=true
-//+kubebuilder:subresource:status
 
-// Config is the Schema for the configs API
+// Config is the operator config file, with the manager options and the operator settings
 type Config struct {
 	metav1.TypeMeta `json:",inline"`
 
 	// ControllerManagerConfigurationSpec returns the contfigurations for controllers
 	cfg.ControllerManagerConfigurationSpec `json:",inline"`
 
-	OIDC struct {
-		ProviderARN string `json:"providerArn"`
-		IssuerURL   string `json:"issuerUrl"`
-	} `json:"oidc,omitempty"`
+	ConfigSpec `json:",inline"`
+
+	// Name of the cluster-scoped OperatorConfig whose spec is applied in place of the settings in this file while
+	// it exists, defaults to "default"
+	OperatorConfigName string `json:"operatorConfigName,omitempty"`
+
+	// The Roles watched by this instance of the operator, e.g. to run an instance per tenant or AWS account. Only
+	// read from this file, as it configures the manager cache.
+	Scope ScopeOptions `json:"scope,omitempty"`
+
+	// Sharding of Roles between the replicas of the operator. Only read from this file.
+	Sharding ShardingOptions `json:"sharding,omitempty"`
+}
+
+// ShardingOptions defines how Roles are split between the replicas of the operator
+type ShardingOptions struct {
+	// Every replica reconciles the Roles it owns (by hash of their namespace and name), rather than the leader
+	// reconciling every Role. Leader election is not used.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// How long the shard Lease of a replica is valid without being renewed, before its Roles move to the other
+	// replicas, defaults to 30s
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+
+	// Namespace of the shard Leases, defaults to the namespace the operator runs in
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+}
+
+// ScopeOptions restricts the Roles reconciled by the operator. Roles out of scope are left untouched, for another
+// instance of the operator to manage.
+type ScopeOptions struct {
+	// Namespaces whose Roles are watched, defaults to every namespace
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// Only Roles whose labels match this selector are watched
+	RoleSelector *metav1.LabelSelector `json:"roleSelector,omitempty"`
+
+	// Only Roles in namespaces whose labels match this selector are reconciled
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// RoleNameOptions defines how IAM role names are generated from Role names
+type RoleNameOptions struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+
+	// How long a previously named IAM role is kept after the role name changes, before it is deleted
+	RenameGracePeriod metav1.Duration `json:"renameGracePeriod,omitempty"`
+}
+
+// InlinePolicyNameOptions defines how inline policy names are generated from statement group names
+type InlinePolicyNameOptions struct {
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+// OIDCOptions defines the cluster OIDC provider trusted by the IAM roles
+type OIDCOptions struct {
+	ProviderARN string `json:"providerArn"`
+	IssuerURL   string `json:"issuerUrl"`
+
+	// Discover the issuer URL and provider ARN at startup, when they are not set. The issuer URL is read from EKS
+	// when clusterName is set, otherwise from the API server, and the provider ARN is looked up in IAM
+	Discover bool `json:"discover,omitempty"`
+
+	// Create the IAM OIDC provider for the issuer at startup if it does not exist (or verify it accepts the
+	// audiences if it does), rather than expecting it to be managed elsewhere
+	ManageProvider bool `json:"manageProvider,omitempty"`
+
+	// Audiences accepted in the service account token "aud" claim, defaults to sts.amazonaws.com
+	Audiences []string `json:"audiences,omitempty"`
+
+	// Further cluster OIDC providers trusted by every role, e.g. the new cluster during a blue/green upgrade
+	AdditionalProviders []OIDCProvider `json:"additionalProviders,omitempty"`
+}
+
+// GarbageCollectionPolicy determines what happens to IAM roles whose Role no longer exists
+// +kubebuilder:validation:Enum=Disabled;Report;Delete
+type GarbageCollectionPolicy string
+
+const (
+	GarbageCollectionDisabled GarbageCollectionPolicy = "Disabled"
+	GarbageCollectionReport   GarbageCollectionPolicy = "Report"
+	GarbageCollectionDelete   GarbageCollectionPolicy = "Delete"
+)
+
+// GarbageCollectionOptions defines how IAM roles left behind by deleted Roles (e.g. after the finalizer was removed
+// by hand) are found and cleaned up. Only roles tagged as owned by the operator for this cluster are considered.
+type GarbageCollectionOptions struct {
+	// Disabled (the default), Report (log and count orphaned roles) or Delete (also delete them)
+	Policy GarbageCollectionPolicy `json:"policy,omitempty"`
+
+	// How often IAM roles are checked, defaults to 1h
+	Interval metav1.Duration `json:"interval,omitempty"`
+
+	// How long a role must have been orphaned (and have existed) before it is deleted, defaults to 24h
+	GracePeriod metav1.Duration `json:"gracePeriod,omitempty"`
+}
+
+// ActionValidationPolicy determines what happens to Roles with actions which are not in the IAM action catalog
+// +kubebuilder:validation:Enum=Disabled;Warn;Strict
+type ActionValidationPolicy string
+
+const (
+	ActionValidationDisabled ActionValidationPolicy = "Disabled"
+	ActionValidationWarn     ActionValidationPolicy = "Warn"
+	ActionValidationStrict   ActionValidationPolicy = "Strict"
+)
+
+// ActionValidationOptions defines how the actions of Role statements are checked for typos, against a catalog of AWS
+// service prefixes and IAM actions. No AWS API is called.
+type ActionValidationOptions struct {
+	// Disabled, Warn (the default, report unknown actions in the ActionsValid condition of the Role) or Strict
+	// (also reject the Role)
+	Policy ActionValidationPolicy `json:"policy,omitempty"`
+
+	// Path of an IAM action catalog file replacing the catalog built into the operator, e.g. to pick up new
+	// actions without upgrading the operator
+	CatalogPath string `json:"catalogPath,omitempty"`
+}
+
+// PolicyLintPolicy determines what happens to Roles whose rendered policies grant overly broad permissions
+// +kubebuilder:validation:Enum=Disabled;Warn;Block
+type PolicyLintPolicy string
+
+const (
+	PolicyLintDisabled PolicyLintPolicy = "Disabled"
+	PolicyLintWarn     PolicyLintPolicy = "Warn"
+	PolicyLintBlock    PolicyLintPolicy = "Block"
+)
+
+// PolicyLintOptions defines how the rendered policies of Roles are checked for risky patterns, such as write actions
+// on any resource, service-wide wildcards or privilege escalation
+type PolicyLintOptions struct {
+	// Disabled, Warn (the default, report findings in the status of the Role) or Block (also reject the Role)
+	Policy PolicyLintPolicy `json:"policy,omitempty"`
+
+	// Lint rules which are not reported, e.g. ServiceWildcard
+	IgnoreRules []string `json:"ignoreRules,omitempty"`
+}
+
+// OIDCProvider is an EKS cluster OIDC issuer and the IAM OIDC provider registered for it
+type OIDCProvider struct {
+	ProviderARN string `json:"providerArn"`
+	IssuerURL   string `json:"issuerUrl"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:scope=Cluster
 
-	RoleNameOptions struct {
-		Prefix string `json:"prefix,omitempty"`
-		Suffix string `json:"suffix,omitempty"`
-	} `json:"roleNameOptions,omitempty"`
+// OperatorConfig holds the settings of the operator in the cluster. While it exists, its spec is applied in place
+// of the settings in the config file, and changes to it are applied without restarting the operator.
+type OperatorConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	InlinePolicyNameOptions struct {
-		Prefix string `json:"prefix,omitempty"`
-		Suffix string `json:"suffix,omitempty"`
-	} `json:"inlinePolicyNameOptions,omitempty"`
+	Spec   ConfigSpec   `json:"spec,omitempty"`
+	Status ConfigStatus `json:"status,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 
-// ConfigList contains a list of Config
-type ConfigList struct {
+// OperatorConfigList contains a list of OperatorConfig
+type OperatorConfigList struct {
 	metav1.TypeMeta `json:",inline"`
 	metav1.ListMeta `json:"metadata,omitempty"`
-	Items           []Config `json:"items"`
+	Items           []OperatorConfig `json:"items"`
 }
 
 func init() {
-	SchemeBuilder.Register(&Config{})
+	SchemeBuilder.Register(&Config{}, &OperatorConfig{}, &OperatorConfigList{})
 }
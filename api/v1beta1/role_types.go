@@ -23,8 +23,22 @@ import (
 type SyncState string
 
 const (
-	SyncStateOK  SyncState = "OK"
-	SyncStateErr SyncState = "ERROR"
+	SyncStateOK          SyncState = "OK"
+	SyncStateErr         SyncState = "ERROR"
+	SyncStatePropagating SyncState = "Propagating"
+)
+
+// IdentityMode determines how pods running as the service accounts obtain credentials for the IAM role
+// +kubebuilder:validation:Enum=IRSA;PodIdentity
+type IdentityMode string
+
+const (
+	// IdentityModeIRSA trusts the service accounts through the cluster OIDC provider (IAM roles for service accounts)
+	IdentityModeIRSA IdentityMode = "IRSA"
+
+	// IdentityModePodIdentity trusts the EKS Pod Identity service, and associates the service accounts with the
+	// role using EKS Pod Identity Associations
+	IdentityModePodIdentity IdentityMode = "PodIdentity"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -44,6 +58,45 @@ type RoleSpec struct {
 
 	// +kubebuilder:validation:Required
 	Statements map[string][]StatementSpec `json:"statements"`
+
+	// How pods obtain credentials for the role. Defaults to the identity mode in the operator config
+	// +optional
+	IdentityMode IdentityMode `json:"identityMode,omitempty"`
+
+	// Audiences accepted in the service account token "aud" claim. Overrides the audiences set in the operator
+	// config, which default to sts.amazonaws.com
+	// +optional
+	Audiences []string `json:"audiences,omitempty"`
+
+	// Further cluster OIDC providers trusted by this role, in addition to those in the operator config
+	// +optional
+	OIDCProviders []OIDCProvider `json:"oidcProviders,omitempty"`
+
+	// Additional principals allowed to assume the role, each rendered as a separate trust policy statement
+	// +optional
+	AdditionalTrust []TrustSpec `json:"additionalTrust,omitempty"`
+}
+
+// TrustSpec defines an additional principal allowed to assume the role. Exactly one of aws, services or
+// federated must be set.
+type TrustSpec struct {
+
+	// IAM principal ARNs (e.g. other IAM roles, for role chaining) allowed to assume the role
+	// +optional
+	AWS []string `json:"aws,omitempty"`
+
+	// AWS service principals (e.g. lambda.amazonaws.com) allowed to assume the role
+	// +optional
+	Services []string `json:"services,omitempty"`
+
+	// ARN of an additional IAM OIDC provider (e.g. GitHub Actions) allowed to assume the role with a web identity
+	// +optional
+	Federated string `json:"federated,omitempty"`
+
+	// Conditions of the trust statement, keyed by condition operator and then condition key, e.g.
+	// {"StringLike": {"token.actions.githubusercontent.com:sub": ["repo:my-org/*"]}}
+	// +optional
+	Conditions map[string]map[string][]string `json:"conditions,omitempty"`
 }
 
 // StatementSpec defines an actual inline permission
@@ -54,6 +107,11 @@ type StatementSpec struct {
 
 	// +kubebuilder:validation:Required
 	Resources []string `json:"resources"`
+
+	// Replace the action wildcards (e.g. s3:Get*) with the actions they match in the operator's IAM action catalog
+	// when rendering, so the statement does not grant actions added to the service later
+	// +optional
+	ExpandActions bool `json:"expandActions,omitempty"`
 }
 
 // RoleStatus defines the observed state of Role
@@ -64,6 +122,170 @@ type RoleStatus struct {
 	State              SyncState `json:"state"`
 	Error              string    `json:"error"`
 	ObservedGeneration int64     `json:"observedGeneration"`
+
+	// Name of the IAM role currently managed for this Role
+	// +optional
+	RoleName string `json:"roleName,omitempty"`
+
+	// ARN of the IAM role currently managed for this Role
+	// +optional
+	RoleARN string `json:"roleArn,omitempty"`
+
+	// Time the IAM role was last changed by the operator. The Role is Propagating, rather than Ready, until the
+	// propagation settle period has passed since this time, as IAM changes are not visible everywhere at once
+	// +optional
+	LastIAMChangeTime *metav1.Time `json:"lastIAMChangeTime,omitempty"`
+
+	// Number of the RoleRevision (named <Role name>-<revision>) recording the policies last applied to the IAM role
+	// +optional
+	CurrentRevision int64 `json:"currentRevision,omitempty"`
+
+	// Number of the RoleRevision whose policies are applied in place of those of the spec, set while the Role has
+	// the rollback annotation
+	// +optional
+	RolledBackToRevision int64 `json:"rolledBackToRevision,omitempty"`
+
+	// The last failed change to the IAM role, and whether the changes made before the failure were rolled back
+	// +optional
+	LastRollback *RollbackStatus `json:"lastRollback,omitempty"`
+
+	// IAM roles previously managed for this Role (e.g. before a role name prefix/suffix change), which are
+	// deleted once their grace period has expired
+	// +optional
+	RetiredRoles []RetiredRole `json:"retiredRoles,omitempty"`
+
+	// EKS Pod Identity Associations managed for the service accounts
+	// +optional
+	PodIdentityAssociations []PodIdentityAssociation `json:"podIdentityAssociations,omitempty"`
+
+	// ARNs of the customer managed policies created and attached by the operator, for the statements which do not
+	// fit in the inline policy size limit of the IAM role
+	// +optional
+	ManagedPolicies []string `json:"managedPolicies,omitempty"`
+
+	// Overly broad permissions found in the rendered policies by the policy linter
+	// +optional
+	PolicyFindings []PolicyFinding `json:"policyFindings,omitempty"`
+
+	// Conditions describing the sync state. Ready is True once the IAM role matches the spec and its changes have
+	// had time to propagate (Ready is False with the reason Propagating until then), and Stalled is True
+	// when the last failure cannot be resolved by retrying, and will not be retried until the spec changes
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// IAM changes that would be made for the Role, set when the Role is reconciled in dry-run mode
+	// +optional
+	Plan *RolePlan `json:"plan,omitempty"`
+}
+
+// RollbackStatus describes a failed change to an IAM role, which was rolled back to its trust policy and inline
+// policies before the change (or deleted, if the change created it)
+type RollbackStatus struct {
+	// Time the change failed
+	Time metav1.Time `json:"time"`
+
+	// Error which failed the change
+	Error string `json:"error"`
+
+	// Whether the IAM role was restored
+	Succeeded bool `json:"succeeded"`
+
+	// Error which failed the rollback, leaving the IAM role partially changed until the next reconcile
+	// +optional
+	RollbackError string `json:"rollbackError,omitempty"`
+}
+
+// PolicyFinding is an overly broad permission found in the rendered policies of a Role
+type PolicyFinding struct {
+	// Lint rule, e.g. WildcardResourceWrite or PrivilegeEscalation
+	Rule string `json:"rule"`
+
+	// Policy the permission was found in, empty for findings across the policies of the role
+	// +optional
+	Policy string `json:"policy,omitempty"`
+
+	Message string `json:"message"`
+}
+
+// Condition types and reasons set on Roles
+const (
+	ConditionTypeReady        = "Ready"
+	ConditionTypeStalled      = "Stalled"
+	ConditionTypeActionsValid = "ActionsValid"
+
+	ReasonSynced           = "Synced"
+	ReasonThrottled        = "Throttled"
+	ReasonRetryableError   = "RetryableError"
+	ReasonTerminalError    = "TerminalError"
+	ReasonValidationFailed = "ValidationFailed"
+	ReasonDryRun           = "DryRun"
+	ReasonPropagating      = "Propagating"
+	ReasonActionsKnown     = "ActionsKnown"
+	ReasonUnknownActions   = "UnknownActions"
+)
+
+// DryRunAnnotation set to "true" on a Role makes the operator plan the IAM changes for the Role, without making them
+const DryRunAnnotation = "eks-iam-operator.neilmcgibbon.com/dry-run"
+
+// PlanAction is a change that would be made to an IAM role
+// +kubebuilder:validation:Enum=CreateRole;UpdateTrustPolicy;TagRole;PutInlinePolicy;DeleteInlinePolicy;PutManagedPolicy;DeleteManagedPolicy;DeleteRole
+type PlanAction string
+
+const (
+	PlanActionCreateRole          PlanAction = "CreateRole"
+	PlanActionUpdateTrustPolicy   PlanAction = "UpdateTrustPolicy"
+	PlanActionTagRole             PlanAction = "TagRole"
+	PlanActionPutInlinePolicy     PlanAction = "PutInlinePolicy"
+	PlanActionDeleteInlinePolicy  PlanAction = "DeleteInlinePolicy"
+	PlanActionPutManagedPolicy    PlanAction = "PutManagedPolicy"
+	PlanActionDeleteManagedPolicy PlanAction = "DeleteManagedPolicy"
+	PlanActionDeleteRole          PlanAction = "DeleteRole"
+)
+
+// RolePlan describes the IAM changes that would be made to reconcile a Role
+type RolePlan struct {
+	// Name of the IAM role the plan applies to
+	RoleName string `json:"roleName"`
+
+	// Generation of the Role the plan was made for
+	ObservedGeneration int64 `json:"observedGeneration"`
+
+	// Time the current state of the IAM role was read
+	PlannedAt metav1.Time `json:"plannedAt"`
+
+	// Changes in the order they would be made, empty when the IAM role is already in sync
+	// +optional
+	Changes []PlannedChange `json:"changes,omitempty"`
+}
+
+// PlannedChange is a single change that would be made to an IAM role
+type PlannedChange struct {
+	Action PlanAction `json:"action"`
+
+	// Name of the inline policy, for inline policy changes
+	// +optional
+	Policy string `json:"policy,omitempty"`
+
+	// Unified diff of the current and desired policy document, formatted as indented JSON
+	// +optional
+	Diff string `json:"diff,omitempty"`
+}
+
+// PodIdentityAssociation is an EKS Pod Identity Association managed for a service account
+type PodIdentityAssociation struct {
+	Namespace      string `json:"namespace"`
+	ServiceAccount string `json:"serviceAccount"`
+	AssociationID  string `json:"associationId"`
+}
+
+// RetiredRole is an IAM role that has been replaced by a renamed role, and is pending deletion
+type RetiredRole struct {
+	Name string `json:"name"`
+
+	// Time after which the IAM role is deleted
+	DeleteAfter metav1.Time `json:"deleteAfter"`
 }
 
 //+kubebuilder:object:root=true
@@ -0,0 +1,80 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RevisionRoleLabel is set on RoleRevisions to the name of the Role they were recorded for, cut to 63 characters
+// ending in a hash of the name if it is longer
+const RevisionRoleLabel = "eks-iam-operator.neilmcgibbon.com/role"
+
+// RollbackAnnotation set to a revision number on a Role makes the operator apply the policies recorded in that
+// RoleRevision to the IAM role, in place of those rendered from the spec, until the annotation is removed
+const RollbackAnnotation = "eks-iam-operator.neilmcgibbon.com/rollback-to-revision"
+
+// RoleRevisionSpec holds the IAM policies applied for a Role at one time. It is never changed once recorded.
+// +kubebuilder:validation:XValidation:rule="self == oldSelf",message="RoleRevisions are immutable"
+type RoleRevisionSpec struct {
+	// Number of the revision, increasing with each change to the applied policies of the Role
+	Revision int64 `json:"revision"`
+
+	// Name of the IAM role the policies were applied to
+	RoleName string `json:"roleName"`
+
+	// Generation of the Role the policies were rendered from
+	RoleGeneration int64 `json:"roleGeneration"`
+
+	// Trust (assume role) policy document
+	TrustPolicy string `json:"trustPolicy"`
+
+	// Inline policy documents, by policy name
+	// +optional
+	InlinePolicies map[string]string `json:"inlinePolicies,omitempty"`
+
+	// Customer managed policy documents created for the statements which did not fit inline, by policy name
+	// +optional
+	ManagedPolicies map[string]string `json:"managedPolicies,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:printcolumn:name="Role",type=string,JSONPath=`.metadata.labels.eks-iam-operator\.neilmcgibbon\.com/role`
+//+kubebuilder:printcolumn:name="Revision",type=integer,JSONPath=`.spec.revision`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// RoleRevision records the IAM policies applied for a Role, created by the operator each time they change. The
+// latest revisions of each Role are kept, and deleted with the Role.
+type RoleRevision struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec RoleRevisionSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RoleRevisionList contains a list of RoleRevision
+type RoleRevisionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RoleRevision `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RoleRevision{}, &RoleRevisionList{})
+}
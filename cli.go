@@ -0,0 +1,85 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// Exit codes of the CLI subcommands
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// loadCLIConfig loads and validates the operator config file for a CLI subcommand. OIDC discovery is not run, so
+// the OIDC values the Roles need must be set in the file.
+func loadCLIConfig(path string) (eksiamoperatorv1beta1.Config, error) {
+	if len(path) == 0 {
+		return eksiamoperatorv1beta1.Config{}, errors.New("-config must be set")
+	}
+
+	ctrlConfig, _, err := loadConfig(path)
+	if err != nil {
+		return ctrlConfig, err
+	}
+	return ctrlConfig, validateConfig(ctrlConfig)
+}
+
+// readRoleManifests reads the Roles from YAML (or JSON) files, each of which may contain multiple documents.
+// Documents of any other kind are skipped, and a path of "-" reads from stdin.
+func readRoleManifests(paths []string) ([]eksiamoperatorv1beta1.Role, error) {
+	roles := []eksiamoperatorv1beta1.Role{}
+	for _, path := range paths {
+		var in io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			in = f
+		}
+
+		decoder := yaml.NewYAMLOrJSONDecoder(in, 4096)
+		for {
+			var role eksiamoperatorv1beta1.Role
+			if err := decoder.Decode(&role); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+
+			if role.Kind != "Role" || role.APIVersion != eksiamoperatorv1beta1.GroupVersion.String() {
+				continue
+			}
+			if len(role.Namespace) == 0 {
+				role.Namespace = "default"
+			}
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
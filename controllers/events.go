@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// Event reasons recorded against Roles
+const (
+	eventReasonRoleCreated          = "RoleCreated"
+	eventReasonRoleDeleted          = "RoleDeleted"
+	eventReasonTrustPolicyUpdated   = "TrustPolicyUpdated"
+	eventReasonRoleTagged           = "RoleTagged"
+	eventReasonInlinePolicyAdded    = "InlinePolicyAdded"
+	eventReasonInlinePolicyUpdated  = "InlinePolicyUpdated"
+	eventReasonInlinePolicyRemoved  = "InlinePolicyRemoved"
+	eventReasonManagedPolicyAdded   = "ManagedPolicyAdded"
+	eventReasonManagedPolicyUpdated = "ManagedPolicyUpdated"
+	eventReasonManagedPolicyRemoved = "ManagedPolicyRemoved"
+	eventReasonOwnershipConflict    = "OwnershipConflict"
+	eventReasonThrottled            = "Throttled"
+	eventReasonValidationFailed     = "ValidationFailed"
+	eventReasonUnknownActions       = "UnknownActions"
+	eventReasonBroadPermissions     = "BroadPermissions"
+	eventReasonSyncFailed           = "SyncFailed"
+	eventReasonServiceAccountMoved  = "ServiceAccountUpdated"
+	eventReasonRoleRenamed          = "RoleRenamed"
+	eventReasonRolledBack           = "RolledBack"
+	eventReasonRollbackFailed       = "RollbackFailed"
+	eventReasonRevisionRecorded     = "RevisionRecorded"
+	eventReasonRolledBackToRevision = "RolledBackToRevision"
+	eventReasonChangesPlanned       = "ChangesPlanned"
+)
+
+// validationError is returned when a Role spec (or the operator config it depends on) cannot be rendered into
+// valid IAM policies
+type validationError struct {
+	err error
+}
+
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *validationError) Unwrap() error {
+	return e.err
+}
+
+// newValidationError returns a validationError with a formatted message
+func newValidationError(format string, a ...interface{}) error {
+	return &validationError{err: fmt.Errorf(format, a...)}
+}
+
+// recordUpsertEvents records a Normal event for each change made to the IAM role
+func (r *RoleReconciler) recordUpsertEvents(role *eksiamoperatorv1beta1.Role, name string, result *internal.UpsertResult) {
+	if result.Created {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRoleCreated, "Created IAM role %s", name)
+	}
+	if result.TrustPolicyUpdated {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonTrustPolicyUpdated, "Updated trust policy of IAM role %s", name)
+	}
+	if result.TagsUpdated {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRoleTagged, "Updated tags of IAM role %s", name)
+	}
+	for _, v := range result.PoliciesAdded {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonInlinePolicyAdded, "Added inline policy %s to IAM role %s", v, name)
+	}
+	for _, v := range result.PoliciesUpdated {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonInlinePolicyUpdated, "Updated inline policy %s of IAM role %s", v, name)
+	}
+	for _, v := range result.PoliciesDeleted {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonInlinePolicyRemoved, "Removed inline policy %s from IAM role %s", v, name)
+	}
+}
+
+// recordManagedPolicyEvents records a Normal event for each change made to the customer managed policies of an IAM
+// role
+func (r *RoleReconciler) recordManagedPolicyEvents(role *eksiamoperatorv1beta1.Role, name string, result *internal.ManagedPolicyResult) {
+	for _, v := range result.Created {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonManagedPolicyAdded, "Added managed policy %s to IAM role %s", v, name)
+	}
+	for _, v := range result.Updated {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonManagedPolicyUpdated, "Updated managed policy %s of IAM role %s", v, name)
+	}
+	for _, v := range result.Deleted {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonManagedPolicyRemoved, "Removed managed policy %s from IAM role %s", v, name)
+	}
+}
+
+// recordFailureEvent records a Warning event for a failed reconcile, with a reason describing the kind of failure
+func (r *RoleReconciler) recordFailureEvent(role *eksiamoperatorv1beta1.Role, err error) {
+	var invalid *validationError
+
+	reason := eventReasonSyncFailed
+	switch {
+	case internal.IsOwnershipError(err):
+		reason = eventReasonOwnershipConflict
+	case internal.IsThrottlingError(err):
+		reason = eventReasonThrottled
+	case errors.As(err, &invalid):
+		reason = eventReasonValidationFailed
+	}
+
+	r.Recorder.Event(role, corev1.EventTypeWarning, reason, err.Error())
+}
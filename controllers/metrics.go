@@ -0,0 +1,183 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const (
+	// IAM limits on policy document sizes, in characters (excluding whitespace)
+	trustPolicySizeLimit   = 2048
+	inlinePolicySizeLimit  = 10240
+	managedPolicySizeLimit = 6144
+
+	// IAM limits on the number of managed policies attached to a role, and the length of a managed policy name
+	managedPoliciesPerRoleLimit = 10
+	managedPolicyNameLimit      = 128
+)
+
+var (
+	rolesBySyncState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eks_iam_operator_roles",
+		Help: "Number of Roles, by sync state",
+	}, []string{"state"})
+
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eks_iam_operator_reconcile_duration_seconds",
+		Help:    "Duration of Role reconciles, by outcome (success, retry or terminal)",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	driftRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eks_iam_operator_drift_repairs_total",
+		Help: "Number of changes made to IAM roles whose Role spec had already been applied, by resource",
+	}, []string{"resource"})
+
+	policySizeRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eks_iam_operator_policy_size_ratio",
+		Help: "Size of the rendered policies of a Role relative to the IAM limit, by policy type (trust or inline)",
+	}, []string{"namespace", "role", "policy"})
+
+	policyLintFindings = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "eks_iam_operator_policy_lint_findings",
+		Help: "Number of overly broad permissions found in the rendered policies of a Role, by lint rule",
+	}, []string{"namespace", "role", "rule"})
+
+	shardReplicas = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "eks_iam_operator_shard_replicas",
+		Help: "Number of replicas sharing the Roles, as seen by this replica, when sharding is enabled",
+	})
+
+	orphanedRoles = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "eks_iam_operator_orphaned_roles",
+		Help: "Number of IAM roles owned by the operator for this cluster whose Role no longer exists",
+	})
+
+	orphanedRolesDeleted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eks_iam_operator_orphaned_roles_deleted_total",
+		Help: "Number of orphaned IAM roles deleted by garbage collection",
+	})
+
+	garbageCollectionErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "eks_iam_operator_garbage_collection_errors_total",
+		Help: "Number of failed garbage collection runs",
+	})
+)
+
+func init() {
+	metrics.Registry.MustRegister(rolesBySyncState, reconcileDuration, driftRepairs, policySizeRatio, policyLintFindings, shardReplicas, orphanedRoles, orphanedRolesDeleted, garbageCollectionErrors)
+}
+
+// roleStates tracks the sync state of every Role, to report the number of Roles in each state
+var roleStates = &syncStateTracker{states: map[types.NamespacedName]eksiamoperatorv1beta1.SyncState{}}
+
+type syncStateTracker struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]eksiamoperatorv1beta1.SyncState
+}
+
+// set records the sync state of a Role
+func (t *syncStateTracker) set(role types.NamespacedName, state eksiamoperatorv1beta1.SyncState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.states[role] = state
+	t.publish()
+}
+
+// delete stops tracking a deleted Role
+func (t *syncStateTracker) delete(role types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, role)
+	t.publish()
+}
+
+func (t *syncStateTracker) publish() {
+	counts := map[eksiamoperatorv1beta1.SyncState]float64{
+		eksiamoperatorv1beta1.SyncStateOK:          0,
+		eksiamoperatorv1beta1.SyncStateErr:         0,
+		eksiamoperatorv1beta1.SyncStatePropagating: 0,
+	}
+	for _, v := range t.states {
+		counts[v]++
+	}
+	for state, count := range counts {
+		rolesBySyncState.WithLabelValues(string(state)).Set(count)
+	}
+}
+
+// observeReconcile records the duration and outcome of a reconcile
+func observeReconcile(start time.Time, outcome string) {
+	reconcileDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
+
+// observeDriftRepairs records the changes made to an IAM role which should already have been in sync
+func observeDriftRepairs(result *internal.UpsertResult, managed *internal.ManagedPolicyResult) {
+	if result.Created {
+		driftRepairs.WithLabelValues("role").Inc()
+	}
+	if result.TrustPolicyUpdated {
+		driftRepairs.WithLabelValues("trust_policy").Inc()
+	}
+	if result.TagsUpdated {
+		driftRepairs.WithLabelValues("tags").Inc()
+	}
+	if n := len(result.PoliciesAdded) + len(result.PoliciesUpdated) + len(result.PoliciesDeleted); n > 0 {
+		driftRepairs.WithLabelValues("inline_policy").Add(float64(n))
+	}
+	if n := len(managed.Created) + len(managed.Updated) + len(managed.Deleted); n > 0 {
+		driftRepairs.WithLabelValues("managed_policy").Add(float64(n))
+	}
+}
+
+// observePolicySizes records the size of the rendered trust policy and the total size of the inline policies,
+// relative to the IAM limits
+func observePolicySizes(role types.NamespacedName, trustPolicy string, inlinePolicies map[string]string) {
+	inline := 0
+	for _, v := range inlinePolicies {
+		inline += len(v)
+	}
+	policySizeRatio.WithLabelValues(role.Namespace, role.Name, "trust").Set(float64(len(trustPolicy)) / trustPolicySizeLimit)
+	policySizeRatio.WithLabelValues(role.Namespace, role.Name, "inline").Set(float64(inline) / inlinePolicySizeLimit)
+}
+
+// observePolicyFindings records the number of policy lint findings of a Role for every rule
+func observePolicyFindings(role types.NamespacedName, findings []internal.LintFinding) {
+	for rule, count := range internal.LintRuleCounts(findings) {
+		policyLintFindings.WithLabelValues(role.Namespace, role.Name, rule).Set(float64(count))
+	}
+}
+
+// forgetRoleMetrics removes the metrics of a deleted Role
+func forgetRoleMetrics(role types.NamespacedName) {
+	roleStates.delete(role)
+	policySizeRatio.DeleteLabelValues(role.Namespace, role.Name, "trust")
+	policySizeRatio.DeleteLabelValues(role.Namespace, role.Name, "inline")
+	for _, rule := range internal.LintRules {
+		policyLintFindings.DeleteLabelValues(role.Namespace, role.Name, rule)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// ErrInvalidConfig is wrapped by the errors of a ConfigApplier for settings which are invalid, rather than failing to
+// be applied, so are not retried until the OperatorConfig changes
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ConfigApplier validates the settings of the operator and applies them while the operator runs
+type ConfigApplier func(ctx context.Context, spec eksiamoperatorv1beta1.ConfigSpec) error
+
+// OperatorConfigReconciler applies the spec of the named OperatorConfig whenever it changes, and the settings of the
+// config file when it is deleted
+type OperatorConfigReconciler struct {
+	client.Client
+	Log logr.Logger
+
+	// Name of the OperatorConfig applied by the operator
+	Name string
+
+	// Settings applied when the OperatorConfig does not exist, read from the config file
+	Default eksiamoperatorv1beta1.ConfigSpec
+
+	// Settings in effect when the operator started
+	Initial eksiamoperatorv1beta1.ConfigSpec
+
+	Apply ConfigApplier
+
+	// Settings last applied, so that status-only changes are not applied again
+	applied *eksiamoperatorv1beta1.ConfigSpec
+}
+
+//+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=operatorconfigs,verbs=get;list;watch
+//+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=operatorconfigs/status,verbs=get;update;patch
+
+// Reconcile applies the spec of the OperatorConfig (or the config file settings when it does not exist), and
+// reports the outcome in its status. Invalid settings are rejected, keeping the settings last applied.
+func (r *OperatorConfigReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var config eksiamoperatorv1beta1.OperatorConfig
+	exists := true
+	if err := r.Get(ctx, req.NamespacedName, &config); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, err
+		}
+		exists = false
+	}
+
+	spec := r.Default
+	if exists {
+		spec = config.Spec
+	}
+
+	var applyErr error
+	if !reflect.DeepEqual(&spec, r.applied) {
+		if applyErr = r.Apply(ctx, spec); applyErr == nil {
+			r.applied = spec.DeepCopy()
+			r.Log.Info("Applied operator config", "operatorConfig", req.Name, "fromFile", !exists)
+		} else {
+			r.Log.Error(applyErr, "Unable to apply operator config", "operatorConfig", req.Name, "fromFile", !exists)
+		}
+	}
+
+	if exists {
+		config.Status.ObservedGeneration = config.Generation
+		switch {
+		case applyErr == nil:
+			setConfigCondition(&config, metav1.ConditionTrue, eksiamoperatorv1beta1.ReasonConfigApplied, "Operator config applied")
+		case errors.Is(applyErr, ErrInvalidConfig):
+			setConfigCondition(&config, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonValidationFailed, applyErr.Error())
+		default:
+			setConfigCondition(&config, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonRetryableError, applyErr.Error())
+		}
+		if err := r.Status().Update(ctx, &config); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if applyErr != nil && !errors.Is(applyErr, ErrInvalidConfig) {
+		return ctrl.Result{}, applyErr
+	}
+	return ctrl.Result{}, nil
+}
+
+// setConfigCondition sets the Ready condition of an OperatorConfig
+func setConfigCondition(config *eksiamoperatorv1beta1.OperatorConfig, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&config.Status.Conditions, metav1.Condition{
+		Type:               eksiamoperatorv1beta1.ConditionTypeReady,
+		Status:             status,
+		ObservedGeneration: config.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager, watching the named OperatorConfig only
+func (r *OperatorConfigReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.applied = r.Initial.DeepCopy()
+
+	named := predicate.NewPredicateFuncs(func(o client.Object) bool { return o.GetName() == r.Name })
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&eksiamoperatorv1beta1.OperatorConfig{}, builder.WithPredicates(named, predicate.GenerationChangedPredicate{})).
+		Complete(r)
+}
+
+// Reconfigure replaces the settings of the reconciler with those of another (built from changed operator settings),
+// and reconciles again every Role whose IAM role or dry-run mode is changed by the new settings
+func (r *RoleReconciler) Reconfigure(ctx context.Context, next *RoleReconciler) error {
+	var roles eksiamoperatorv1beta1.RoleList
+	if err := r.List(ctx, &roles); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	affected := []client.Object{}
+	for i := range roles.Items {
+		if r.settingsChangeRole(next, &roles.Items[i]) {
+			affected = append(affected, &roles.Items[i])
+		}
+	}
+
+	r.RolePrefix, r.RoleSuffix = next.RolePrefix, next.RoleSuffix
+	r.InlinePolicyPrefix, r.InlinePolicySuffix = next.InlinePolicyPrefix, next.InlinePolicySuffix
+	r.OIDCIssuerURL, r.OIDCProviderARN, r.OIDCAudiences = next.OIDCIssuerURL, next.OIDCProviderARN, next.OIDCAudiences
+	r.AdditionalOIDCProviders = next.AdditionalOIDCProviders
+	r.RoleRenameGracePeriod = next.RoleRenameGracePeriod
+	r.PropagationSettlePeriod = next.PropagationSettlePeriod
+	r.ResyncInterval = next.ResyncInterval
+	r.RevisionHistoryLimit = next.RevisionHistoryLimit
+	r.ClusterName, r.IdentityMode = next.ClusterName, next.IdentityMode
+	r.DryRun = next.DryRun
+	r.ActionCatalog, r.StrictActionValidation = next.ActionCatalog, next.StrictActionValidation
+	r.PolicyLint, r.BlockPolicyFindings, r.IgnoredLintRules = next.PolicyLint, next.BlockPolicyFindings, next.IgnoredLintRules
+	r.mu.Unlock()
+
+	r.Log.Info("Reconciling Roles affected by the operator config change", "roles", len(affected))
+	for _, role := range affected {
+		select {
+		case r.resync <- event.GenericEvent{Object: role}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// settingsChangeRole returns true if the IAM role rendered for a Role (or the error rendering it), or whether it
+// is reconciled in dry-run mode, differs between the settings of two reconcilers
+func (r *RoleReconciler) settingsChangeRole(next *RoleReconciler, role *eksiamoperatorv1beta1.Role) bool {
+	if r.dryRun(role) != next.dryRun(role) {
+		return true
+	}
+
+	current, currentErr := r.Render(role)
+	desired, desiredErr := next.Render(role)
+	if currentErr != nil || desiredErr != nil {
+		return fmt.Sprint(currentErr) != fmt.Sprint(desiredErr)
+	}
+	if !reflect.DeepEqual(current, desired) {
+		return true
+	}
+	return (r.CheckPolicyFindings(current) == nil) != (next.CheckPolicyFindings(desired) == nil)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// newConfigClient returns a fake client holding the given objects
+func newConfigClient(t *testing.T, objects ...client.Object) client.Client {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := eksiamoperatorv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+// configTestRole returns a Role trusting a service account, with the given audiences
+func configTestRole(name string, audiences ...string) *eksiamoperatorv1beta1.Role {
+	return &eksiamoperatorv1beta1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		Spec:       eksiamoperatorv1beta1.RoleSpec{Namespace: "default", ServiceAccounts: []string{name}, Audiences: audiences},
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	settings := func() *RoleReconciler {
+		return &RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: testIssuer, OIDCProviderARN: testProviderARN, OIDCAudiences: []string{"sts.amazonaws.com"}}
+	}
+
+	tests := []struct {
+		name     string
+		change   func(next *RoleReconciler)
+		expected []string
+	}{
+		{name: "unchanged", change: func(next *RoleReconciler) {}, expected: []string{}},
+		{name: "prefix", change: func(next *RoleReconciler) { next.RolePrefix = "cluster-" }, expected: []string{"default-audience", "own-audience"}},
+		// Roles setting their own audiences are not changed by the default audiences
+		{name: "audiences", change: func(next *RoleReconciler) { next.OIDCAudiences = []string{"vault"} }, expected: []string{"default-audience"}},
+		{name: "dry run", change: func(next *RoleReconciler) { next.DryRun = true }, expected: []string{"default-audience", "own-audience"}},
+		// Settings which do not change the rendered IAM roles do not reconcile Roles
+		{name: "resync interval", change: func(next *RoleReconciler) { next.ResyncInterval = 1 }, expected: []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := settings()
+			r.Client = newConfigClient(t, configTestRole("default-audience"), configTestRole("own-audience", "vault"))
+			r.Log = logr.Discard()
+			r.resync = make(chan event.GenericEvent, 10)
+
+			next := settings()
+			tt.change(next)
+			if err := r.Reconfigure(context.Background(), next); err != nil {
+				t.Fatal(err)
+			}
+
+			resynced := []string{}
+			for len(r.resync) > 0 {
+				resynced = append(resynced, (<-r.resync).Object.GetName())
+			}
+			sort.Strings(resynced)
+			if fmt.Sprint(resynced) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected Roles %v to be reconciled, got %v", tt.expected, resynced)
+			}
+
+			// The new settings are applied
+			if r.RolePrefix != next.RolePrefix || fmt.Sprint(r.OIDCAudiences) != fmt.Sprint(next.OIDCAudiences) || r.DryRun != next.DryRun || r.ResyncInterval != next.ResyncInterval {
+				t.Fatalf("expected the new settings to be applied, got %+v", r)
+			}
+		})
+	}
+}
+
+func TestOperatorConfigReconcile(t *testing.T) {
+	tests := []struct {
+		name     string
+		applyErr error
+		status   metav1.ConditionStatus
+		reason   string
+		fails    bool
+	}{
+		{name: "applied", status: metav1.ConditionTrue, reason: eksiamoperatorv1beta1.ReasonConfigApplied},
+		// Invalid settings are not retried until the OperatorConfig changes
+		{name: "invalid", applyErr: fmt.Errorf("%w: oidc.providerArn must be set", ErrInvalidConfig), status: metav1.ConditionFalse, reason: eksiamoperatorv1beta1.ReasonValidationFailed},
+		{name: "failed", applyErr: errors.New("unable to reach EKS"), status: metav1.ConditionFalse, reason: eksiamoperatorv1beta1.ReasonRetryableError, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			config := &eksiamoperatorv1beta1.OperatorConfig{ObjectMeta: metav1.ObjectMeta{Name: "default", Generation: 2}}
+			applied := 0
+			r := &OperatorConfigReconciler{
+				Client: newConfigClient(t, config),
+				Log:    logr.Discard(),
+				Name:   "default",
+				Apply: func(ctx context.Context, spec eksiamoperatorv1beta1.ConfigSpec) error {
+					applied++
+					return tt.applyErr
+				},
+			}
+
+			result, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "default"}})
+			if (err != nil) != tt.fails {
+				t.Fatalf("expected an error: %t, got %v", tt.fails, err)
+			}
+			if result.Requeue || result.RequeueAfter > 0 {
+				t.Fatalf("expected no requeue, got %+v", result)
+			}
+
+			if err := r.Get(ctx, client.ObjectKey{Name: "default"}, config); err != nil {
+				t.Fatal(err)
+			}
+			ready := meta.FindStatusCondition(config.Status.Conditions, eksiamoperatorv1beta1.ConditionTypeReady)
+			if ready == nil || ready.Status != tt.status || ready.Reason != tt.reason {
+				t.Fatalf("expected Ready=%s with reason %s, got %+v", tt.status, tt.reason, ready)
+			}
+
+			// Settings are applied again after failing, but not once applied
+			if _, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKey{Name: "default"}}); (err != nil) != tt.fails {
+				t.Fatalf("expected an error: %t, got %v", tt.fails, err)
+			}
+			if expected := map[bool]int{true: 1, false: 2}[tt.applyErr == nil]; applied != expected {
+				t.Fatalf("expected the settings to be applied %d times, got %d", expected, applied)
+			}
+		})
+	}
+}
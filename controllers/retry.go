@@ -0,0 +1,143 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const (
+	// Initial requeue delay after a retryable error, doubled for each consecutive failure
+	retryBaseDelay = 5 * time.Second
+
+	// Initial requeue delay after being throttled by AWS, doubled for each consecutive failure
+	throttledBaseDelay = 30 * time.Second
+
+	// Maximum requeue delay after a retryable error
+	retryMaxDelay = 10 * time.Minute
+)
+
+// roleFailures tracks the number of consecutive retryable failures of each Role, to calculate backoff
+var roleFailures = &failureTracker{counts: map[types.NamespacedName]int{}}
+
+type failureTracker struct {
+	mu     sync.Mutex
+	counts map[types.NamespacedName]int
+}
+
+// inc increments the consecutive failures of a Role, returning the new count
+func (t *failureTracker) inc(role types.NamespacedName) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[role]++
+	return t.counts[role]
+}
+
+// reset clears the consecutive failures of a Role
+func (t *failureTracker) reset(role types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.counts, role)
+}
+
+// finalizerError is returned when the IAM role of a deleted Role cannot be cleaned up. It is always retried, as the
+// spec of a deleted Role cannot change to resolve it, and the Role would otherwise be left terminating.
+type finalizerError struct {
+	err error
+}
+
+func (e *finalizerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *finalizerError) Unwrap() error {
+	return e.err
+}
+
+// classifyError returns the condition reason for a reconcile error, and whether the error is terminal, i.e. it
+// cannot be resolved by retrying until the Role spec changes
+func classifyError(err error) (string, bool) {
+	var invalid *validationError
+	var finalizing *finalizerError
+
+	switch {
+	case errors.As(err, &finalizing):
+		reason, _ := classifyError(finalizing.err)
+		return reason, false
+	case errors.As(err, &invalid):
+		return eksiamoperatorv1beta1.ReasonValidationFailed, true
+	case internal.IsServerError(err):
+		// AWS failing to handle a request is always retried, whatever the error code
+		return eksiamoperatorv1beta1.ReasonRetryableError, false
+	case internal.IsTerminalError(err):
+		return eksiamoperatorv1beta1.ReasonTerminalError, true
+	case internal.IsThrottlingError(err):
+		return eksiamoperatorv1beta1.ReasonThrottled, false
+	default:
+		// Any error we do not recognise (e.g. network or Kubernetes API errors) is retried
+		return eksiamoperatorv1beta1.ReasonRetryableError, false
+	}
+}
+
+// handleReconcileError converts the outcome of a reconcile into the result returned to controller-runtime.
+// Terminal errors are not requeued (a spec change triggers a new reconcile), and retryable errors are requeued
+// with a jittered exponential backoff, rather than relying on the default rate limiter.
+func handleReconcileError(role types.NamespacedName, start time.Time, result ctrl.Result, err error) (ctrl.Result, error) {
+	if err == nil {
+		roleFailures.reset(role)
+		observeReconcile(start, "success")
+		return result, nil
+	}
+
+	reason, terminal := classifyError(err)
+	if terminal {
+		roleFailures.reset(role)
+		observeReconcile(start, "terminal")
+		return ctrl.Result{}, nil
+	}
+
+	base := retryBaseDelay
+	if reason == eksiamoperatorv1beta1.ReasonThrottled {
+		base = throttledBaseDelay
+	}
+
+	observeReconcile(start, "retry")
+	return ctrl.Result{RequeueAfter: backoff(base, roleFailures.inc(role))}, nil
+}
+
+// backoff returns the delay before the next attempt after a number of consecutive failures, doubling the base
+// delay for each failure up to the maximum, with up to 50% random jitter added
+func backoff(base time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 1; i < failures && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
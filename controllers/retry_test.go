@@ -0,0 +1,62 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+func TestClassifyError(t *testing.T) {
+	accessDenied := &smithy.GenericAPIError{Code: "AccessDenied"}
+	serverError := &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: http.StatusInternalServerError}},
+		Err:      &smithy.GenericAPIError{Code: "InvalidInput"},
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		reason   string
+		terminal bool
+	}{
+		{name: "validation", err: newValidationError("invalid"), reason: eksiamoperatorv1beta1.ReasonValidationFailed, terminal: true},
+		{name: "terminal AWS error", err: accessDenied, reason: eksiamoperatorv1beta1.ReasonTerminalError, terminal: true},
+		{name: "not owned", err: &internal.OwnershipError{Resource: "IAM role", Name: "app"}, reason: eksiamoperatorv1beta1.ReasonTerminalError, terminal: true},
+		{name: "throttled", err: &smithy.GenericAPIError{Code: "Throttling"}, reason: eksiamoperatorv1beta1.ReasonThrottled},
+		{name: "server error", err: serverError, reason: eksiamoperatorv1beta1.ReasonRetryableError},
+		{name: "unknown", err: errors.New("connection reset"), reason: eksiamoperatorv1beta1.ReasonRetryableError},
+		{name: "finalizer", err: &finalizerError{err: accessDenied}, reason: eksiamoperatorv1beta1.ReasonTerminalError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, terminal := classifyError(tt.err)
+			if reason != tt.reason || terminal != tt.terminal {
+				t.Fatalf("expected %s (terminal %t), got %s (terminal %t)", tt.reason, tt.terminal, reason, terminal)
+			}
+		})
+	}
+}
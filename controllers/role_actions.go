@@ -0,0 +1,134 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// actionProblems checks the actions of a Role's statements against the action catalog, returning a description of
+// each unknown action, and of each other problem found, in statement group order
+func (r *RoleReconciler) actionProblems(role *eksiamoperatorv1beta1.Role) (unknown []string, problems []string) {
+	if r.ActionCatalog == nil {
+		return nil, nil
+	}
+
+	groups := make([]string, 0, len(role.Spec.Statements))
+	for group := range role.Spec.Statements {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	seen := map[string]bool{}
+	for _, group := range groups {
+		for _, stmt := range role.Spec.Statements[group] {
+			for _, action := range stmt.Actions {
+				if seen[action] {
+					continue
+				}
+				seen[action] = true
+
+				problem, isUnknown := r.ActionCatalog.ValidateAction(action)
+				if len(problem) == 0 {
+					continue
+				}
+				if isUnknown {
+					unknown = append(unknown, fmt.Sprintf("statements %s: %s", group, problem))
+				} else {
+					problems = append(problems, fmt.Sprintf("statements %s: %s", group, problem))
+				}
+			}
+		}
+	}
+	return unknown, problems
+}
+
+// validateActions returns the problems with the actions of a Role's statements as warnings, or a validation error
+// when any action is unknown in strict mode
+func (r *RoleReconciler) validateActions(role *eksiamoperatorv1beta1.Role) ([]string, error) {
+	unknown, problems := r.actionProblems(role)
+	if len(unknown) > 0 && r.StrictActionValidation {
+		return nil, newValidationError("unknown IAM actions: %s", strings.Join(unknown, "; "))
+	}
+	return append(unknown, problems...), nil
+}
+
+// expandActions returns the statements with the action wildcards of those with expandActions set replaced by the
+// catalogued actions they match. A wildcard which cannot be expanded (action validation is disabled, the actions of
+// its service are not catalogued, or it matches no action) is a validation error, as granting it unexpanded would
+// grant more than the Role asks for.
+func (r *RoleReconciler) expandActions(statements map[string][]eksiamoperatorv1beta1.StatementSpec) (map[string][]eksiamoperatorv1beta1.StatementSpec, error) {
+	expanded := make(map[string][]eksiamoperatorv1beta1.StatementSpec, len(statements))
+	for group, stmts := range statements {
+		for _, stmt := range stmts {
+			if stmt.ExpandActions {
+				actions := []string{}
+				for _, action := range stmt.Actions {
+					if !strings.ContainsAny(action, "*?") {
+						actions = append(actions, action)
+						continue
+					}
+					if r.ActionCatalog == nil {
+						return nil, newValidationError("statements %s: cannot expand %s, action validation is disabled", group, action)
+					}
+					matched, ok := r.ActionCatalog.Expand(action)
+					if !ok {
+						return nil, newValidationError("statements %s: cannot expand %s, the actions of its service are not catalogued", group, action)
+					}
+					if len(matched) == 0 {
+						return nil, newValidationError("statements %s: cannot expand %s, it does not match any action", group, action)
+					}
+					actions = append(actions, matched...)
+				}
+				stmt.Actions = actions
+			}
+			expanded[group] = append(expanded[group], stmt)
+		}
+	}
+	return expanded, nil
+}
+
+// setActionsCondition sets the ActionsValid condition of a Role from the problems with the actions of its statements,
+// recording a Warning event when unknown actions are first found for the generation. The condition is not set when
+// action validation is disabled.
+func (r *RoleReconciler) setActionsCondition(role *eksiamoperatorv1beta1.Role) {
+	if r.ActionCatalog == nil {
+		meta.RemoveStatusCondition(&role.Status.Conditions, eksiamoperatorv1beta1.ConditionTypeActionsValid)
+		return
+	}
+
+	unknown, problems := r.actionProblems(role)
+	if len(unknown) == 0 {
+		setCondition(role, eksiamoperatorv1beta1.ConditionTypeActionsValid, metav1.ConditionTrue, eksiamoperatorv1beta1.ReasonActionsKnown, strings.Join(problems, "; "))
+		return
+	}
+
+	message := strings.Join(append(unknown, problems...), "; ")
+	previous := meta.FindStatusCondition(role.Status.Conditions, eksiamoperatorv1beta1.ConditionTypeActionsValid)
+	if previous == nil || previous.Status != metav1.ConditionFalse || previous.ObservedGeneration != role.Generation {
+		r.Recorder.Event(role, corev1.EventTypeWarning, eventReasonUnknownActions, message)
+	}
+	setCondition(role, eksiamoperatorv1beta1.ConditionTypeActionsValid, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonUnknownActions, message)
+}
@@ -20,15 +20,29 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
 
@@ -38,8 +52,9 @@ import (
 // RoleReconciler reconciles a Role object
 type RoleReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 
 	RolePrefix         string
 	RoleSuffix         string
@@ -47,11 +62,61 @@ type RoleReconciler struct {
 	InlinePolicySuffix string
 	OIDCIssuerURL      string
 	OIDCProviderARN    string
+	OIDCAudiences      []string
+
+	// Further cluster OIDC providers trusted by every role
+	AdditionalOIDCProviders []eksiamoperatorv1beta1.OIDCProvider
+
+	// How long a previously named IAM role is kept after a role name change
+	RoleRenameGracePeriod time.Duration
+
+	// How long a Role is Propagating, rather than Ready, after its IAM role changes
+	PropagationSettlePeriod time.Duration
+
+	// How often a Role is reconciled again to repair drift of its IAM role
+	ResyncInterval time.Duration
+
+	// Number of RoleRevisions kept for each Role
+	RevisionHistoryLimit int
+
+	// EKS cluster name and default identity mode, used for EKS Pod Identity
+	ClusterName  string
+	IdentityMode eksiamoperatorv1beta1.IdentityMode
+
+	// Plan the IAM changes for every Role in its status, without making them
+	DryRun bool
+
+	// Catalog the actions of Role statements are validated against (nil disables validation), and whether Roles
+	// with unknown actions are rejected
+	ActionCatalog          *internal.ActionCatalog
+	StrictActionValidation bool
+
+	// Whether the rendered policies are linted for overly broad permissions, whether Roles with findings are
+	// rejected, and the lint rules which are not reported
+	PolicyLint          bool
+	BlockPolicyFindings bool
+	IgnoredLintRules    []string
+
+	// Only Roles in namespaces whose labels match this selector are reconciled, when set
+	NamespaceSelector labels.Selector
+
+	// Only the Roles owned by this replica are reconciled, when sharding is enabled
+	Shards *RoleShards
+
+	// Guards the settings above (other than the namespace selector), which are replaced while the operator runs
+	// when the OperatorConfig changes. It is only held while the settings are copied, never across AWS calls
+	mu sync.RWMutex
+
+	// Roles to reconcile again after a change of settings
+	resync chan event.GenericEvent
 }
 
 //+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=roles,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=roles/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=roles/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",resources=serviceaccounts,verbs=get;list;watch;update;patch
+//+kubebuilder:rbac:urls=/.well-known/openid-configuration,verbs=get
+//+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -63,17 +128,77 @@ type RoleReconciler struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.12.2/pkg/reconcile
 func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	// Roles owned by another replica are left to it
+	if r.Shards != nil && !r.Shards.Owns(req.String()) {
+		return ctrl.Result{}, nil
+	}
+
+	start := time.Now()
+	result, err := r.snapshot().reconcile(ctx, req)
+	if err != nil {
+		r.Log.Error(err, "Reconcile failed", "namespace", req.Namespace, "name", req.Name)
+	}
+	return handleReconcileError(req.NamespacedName, start, result, err)
+}
+
+// snapshot returns a copy of the reconciler with its current settings, so a reconcile sees consistent settings
+// without holding the lock (and blocking a change of settings) while it calls AWS
+func (r *RoleReconciler) snapshot() *RoleReconciler {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return &RoleReconciler{
+		Client:                  r.Client,
+		Log:                     r.Log,
+		Scheme:                  r.Scheme,
+		Recorder:                r.Recorder,
+		RolePrefix:              r.RolePrefix,
+		RoleSuffix:              r.RoleSuffix,
+		InlinePolicyPrefix:      r.InlinePolicyPrefix,
+		InlinePolicySuffix:      r.InlinePolicySuffix,
+		OIDCIssuerURL:           r.OIDCIssuerURL,
+		OIDCProviderARN:         r.OIDCProviderARN,
+		OIDCAudiences:           r.OIDCAudiences,
+		AdditionalOIDCProviders: r.AdditionalOIDCProviders,
+		RoleRenameGracePeriod:   r.RoleRenameGracePeriod,
+		PropagationSettlePeriod: r.PropagationSettlePeriod,
+		ResyncInterval:          r.ResyncInterval,
+		RevisionHistoryLimit:    r.RevisionHistoryLimit,
+		ClusterName:             r.ClusterName,
+		IdentityMode:            r.IdentityMode,
+		DryRun:                  r.DryRun,
+		ActionCatalog:           r.ActionCatalog,
+		StrictActionValidation:  r.StrictActionValidation,
+		PolicyLint:              r.PolicyLint,
+		BlockPolicyFindings:     r.BlockPolicyFindings,
+		IgnoredLintRules:        r.IgnoredLintRules,
+		NamespaceSelector:       r.NamespaceSelector,
+		Shards:                  r.Shards,
+		resync:                  r.resync,
+	}
+}
+
+// reconcile creates, updates or deletes the IAM role (and related resources) for a Role
+func (r *RoleReconciler) reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var role eksiamoperatorv1beta1.Role
 	if err := r.Get(ctx, req.NamespacedName, &role); err != nil {
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
+	// Roles in namespaces out of scope are left for another instance of the operator
+	inScope, err := r.namespaceInScope(ctx, role.Namespace)
+	if err != nil || !inScope {
+		return ctrl.Result{}, err
+	}
+
 	// Generate role name from prefix, cluster, region and role
-	fullRoleName := fmt.Sprintf("%s%s%s", r.RolePrefix, role.Name, r.RoleSuffix)
+	fullRoleName := r.roleName(&role)
 	r.Log.Info("Reconciling role", "role", fullRoleName)
+	seedRoleName(&role, fullRoleName)
 
 	// Check if need to reconcile this
-	if role.Status.ObservedGeneration == role.ObjectMeta.Generation && role.Status.State == eksiamoperatorv1beta1.SyncStateOK {
+	inSync := role.Status.ObservedGeneration == role.ObjectMeta.Generation && role.Status.State == eksiamoperatorv1beta1.SyncStateOK
+	if inSync {
 		r.Log.Info("Role already reconciled, not doing anything", "role", fullRoleName)
 	}
 
@@ -97,33 +222,120 @@ func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		}
 	} else {
 		if controllerutil.ContainsFinalizer(&role, finalizer) {
-			if err := client.Delete(ctx, fullRoleName); err != nil {
+			if r.dryRun(&role) {
+				if err := r.planDelete(ctx, client, &role, fullRoleName); err != nil {
+					err = &finalizerError{err: err}
+					r.statusUpdater(ctx, &role, err)
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, nil
+			}
+
+			if err := r.deletePodIdentityAssociations(ctx, client, &role); err != nil {
+				err = &finalizerError{err: err}
 				r.statusUpdater(ctx, &role, err)
 				return ctrl.Result{}, err
 			}
 
+			for _, name := range managedRoleNames(&role, fullRoleName) {
+				err := client.Delete(ctx, name)
+				if internal.IsOwnershipError(err) {
+					// An IAM role of the same name which the operator did not create is left alone
+					r.Recorder.Eventf(&role, corev1.EventTypeWarning, eventReasonOwnershipConflict, "Not deleting IAM role %s as it does not have the operator owner tag", name)
+					continue
+				}
+				if err != nil {
+					err = &finalizerError{err: err}
+					r.statusUpdater(ctx, &role, err)
+					return ctrl.Result{}, err
+				}
+				r.Recorder.Eventf(&role, corev1.EventTypeNormal, eventReasonRoleDeleted, "Deleted IAM role %s", name)
+			}
+
 			// AWS Role is deleted, so now remove finalizer so Kubernets deletes the dead resource
 			controllerutil.RemoveFinalizer(&role, finalizer)
 			if err := r.Update(ctx, &role); err != nil {
 				return ctrl.Result{}, err
 			}
+			forgetRoleMetrics(req.NamespacedName)
 		}
 		return ctrl.Result{}, nil
 	}
 
-	trustPolicy, err := generateTrustPolicy(role.Spec.ServiceAccounts, role.Spec.Namespace, r.OIDCIssuerURL, r.OIDCProviderARN)
+	r.setActionsCondition(&role)
+
+	rendered, err := r.Render(&role)
 	if err != nil {
 		r.statusUpdater(ctx, &role, err)
 		return ctrl.Result{}, err
 	}
 
-	policies, err := r.generateInlinePolicies(role.Spec.Statements)
+	// A Role with the rollback annotation has the policies of an earlier revision applied, in place of its spec
+	if err := r.applyRollback(ctx, &role, rendered); err != nil {
+		r.statusUpdater(ctx, &role, err)
+		return ctrl.Result{}, err
+	}
+	trustPolicy, policies := rendered.TrustPolicy, rendered.InlinePolicies
+
+	observePolicySizes(req.NamespacedName, trustPolicy, policies)
+
+	r.setPolicyFindings(&role, rendered)
+	if err := r.CheckPolicyFindings(rendered); err != nil {
+		r.statusUpdater(ctx, &role, err)
+		return ctrl.Result{}, err
+	}
+
+	// In dry-run mode, record the changes in the status rather than making them
+	if r.dryRun(&role) {
+		if err := r.planUpsert(ctx, client, &role, rendered); err != nil {
+			r.statusUpdater(ctx, &role, err)
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: r.ResyncInterval}, nil
+	}
+
+	// Customer managed policies hold the statements which do not fit inline
+	upserted, err := client.Upsert(ctx, fullRoleName, trustPolicy, policies, rendered.ManagedPolicies, rendered.Tags)
+	if err != nil {
+		r.recordRollback(&role, fullRoleName, err)
+		r.statusUpdater(ctx, &role, err)
+		return ctrl.Result{}, err
+	}
+	managed := upserted.Managed
+	r.recordUpsertEvents(&role, fullRoleName, upserted)
+	r.recordManagedPolicyEvents(&role, fullRoleName, managed)
+	role.Status.ManagedPolicies = managed.ARNs
+
+	if upserted.Changed() {
+		recordIAMChange(&role)
+
+		// Changes to a role whose spec was already applied mean the IAM role drifted
+		if inSync {
+			r.Log.Info("Repaired drift in IAM role", "role", fullRoleName)
+			observeDriftRepairs(upserted, managed)
+		}
+	}
+
+	// If the role name has changed, move service accounts over to the new role and retire the old one
+	if err = r.migrateRenamedRole(ctx, &role, fullRoleName, upserted.ARN); err != nil {
+		r.statusUpdater(ctx, &role, err)
+		return ctrl.Result{}, err
+	}
+
+	// Associate the service accounts with the role when using EKS Pod Identity
+	if err = r.syncPodIdentityAssociations(ctx, client, &role); err != nil {
+		r.statusUpdater(ctx, &role, err)
+		return ctrl.Result{}, err
+	}
+
+	requeueAfter, err := r.deleteRetiredRoles(ctx, client, &role)
 	if err != nil {
 		r.statusUpdater(ctx, &role, err)
 		return ctrl.Result{}, err
 	}
 
-	if err = client.Upsert(ctx, fullRoleName, trustPolicy, policies); err != nil {
+	// Record the applied policies, so they can be inspected and rolled back to later
+	if err = r.recordRevision(ctx, &role, fullRoleName, rendered); err != nil {
 		r.statusUpdater(ctx, &role, err)
 		return ctrl.Result{}, err
 	}
@@ -132,14 +344,37 @@ func (r *RoleReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	//role.Status.ObservedGeneration = role.ObjectMeta.Generation
 
 	r.statusUpdater(ctx, &role, nil)
-	return ctrl.Result{}, nil
+
+	// Reconcile again once the IAM changes have settled, to report the Role Ready, and periodically to repair drift
+	requeueAfter = earliestRequeue(requeueAfter, r.propagationRemaining(&role))
+	return ctrl.Result{RequeueAfter: earliestRequeue(requeueAfter, r.ResyncInterval)}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager. ctx bounds the calls made while mapping watched objects
+// to Roles, so should be the context the Manager is started with.
+func (r *RoleReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("eks-iam-operator")
+	r.resync = make(chan event.GenericEvent)
+	if r.Shards != nil {
+		r.Shards.OnChange = r.resyncAll
+	}
+
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&eksiamoperatorv1beta1.Role{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&source.Channel{Source: r.resync}, &handler.EnqueueRequestForObject{})
+
+	// Roles come into (or out of) scope when the labels of their namespace change
+	if r.NamespaceSelector != nil {
+		b = b.Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(func(o client.Object) []reconcile.Request {
+			return r.namespaceRoles(ctx, o)
+		}), builder.WithPredicates(predicate.LabelChangedPredicate{}))
+	}
+	return b.Complete(r)
 }
 
-// SetupWithManager sets up the controller with the Manager.
-func (r *RoleReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&eksiamoperatorv1beta1.Role{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
-		Complete(r)
+// roleName returns the IAM role name for a Role, generated from the configured prefix and suffix
+func (r *RoleReconciler) roleName(role *eksiamoperatorv1beta1.Role) string {
+	return fmt.Sprintf("%s%s%s", r.RolePrefix, role.Name, r.RoleSuffix)
 }
 
 // stringOrArray takes an array and returns the value of the first element if the array has one item, otherwise
@@ -152,50 +387,268 @@ func stringOrArray(tst []string) interface{} {
 }
 
 // generateTrustPolicy returns a string representation of an AWS Assume Role policy, formatted specifically for
-// service accounts running in an EKS cluster
-func generateTrustPolicy(services []string, ns, oidcIssuerURL, oidcProviderARN string) (string, error) {
+// service accounts running in EKS clusters. Using IRSA, statements are generated for each trusted cluster OIDC
+// provider, and using EKS Pod Identity the EKS Pod Identity service is trusted. Any additional trust entries in
+// the Role spec are appended as further statements.
+func (r *RoleReconciler) generateTrustPolicy(role *eksiamoperatorv1beta1.Role) (string, error) {
+	doc := internal.NewAWSTrustPolicy()
 
-	// Create our StringLike condition for service name matches
-	sa := []string{}
-	for _, v := range services {
-		sa = append(sa, fmt.Sprintf("system:serviceaccount:%s:%s", ns, v))
+	if r.identityMode(role) == eksiamoperatorv1beta1.IdentityModePodIdentity {
+		doc.Statement = append(doc.Statement, internal.NewAWSPodIdentityTrustStatement())
+	} else {
+		if err := validateOIDCProviders(role.Spec.OIDCProviders); err != nil {
+			return "", err
+		}
+		providers := r.oidcProviders(role)
+		if len(providers) == 0 {
+			return "", newValidationError("no OIDC provider configured for the IRSA identity mode")
+		}
+
+		audiences := r.audiences(role)
+		for _, provider := range providers {
+			doc.Statement = append(doc.Statement, generateServiceAccountTrustStatements(role, provider, audiences)...)
+		}
 	}
 
-	doc := internal.NewAWSTrustPolicy()
-	doc.Statement[0].Principal["Federated"] = oidcProviderARN
-	doc.Statement[0].Condition["StringLike"] = map[string][]string{
-		fmt.Sprintf("%s:sub", strings.TrimPrefix(oidcIssuerURL, "https://")): sa,
+	for i, v := range role.Spec.AdditionalTrust {
+		stmt, err := generateAdditionalTrustStatement(v)
+		if err != nil {
+			return "", fmt.Errorf("additionalTrust[%d]: %w", i, err)
+		}
+		doc.Statement = append(doc.Statement, stmt)
+	}
+
+	// IAM rejects a trust policy without statements, and a role nothing can assume is of no use
+	if len(doc.Statement) == 0 {
+		return "", newValidationError("at least one service account or additionalTrust entry must be set")
 	}
 
 	j, err := json.Marshal(doc)
 	return string(j), err
 }
 
+// generateServiceAccountTrustStatements returns the web identity statements trusting the Role's service accounts
+// through a cluster OIDC provider. Service account names are matched exactly using StringEquals, unless they
+// contain a wildcard, in which case they are matched in a separate statement using StringLike.
+func generateServiceAccountTrustStatements(role *eksiamoperatorv1beta1.Role, provider eksiamoperatorv1beta1.OIDCProvider, audiences []string) []internal.AWSPolicyDocumentStatement {
+
+	// Split service account subjects into exact and wildcard matches
+	exact, wildcard := []string{}, []string{}
+	for _, v := range role.Spec.ServiceAccounts {
+		sub := fmt.Sprintf("system:serviceaccount:%s:%s", role.Spec.Namespace, v)
+		if strings.ContainsAny(v, "*?") {
+			wildcard = append(wildcard, sub)
+		} else {
+			exact = append(exact, sub)
+		}
+	}
+
+	issuer := strings.TrimPrefix(provider.IssuerURL, "https://")
+
+	stmts := []internal.AWSPolicyDocumentStatement{}
+	if len(exact) > 0 {
+		stmt := internal.NewAWSWebIdentityTrustStatement(provider.ProviderARN)
+		stmt.Condition["StringEquals"] = map[string][]string{
+			fmt.Sprintf("%s:sub", issuer): exact,
+			fmt.Sprintf("%s:aud", issuer): audiences,
+		}
+		stmts = append(stmts, stmt)
+	}
+	if len(wildcard) > 0 {
+		stmt := internal.NewAWSWebIdentityTrustStatement(provider.ProviderARN)
+		stmt.Condition["StringLike"] = map[string][]string{
+			fmt.Sprintf("%s:sub", issuer): wildcard,
+		}
+		stmt.Condition["StringEquals"] = map[string][]string{
+			fmt.Sprintf("%s:aud", issuer): audiences,
+		}
+		stmts = append(stmts, stmt)
+	}
+
+	return stmts
+}
+
+// generateAdditionalTrustStatement returns the assume role statement for an additional trust entry, which must
+// have exactly one principal type
+func generateAdditionalTrustStatement(trust eksiamoperatorv1beta1.TrustSpec) (internal.AWSPolicyDocumentStatement, error) {
+	principals := 0
+	for _, set := range []bool{len(trust.AWS) > 0, len(trust.Services) > 0, len(trust.Federated) > 0} {
+		if set {
+			principals++
+		}
+	}
+	if principals != 1 {
+		return internal.AWSPolicyDocumentStatement{}, newValidationError("exactly one of aws, services or federated must be set")
+	}
+
+	var stmt internal.AWSPolicyDocumentStatement
+	switch {
+	case len(trust.AWS) > 0:
+		stmt = internal.NewAWSTrustStatement("AWS", stringOrArray(trust.AWS))
+	case len(trust.Services) > 0:
+		stmt = internal.NewAWSTrustStatement("Service", stringOrArray(trust.Services))
+	default:
+		stmt = internal.NewAWSWebIdentityTrustStatement(trust.Federated)
+	}
+
+	for op, cond := range trust.Conditions {
+		stmt.Condition[op] = cond
+	}
+
+	return stmt, nil
+}
+
+// oidcProviders returns every cluster OIDC provider trusted by a Role, i.e. the operator's own cluster, any
+// additional providers in the operator config and any providers in the Role spec. Duplicate providers are
+// only returned once.
+func (r *RoleReconciler) oidcProviders(role *eksiamoperatorv1beta1.Role) []eksiamoperatorv1beta1.OIDCProvider {
+	providers := []eksiamoperatorv1beta1.OIDCProvider{}
+	if len(r.OIDCProviderARN) > 0 {
+		providers = append(providers, eksiamoperatorv1beta1.OIDCProvider{ProviderARN: r.OIDCProviderARN, IssuerURL: r.OIDCIssuerURL})
+	}
+	providers = append(providers, r.AdditionalOIDCProviders...)
+	providers = append(providers, role.Spec.OIDCProviders...)
+
+	seen := map[string]bool{}
+	unique := []eksiamoperatorv1beta1.OIDCProvider{}
+	for _, v := range providers {
+		if seen[v.ProviderARN] {
+			continue
+		}
+		seen[v.ProviderARN] = true
+		unique = append(unique, v)
+	}
+	return unique
+}
+
+// validateOIDCProviders checks that each OIDC provider of a Role spec has an issuer URL, and a provider ARN
+// which is the ARN of an IAM OIDC provider
+func validateOIDCProviders(providers []eksiamoperatorv1beta1.OIDCProvider) error {
+	for i, v := range providers {
+		if len(v.IssuerURL) == 0 {
+			return newValidationError("oidcProviders[%d]: issuerUrl must be set", i)
+		}
+		parsed, err := arn.Parse(v.ProviderARN)
+		if err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "oidc-provider/") {
+			return newValidationError("oidcProviders[%d]: providerArn must be the ARN of an IAM OIDC provider, got %q", i, v.ProviderARN)
+		}
+	}
+	return nil
+}
+
+// audiences returns the token audiences trusted by a Role, falling back to the operator defaults
+func (r *RoleReconciler) audiences(role *eksiamoperatorv1beta1.Role) []string {
+	if len(role.Spec.Audiences) > 0 {
+		return role.Spec.Audiences
+	}
+	if len(r.OIDCAudiences) > 0 {
+		return r.OIDCAudiences
+	}
+	return []string{internal.DefaultOIDCAudience}
+}
+
 // generateInlinePolicies returns a map of JSON string IAM policies, with the map key as the intended inline
-// policy name
-func (r *RoleReconciler) generateInlinePolicies(perms map[string][]eksiamoperatorv1beta1.StatementSpec) (map[string]string, error) {
+// policy name. When the policies exceed the IAM inline policy size limit of a role, the policies which do not fit
+// are returned as customer managed policies instead, named after the role and the policy with a numbered suffix
+// (several are needed when the statements of a policy exceed the managed policy size limit).
+func (r *RoleReconciler) generateInlinePolicies(roleName string, perms map[string][]eksiamoperatorv1beta1.StatementSpec) (map[string]string, map[string]string, error) {
 
 	policies := map[string]string{}
+	managed := map[string]string{}
+	statements := map[string][]internal.AWSPolicyDocumentStatement{}
 
+	size := 0
 	for svc, stmts := range perms {
-		d := &internal.AWSPolicyDocument{Version: "2012-10-17", Statement: []internal.AWSPolicyDocumentStatement{}}
+		name := fmt.Sprintf("%s%s%s", r.InlinePolicyPrefix, svc, r.InlinePolicySuffix)
 		for _, stmt := range stmts {
-			d.Statement = append(d.Statement, internal.AWSPolicyDocumentStatement{
+			statements[name] = append(statements[name], internal.AWSPolicyDocumentStatement{
 				Effect:    "Allow",
 				Actions:   stringOrArray(stmt.Actions),
 				Resources: stringOrArray(stmt.Resources),
 			})
 		}
 
-		j, err := json.Marshal(d)
+		// Duplicate and overlapping statements are merged, so policies are minimal and stable across reconciles
+		statements[name] = internal.NormalizeStatements(statements[name])
+
+		j, err := json.Marshal(&internal.AWSPolicyDocument{Version: "2012-10-17", Statement: statements[name]})
+		if err != nil {
+			return policies, managed, err
+		}
+
+		policies[name] = string(j)
+		size += len(j)
+	}
+
+	if size <= inlinePolicySizeLimit {
+		return policies, managed, nil
+	}
+
+	// Keep as many policies inline as fit, in name order, and move the rest to managed policies
+	names := make([]string, 0, len(policies))
+	for name := range policies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	size = 0
+	for _, name := range names {
+		if size+len(policies[name]) <= inlinePolicySizeLimit {
+			size += len(policies[name])
+			continue
+		}
+		delete(policies, name)
+
+		docs, err := packPolicyStatements(name, statements[name])
 		if err != nil {
-			return policies, err
+			return policies, managed, err
+		}
+		for i, doc := range docs {
+			managedName := fmt.Sprintf("%s-%s-%d", roleName, name, i+1)
+			if len(managedName) > managedPolicyNameLimit {
+				return policies, managed, newValidationError("managed policy name %s for the statements of policy %s is longer than %d characters", managedName, name, managedPolicyNameLimit)
+			}
+			managed[managedName] = doc
 		}
+	}
+
+	if len(managed) > managedPoliciesPerRoleLimit {
+		return policies, managed, newValidationError("statements need %d managed policies in addition to the inline policies, more than the IAM limit of %d per role", len(managed), managedPoliciesPerRoleLimit)
+	}
+
+	return policies, managed, nil
+}
 
-		policies[fmt.Sprintf("%s%s%s", r.InlinePolicyPrefix, svc, r.InlinePolicySuffix)] = string(j)
+// packPolicyStatements returns the statements of a policy as few JSON policy documents as possible, each within the
+// managed policy size limit
+func packPolicyStatements(name string, stmts []internal.AWSPolicyDocumentStatement) ([]string, error) {
+	docs := []string{}
+	start := 0
+	last := ""
+
+	for i := range stmts {
+		j, err := json.Marshal(&internal.AWSPolicyDocument{Version: "2012-10-17", Statement: stmts[start : i+1]})
+		if err != nil {
+			return nil, err
+		}
+		if len(j) > managedPolicySizeLimit && i > start {
+			// Start a new document with this statement
+			docs = append(docs, last)
+			start = i
+			if j, err = json.Marshal(&internal.AWSPolicyDocument{Version: "2012-10-17", Statement: stmts[i : i+1]}); err != nil {
+				return nil, err
+			}
+		}
+		if len(j) > managedPolicySizeLimit {
+			return nil, newValidationError("statement %d of policy %s exceeds the IAM managed policy size limit of %d characters", i, name, managedPolicySizeLimit)
+		}
+		last = string(j)
 	}
 
-	return policies, nil
+	if len(stmts) > start {
+		docs = append(docs, last)
+	}
+	return docs, nil
 }
 
 // generateInlinePolicies returns a map of JSON string IAM policies, with the map key as the intended inline
@@ -204,14 +657,48 @@ func (r *RoleReconciler) statusUpdater(ctx context.Context, role *eksiamoperator
 
 	if err == nil {
 		role.Status.Error = "<none>"
-		role.Status.State = eksiamoperatorv1beta1.SyncStateOK
 		role.Status.ObservedGeneration = role.ObjectMeta.Generation
+		role.Status.Plan = nil
+		if remaining := r.propagationRemaining(role); remaining > 0 {
+			role.Status.State = eksiamoperatorv1beta1.SyncStatePropagating
+			setCondition(role, eksiamoperatorv1beta1.ConditionTypeReady, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonPropagating, fmt.Sprintf("Waiting %s for IAM changes to propagate", remaining.Round(time.Second)))
+		} else {
+			role.Status.State = eksiamoperatorv1beta1.SyncStateOK
+			message := "IAM role is in sync"
+			if role.Status.RolledBackToRevision > 0 {
+				message = fmt.Sprintf("IAM role is in sync with revision %d", role.Status.RolledBackToRevision)
+			}
+			setCondition(role, eksiamoperatorv1beta1.ConditionTypeReady, metav1.ConditionTrue, eksiamoperatorv1beta1.ReasonSynced, message)
+		}
+		setCondition(role, eksiamoperatorv1beta1.ConditionTypeStalled, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonSynced, "")
 	} else {
 		role.Status.Error = err.Error()
 		role.Status.State = eksiamoperatorv1beta1.SyncStateErr
+		r.recordFailureEvent(role, err)
+
+		reason, terminal := classifyError(err)
+		setCondition(role, eksiamoperatorv1beta1.ConditionTypeReady, metav1.ConditionFalse, reason, err.Error())
+		if terminal {
+			setCondition(role, eksiamoperatorv1beta1.ConditionTypeStalled, metav1.ConditionTrue, reason, "Not retrying until the Role spec changes")
+		} else {
+			setCondition(role, eksiamoperatorv1beta1.ConditionTypeStalled, metav1.ConditionFalse, reason, "Retrying with backoff")
+		}
 	}
 
+	roleStates.set(types.NamespacedName{Namespace: role.Namespace, Name: role.Name}, role.Status.State)
+
 	if e := r.Status().Update(ctx, role); e != nil {
 		r.Log.Error(e, "unable to update Role status")
 	}
 }
+
+// setCondition sets a status condition on a Role, for the Role's current generation
+func setCondition(role *eksiamoperatorv1beta1.Role, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&role.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: role.Generation,
+	})
+}
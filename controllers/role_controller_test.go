@@ -0,0 +1,207 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const (
+	testIssuer      = "oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+	testProviderARN = "arn:aws:iam::111111111111:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+)
+
+// renderTrustPolicy returns the trust policy generated for a Role spec, decoded
+func renderTrustPolicy(t *testing.T, r *RoleReconciler, spec eksiamoperatorv1beta1.RoleSpec) *internal.AWSPolicyDocument {
+	t.Helper()
+
+	role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}, Spec: spec}
+	policy, err := r.generateTrustPolicy(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &internal.AWSPolicyDocument{}
+	if err := json.Unmarshal([]byte(policy), doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestGenerateTrustPolicyServiceAccounts(t *testing.T) {
+	r := &RoleReconciler{OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+
+	tests := []struct {
+		name            string
+		serviceAccounts []string
+		audiences       []string
+		conditions      []map[string]map[string][]string
+	}{
+		{
+			name:            "exact names use StringEquals",
+			serviceAccounts: []string{"one", "two"},
+			conditions: []map[string]map[string][]string{{
+				"StringEquals": {
+					testIssuer + ":sub": {"system:serviceaccount:ns:one", "system:serviceaccount:ns:two"},
+					testIssuer + ":aud": {internal.DefaultOIDCAudience},
+				},
+			}},
+		},
+		{
+			name:            "wildcards use StringLike in a separate statement",
+			serviceAccounts: []string{"one", "job-*"},
+			conditions: []map[string]map[string][]string{
+				{
+					"StringEquals": {
+						testIssuer + ":sub": {"system:serviceaccount:ns:one"},
+						testIssuer + ":aud": {internal.DefaultOIDCAudience},
+					},
+				},
+				{
+					"StringLike":   {testIssuer + ":sub": {"system:serviceaccount:ns:job-*"}},
+					"StringEquals": {testIssuer + ":aud": {internal.DefaultOIDCAudience}},
+				},
+			},
+		},
+		{
+			name:            "audiences of the Role replace the default",
+			serviceAccounts: []string{"job-?"},
+			audiences:       []string{"vault", "sts.amazonaws.com"},
+			conditions: []map[string]map[string][]string{{
+				"StringLike":   {testIssuer + ":sub": {"system:serviceaccount:ns:job-?"}},
+				"StringEquals": {testIssuer + ":aud": {"vault", "sts.amazonaws.com"}},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := renderTrustPolicy(t, r, eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", ServiceAccounts: tt.serviceAccounts, Audiences: tt.audiences})
+
+			conditions := []map[string]map[string][]string{}
+			for _, stmt := range doc.Statement {
+				if federated := stmt.Principal["Federated"]; federated != testProviderARN {
+					t.Fatalf("expected the cluster OIDC provider to be trusted, got %v", federated)
+				}
+				conditions = append(conditions, stmt.Condition)
+			}
+			if !reflect.DeepEqual(conditions, tt.conditions) {
+				t.Fatalf("expected conditions %v, got %v", tt.conditions, conditions)
+			}
+		})
+	}
+}
+
+func TestGenerateTrustPolicyEmpty(t *testing.T) {
+	r := &RoleReconciler{OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+
+	role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"}, Spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns"}}
+	_, err := r.generateTrustPolicy(role)
+	var invalid *validationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("expected a validation error for a Role trusting nothing, got %v", err)
+	}
+
+	// Additional trust alone is enough
+	renderTrustPolicy(t, r, eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", AdditionalTrust: []eksiamoperatorv1beta1.TrustSpec{{Services: []string{"ec2.amazonaws.com"}}}})
+}
+
+func TestGenerateTrustPolicyInvalidOIDCProviders(t *testing.T) {
+	r := &RoleReconciler{OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+
+	tests := []struct {
+		name     string
+		provider eksiamoperatorv1beta1.OIDCProvider
+	}{
+		{name: "missing issuer", provider: eksiamoperatorv1beta1.OIDCProvider{ProviderARN: testProviderARN}},
+		{name: "not an ARN", provider: eksiamoperatorv1beta1.OIDCProvider{ProviderARN: "oidc-provider/example", IssuerURL: "https://example"}},
+		{name: "not an OIDC provider", provider: eksiamoperatorv1beta1.OIDCProvider{ProviderARN: "arn:aws:iam::111111111111:role/example", IssuerURL: "https://example"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &eksiamoperatorv1beta1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", ServiceAccounts: []string{"one"}, OIDCProviders: []eksiamoperatorv1beta1.OIDCProvider{tt.provider}},
+			}
+			_, err := r.generateTrustPolicy(role)
+			var invalid *validationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("expected a validation error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRenderExpandActions(t *testing.T) {
+	catalog, err := internal.DefaultActionCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &RoleReconciler{OIDCIssuerURL: testIssuer, OIDCProviderARN: testProviderARN, ActionCatalog: catalog}
+
+	tests := []struct {
+		name     string
+		actions  []string
+		expand   bool
+		expected string
+		invalid  bool
+	}{
+		{name: "not expanded", actions: []string{"sqs:*Message"}, expected: `"sqs:*Message"`},
+		{name: "expanded", actions: []string{"sqs:*Message", "s3:GetObject"}, expand: true, expected: `["s3:GetObject","sqs:DeleteMessage","sqs:ReceiveMessage","sqs:SendMessage"]`},
+		{name: "service not catalogued", actions: []string{"ec2:Describe*"}, expand: true, invalid: true},
+		{name: "no match", actions: []string{"sqs:Frobnicate*"}, expand: true, invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &eksiamoperatorv1beta1.Role{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec: eksiamoperatorv1beta1.RoleSpec{
+					ServiceAccounts: []string{"app"},
+					Statements: map[string][]eksiamoperatorv1beta1.StatementSpec{
+						"queue": {{Actions: tt.actions, Resources: []string{"*"}, ExpandActions: tt.expand}},
+					},
+				},
+			}
+
+			rendered, err := r.Render(role)
+			if tt.invalid {
+				var validationErr *validationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("expected a validation error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if policy := rendered.InlinePolicies["queue"]; !strings.Contains(policy, `"Action":`+tt.expected) {
+				t.Fatalf("expected the actions %s, got %s", tt.expected, policy)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// RoleGarbageCollector periodically finds the IAM roles created by the operator for this cluster whose Role no
+// longer exists (e.g. the Role's finalizer was removed by hand), and reports or deletes them according to the
+// policy. It runs on the leader only, and does nothing while the policy is Disabled.
+type RoleGarbageCollector struct {
+	// Reader used to list Roles, which should read from the API server rather than the cache
+	Reader     client.Reader
+	Reconciler *RoleReconciler
+	Log        logr.Logger
+
+	// When sharding is enabled, garbage collection only runs on the replica owning the garbage collector shard
+	Shards *RoleShards
+
+	Policy      eksiamoperatorv1beta1.GarbageCollectionPolicy
+	Interval    time.Duration
+	GracePeriod time.Duration
+
+	// When each orphaned role was first found, for the grace period
+	orphanedSince map[string]time.Time
+
+	// Guards the policy, interval and grace period, which are replaced when the OperatorConfig changes
+	mu           sync.Mutex
+	reconfigured chan struct{}
+}
+
+// Configure replaces the policy, interval and grace period of the garbage collector while it runs, and starts a
+// collection with them
+func (g *RoleGarbageCollector) Configure(policy eksiamoperatorv1beta1.GarbageCollectionPolicy, interval, gracePeriod time.Duration) {
+	g.mu.Lock()
+	g.Policy, g.Interval, g.GracePeriod = policy, interval, gracePeriod
+	reconfigured := g.reconfigured
+	g.mu.Unlock()
+
+	select {
+	case reconfigured <- struct{}{}:
+	default:
+	}
+}
+
+// settings returns the current policy, interval and grace period
+func (g *RoleGarbageCollector) settings() (eksiamoperatorv1beta1.GarbageCollectionPolicy, time.Duration, time.Duration) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.Policy, g.Interval, g.GracePeriod
+}
+
+// NeedLeaderElection makes the garbage collector run on the leader only
+func (g *RoleGarbageCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// Start runs garbage collection every interval (and whenever it is reconfigured) until the context is cancelled
+func (g *RoleGarbageCollector) Start(ctx context.Context) error {
+	g.orphanedSince = map[string]time.Time{}
+
+	g.mu.Lock()
+	g.reconfigured = make(chan struct{}, 1)
+	reconfigured := g.reconfigured
+	g.mu.Unlock()
+
+	for {
+		policy, interval, gracePeriod := g.settings()
+		enabled := policy == eksiamoperatorv1beta1.GarbageCollectionReport || policy == eksiamoperatorv1beta1.GarbageCollectionDelete
+		if enabled && (g.Shards == nil || g.Shards.Owns(garbageCollectorShardKey)) {
+			if err := g.collect(ctx, policy, gracePeriod); err != nil {
+				g.Log.Error(err, "Garbage collection of orphaned IAM roles failed")
+				garbageCollectionErrors.Inc()
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-reconfigured:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// collect finds the orphaned IAM roles, and deletes those which have been orphaned for longer than the grace period
+// when the policy is Delete (unless the operator is in dry-run mode)
+func (g *RoleGarbageCollector) collect(ctx context.Context, policy eksiamoperatorv1beta1.GarbageCollectionPolicy, gracePeriod time.Duration) error {
+	settings := g.Reconciler.snapshot()
+
+	awsClient, err := internal.NewAWSRoleClient(ctx, g.Log)
+	if err != nil {
+		return err
+	}
+
+	// IAM roles are listed before Roles, so a role created after the Roles are listed is never seen as orphaned
+	names, err := awsClient.ListRoleNames(ctx, settings.RolePrefix, settings.RoleSuffix)
+	if err != nil {
+		return err
+	}
+
+	var roles eksiamoperatorv1beta1.RoleList
+	if err := g.Reader.List(ctx, &roles); err != nil {
+		return err
+	}
+
+	managed := map[string]bool{}
+	sources := map[string]bool{}
+	for i := range roles.Items {
+		for _, name := range managedRoleNames(&roles.Items[i], settings.roleName(&roles.Items[i])) {
+			managed[name] = true
+		}
+		sources[roles.Items[i].Namespace+"/"+roles.Items[i].Name] = true
+	}
+
+	// Only the IAM roles no Role manages are read, to check they are owned by the operator for this cluster
+	candidates := []string{}
+	for _, name := range names {
+		if !managed[name] {
+			candidates = append(candidates, name)
+		}
+	}
+	owned, err := awsClient.OwnedRoles(ctx, candidates, map[string]string{internal.RoleClusterTag: settings.ClusterName})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	orphaned := map[string]time.Time{}
+	for _, v := range internal.FindOrphanedRoles(owned, managed, sources, g.orphanedSince, now) {
+		if _, ok := g.orphanedSince[v.Name]; !ok {
+			g.Log.Info("Found orphaned IAM role", "role", v.Name, "source", v.Tags[internal.RoleSourceTag])
+		}
+		orphaned[v.Name] = v.Since
+
+		if policy != eksiamoperatorv1beta1.GarbageCollectionDelete || !v.Expired(now, gracePeriod) {
+			continue
+		}
+
+		// IAM is not changed in dry-run mode, so orphaned roles are only reported
+		if settings.DryRun {
+			g.Log.Info("Not deleting orphaned IAM role in dry-run mode", "role", v.Name, "orphanedSince", v.Since)
+			continue
+		}
+
+		g.Log.Info("Deleting orphaned IAM role", "role", v.Name, "orphanedSince", v.Since)
+		if err := awsClient.Delete(ctx, v.Name); err != nil {
+			g.orphanedSince = orphaned
+			return err
+		}
+		delete(orphaned, v.Name)
+		orphanedRolesDeleted.Inc()
+	}
+
+	g.orphanedSince = orphaned
+	orphanedRoles.Set(float64(len(orphaned)))
+	return nil
+}
@@ -0,0 +1,449 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const serviceAccountSubjectPrefix = "system:serviceaccount:"
+
+// ImportError is returned when an IAM role uses a construct which cannot be expressed by a Role spec
+type ImportError struct {
+	Role   string
+	Reason string
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("IAM role %s cannot be imported: %s", e.Role, e.Reason)
+}
+
+// Import converts an existing IAM role into the Role spec which renders it, the reverse of Render. The role name
+// must have the configured prefix and suffix, the trust policy must trust service accounts of a single namespace,
+// and the inline policies (and the operator's managed policies holding their overflow) must only contain Allow
+// statements of actions on resources. Statement IDs are dropped, and are returned as warnings.
+func (r *RoleReconciler) Import(name string, state *internal.RoleState) (*eksiamoperatorv1beta1.Role, []string, error) {
+	if !strings.HasPrefix(name, r.RolePrefix) || !strings.HasSuffix(name, r.RoleSuffix) || len(name) <= len(r.RolePrefix)+len(r.RoleSuffix) {
+		return nil, nil, &ImportError{Role: name, Reason: fmt.Sprintf("name does not have the role name prefix %q and suffix %q, so the operator "+
+			"cannot manage it under this name; import it under a new Role name instead, and the operator creates the IAM role %s<Role name>%s in its place",
+			r.RolePrefix, r.RoleSuffix, r.RolePrefix, r.RoleSuffix)}
+	}
+	return r.ImportAs(name, strings.TrimSuffix(strings.TrimPrefix(name, r.RolePrefix), r.RoleSuffix), state)
+}
+
+// ImportAs converts an existing IAM role into a Role of the given name, as Import does. When the IAM role name is
+// not the one rendered for the Role, the operator creates a new IAM role with the same policies in its place, and
+// a warning is returned as the existing role is left for the user to delete.
+func (r *RoleReconciler) ImportAs(name, roleName string, state *internal.RoleState) (*eksiamoperatorv1beta1.Role, []string, error) {
+	fail := func(format string, a ...interface{}) error {
+		return &ImportError{Role: name, Reason: fmt.Sprintf(format, a...)}
+	}
+
+	if errs := validation.IsDNS1123Subdomain(roleName); len(errs) > 0 {
+		return nil, nil, fail("%q is not a valid Role name: %s", roleName, strings.Join(errs, ", "))
+	}
+
+	role := &eksiamoperatorv1beta1.Role{}
+	role.APIVersion = eksiamoperatorv1beta1.GroupVersion.String()
+	role.Kind = "Role"
+	role.Name = roleName
+
+	warnings := []string{}
+	if rendered := r.RolePrefix + roleName + r.RoleSuffix; rendered != name {
+		warnings = append(warnings, fmt.Sprintf("IAM role %s: the operator creates the IAM role %s for the Role in its place, delete %s once the new role is in use", name, rendered, name))
+	}
+
+	if err := r.importTrustPolicy(role, state.TrustPolicy, fail); err != nil {
+		return nil, nil, err
+	}
+
+	if len(state.AttachedPolicies) > 0 {
+		return nil, nil, fail("managed policies %s are attached, only inline policies and the operator's own managed policies are supported", strings.Join(state.AttachedPolicies, ", "))
+	}
+
+	// The statements of an inline policy may be split across the policy and the operator's managed policies, which
+	// are named after the IAM role and the inline policy with a numbered suffix
+	documents := map[string][]string{}
+	for policy, doc := range state.InlinePolicies {
+		documents[policy] = []string{doc}
+	}
+	parts := map[string]map[int]string{}
+	for policy, doc := range state.ManagedPolicies {
+		inline := strings.TrimPrefix(policy, name+"-")
+		i := strings.LastIndex(inline, "-")
+		part, err := strconv.Atoi(inline[i+1:])
+		if !strings.HasPrefix(policy, name+"-") || i <= 0 || err != nil {
+			return nil, nil, fail("managed policy %s is not named after the IAM role and an inline policy", policy)
+		}
+		if parts[inline[:i]] == nil {
+			parts[inline[:i]] = map[int]string{}
+		}
+		parts[inline[:i]][part] = doc
+	}
+	for policy, docs := range parts {
+		numbers := make([]int, 0, len(docs))
+		for part := range docs {
+			numbers = append(numbers, part)
+		}
+		sort.Ints(numbers)
+		for _, part := range numbers {
+			documents[policy] = append(documents[policy], docs[part])
+		}
+	}
+
+	role.Spec.Statements = map[string][]eksiamoperatorv1beta1.StatementSpec{}
+	policies := make([]string, 0, len(documents))
+	for policy := range documents {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+	for _, policy := range policies {
+		if !strings.HasPrefix(policy, r.InlinePolicyPrefix) || !strings.HasSuffix(policy, r.InlinePolicySuffix) || len(policy) <= len(r.InlinePolicyPrefix)+len(r.InlinePolicySuffix) {
+			return nil, nil, fail("inline policy %s does not have the inline policy name prefix %q and suffix %q", policy, r.InlinePolicyPrefix, r.InlinePolicySuffix)
+		}
+		group := strings.TrimSuffix(strings.TrimPrefix(policy, r.InlinePolicyPrefix), r.InlinePolicySuffix)
+
+		for _, doc := range documents[policy] {
+			stmts, sids, err := importInlinePolicy(doc)
+			if err != nil {
+				return nil, nil, fail("inline policy %s: %v", policy, err)
+			}
+			for _, sid := range sids {
+				warnings = append(warnings, fmt.Sprintf("IAM role %s: inline policy %s: statement ID %q dropped", name, policy, sid))
+			}
+			role.Spec.Statements[group] = append(role.Spec.Statements[group], stmts...)
+		}
+	}
+
+	return role, warnings, nil
+}
+
+// importTrustPolicy sets the service accounts, audiences, OIDC providers and additional trust of a Role from a
+// trust policy
+func (r *RoleReconciler) importTrustPolicy(role *eksiamoperatorv1beta1.Role, trustPolicy string, fail func(string, ...interface{}) error) error {
+	stmts, err := parsePolicyStatements(trustPolicy)
+	if err != nil {
+		return fail("trust policy: %v", err)
+	}
+
+	configured := map[string]bool{}
+	for _, v := range r.oidcProviders(&eksiamoperatorv1beta1.Role{}) {
+		configured[v.ProviderARN] = true
+	}
+
+	serviceAccounts := map[string]bool{}
+	var audiences []string
+	for i, stmt := range stmts {
+		if err := checkStatementKeys(stmt, "Sid", "Effect", "Principal", "Action", "Condition"); err != nil {
+			return fail("trust policy statement %d: %v", i, err)
+		}
+		if stmt["Effect"] != "Allow" {
+			return fail("trust policy statement %d: only Allow statements are supported", i)
+		}
+
+		principal, ok := stmt["Principal"].(map[string]interface{})
+		if !ok || len(principal) != 1 {
+			return fail("trust policy statement %d: exactly one principal type must be set", i)
+		}
+		actions, ok := stringList(stmt["Action"])
+		if !ok {
+			return fail("trust policy statement %d: Action must be a string or list of strings", i)
+		}
+		conditions, err := parseConditions(stmt["Condition"])
+		if err != nil {
+			return fail("trust policy statement %d: %v", i, err)
+		}
+
+		var principalType string
+		var principals []string
+		for k, v := range principal {
+			principalType = k
+			if principals, ok = stringList(v); !ok {
+				return fail("trust policy statement %d: %s principal must be a string or list of strings", i, k)
+			}
+		}
+
+		switch {
+		case principalType == "Service" && reflect.DeepEqual(principals, []string{"pods.eks.amazonaws.com"}):
+			return fail("trust policy statement %d: the service accounts of EKS Pod Identity roles cannot be determined from the trust policy", i)
+
+		case principalType == "Federated" && len(principals) == 1 && reflect.DeepEqual(actions, []string{"sts:AssumeRoleWithWebIdentity"}) && isServiceAccountTrust(principals[0], conditions):
+			auds, err := importServiceAccountTrust(role, principals[0], conditions, serviceAccounts)
+			if err != nil {
+				return fail("trust policy statement %d: %v", i, err)
+			}
+			if audiences != nil && !reflect.DeepEqual(audiences, auds) {
+				return fail("trust policy statement %d: service accounts must be trusted with the same audiences in every statement", i)
+			}
+			audiences = auds
+
+			if !configured[principals[0]] && !containsOIDCProvider(role.Spec.OIDCProviders, principals[0]) {
+				role.Spec.OIDCProviders = append(role.Spec.OIDCProviders, eksiamoperatorv1beta1.OIDCProvider{
+					ProviderARN: principals[0],
+					IssuerURL:   "https://" + oidcProviderIssuer(principals[0]),
+				})
+			}
+
+		case principalType == "Federated" && len(principals) == 1 && reflect.DeepEqual(actions, []string{"sts:AssumeRoleWithWebIdentity"}):
+			role.Spec.AdditionalTrust = append(role.Spec.AdditionalTrust, eksiamoperatorv1beta1.TrustSpec{Federated: principals[0], Conditions: conditions})
+
+		case principalType == "AWS" && reflect.DeepEqual(actions, []string{"sts:AssumeRole"}):
+			role.Spec.AdditionalTrust = append(role.Spec.AdditionalTrust, eksiamoperatorv1beta1.TrustSpec{AWS: principals, Conditions: conditions})
+
+		case principalType == "Service" && reflect.DeepEqual(actions, []string{"sts:AssumeRole"}):
+			role.Spec.AdditionalTrust = append(role.Spec.AdditionalTrust, eksiamoperatorv1beta1.TrustSpec{Services: principals, Conditions: conditions})
+
+		default:
+			return fail("trust policy statement %d: %s principals with actions %v are not supported", i, principalType, actions)
+		}
+	}
+
+	if len(serviceAccounts) == 0 {
+		return fail("trust policy does not trust any service account")
+	}
+	for v := range serviceAccounts {
+		role.Spec.ServiceAccounts = append(role.Spec.ServiceAccounts, v)
+	}
+	sort.Strings(role.Spec.ServiceAccounts)
+
+	// Audiences are only set in the spec if they differ from the operator config
+	configuredAudiences := append([]string{}, r.audiences(&eksiamoperatorv1beta1.Role{})...)
+	sort.Strings(configuredAudiences)
+	if !reflect.DeepEqual(audiences, configuredAudiences) {
+		role.Spec.Audiences = audiences
+	}
+
+	return nil
+}
+
+// isServiceAccountTrust returns true if a web identity statement trusts Kubernetes service accounts, i.e. it has a
+// subject condition on service account subjects
+func isServiceAccountTrust(providerARN string, conditions map[string]map[string][]string) bool {
+	sub := oidcProviderIssuer(providerARN) + ":sub"
+	for _, op := range []string{"StringEquals", "StringLike"} {
+		for _, v := range conditions[op][sub] {
+			if strings.HasPrefix(v, serviceAccountSubjectPrefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importServiceAccountTrust adds the service accounts trusted by a web identity statement to a Role, returning
+// the trusted audiences (the default audience when there is no audience condition). Only the subject and audience
+// conditions generated by the operator are supported.
+func importServiceAccountTrust(role *eksiamoperatorv1beta1.Role, providerARN string, conditions map[string]map[string][]string, serviceAccounts map[string]bool) ([]string, error) {
+	issuer := oidcProviderIssuer(providerARN)
+	sub, aud := issuer+":sub", issuer+":aud"
+
+	var audiences []string
+	for op, keys := range conditions {
+		for key, values := range keys {
+			switch {
+			case op == "StringEquals" && key == aud:
+				audiences = append([]string{}, values...)
+				sort.Strings(audiences)
+			case (op == "StringEquals" || op == "StringLike") && key == sub:
+				for _, v := range values {
+					parts := strings.Split(strings.TrimPrefix(v, serviceAccountSubjectPrefix), ":")
+					if !strings.HasPrefix(v, serviceAccountSubjectPrefix) || len(parts) != 2 {
+						return nil, fmt.Errorf("subject %q is not a service account", v)
+					}
+					if op == "StringEquals" && strings.ContainsAny(v, "*?") {
+						return nil, fmt.Errorf("subject %q contains a wildcard, but is matched exactly", v)
+					}
+					if op == "StringLike" && !strings.ContainsAny(parts[1], "*?") {
+						return nil, fmt.Errorf("subject %q has no wildcard, but is matched with StringLike", v)
+					}
+					if strings.ContainsAny(parts[0], "*?") {
+						return nil, fmt.Errorf("subject %q has a wildcard namespace", v)
+					}
+					if len(role.Spec.Namespace) > 0 && role.Spec.Namespace != parts[0] {
+						return nil, fmt.Errorf("service accounts of more than one namespace are trusted (%s and %s)", role.Spec.Namespace, parts[0])
+					}
+					role.Spec.Namespace = parts[0]
+					serviceAccounts[parts[1]] = true
+				}
+			default:
+				return nil, fmt.Errorf("condition %s on %s is not supported", op, key)
+			}
+		}
+	}
+
+	// Without an audience condition, EKS only issues tokens for the default audience
+	if len(audiences) == 0 {
+		audiences = []string{internal.DefaultOIDCAudience}
+	}
+	return audiences, nil
+}
+
+// importInlinePolicy converts an inline policy into statements, returning the IDs of the statements which are
+// dropped as they cannot be expressed in a Role spec
+func importInlinePolicy(policy string) ([]eksiamoperatorv1beta1.StatementSpec, []string, error) {
+	parsed, err := parsePolicyStatements(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stmts := []eksiamoperatorv1beta1.StatementSpec{}
+	sids := []string{}
+	for i, stmt := range parsed {
+		if err := checkStatementKeys(stmt, "Sid", "Effect", "Action", "Resource"); err != nil {
+			return nil, nil, fmt.Errorf("statement %d: %v", i, err)
+		}
+		if stmt["Effect"] != "Allow" {
+			return nil, nil, fmt.Errorf("statement %d: only Allow statements are supported", i)
+		}
+
+		actions, ok := stringList(stmt["Action"])
+		if !ok {
+			return nil, nil, fmt.Errorf("statement %d: Action must be a string or list of strings", i)
+		}
+		resources, ok := stringList(stmt["Resource"])
+		if !ok {
+			return nil, nil, fmt.Errorf("statement %d: Resource must be a string or list of strings", i)
+		}
+		if sid, ok := stmt["Sid"].(string); ok && len(sid) > 0 {
+			sids = append(sids, sid)
+		}
+
+		stmts = append(stmts, eksiamoperatorv1beta1.StatementSpec{Actions: actions, Resources: resources})
+	}
+	return stmts, sids, nil
+}
+
+// parsePolicyStatements parses the statements of a policy document. A single statement object is returned as a
+// list of one statement.
+func parsePolicyStatements(policy string) ([]map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %v", err)
+	}
+	if err := checkStatementKeys(doc, "Version", "Id", "Statement"); err != nil {
+		return nil, err
+	}
+
+	var list []interface{}
+	switch v := doc["Statement"].(type) {
+	case []interface{}:
+		list = v
+	case map[string]interface{}:
+		list = []interface{}{v}
+	default:
+		return nil, fmt.Errorf("policy document has no statements")
+	}
+
+	stmts := []map[string]interface{}{}
+	for i, v := range list {
+		stmt, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("statement %d is not an object", i)
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// checkStatementKeys returns an error naming the first key of a policy element which is not supported
+func checkStatementKeys(stmt map[string]interface{}, supported ...string) error {
+	keys := []string{}
+	for k := range stmt {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if !internal.ContainsString(supported, k) {
+			return fmt.Errorf("%s is not supported", k)
+		}
+	}
+	return nil
+}
+
+// parseConditions parses the conditions of a statement, allowing single string values
+func parseConditions(v interface{}) (map[string]map[string][]string, error) {
+	conditions := map[string]map[string][]string{}
+	if v == nil {
+		return conditions, nil
+	}
+
+	ops, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Condition must be an object")
+	}
+	for op, keys := range ops {
+		m, ok := keys.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("condition %s must be an object", op)
+		}
+		conditions[op] = map[string][]string{}
+		for key, values := range m {
+			if conditions[op][key], ok = stringList(values); !ok {
+				return nil, fmt.Errorf("condition %s on %s must be a string or list of strings", op, key)
+			}
+		}
+	}
+	return conditions, nil
+}
+
+// stringList converts a policy value which is a string or a list of strings into a list
+func stringList(v interface{}) ([]string, bool) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, true
+	case []interface{}:
+		list := []string{}
+		for _, s := range v {
+			str, ok := s.(string)
+			if !ok {
+				return nil, false
+			}
+			list = append(list, str)
+		}
+		return list, len(list) > 0
+	}
+	return nil, false
+}
+
+// oidcProviderIssuer returns the issuer host and path of an IAM OIDC provider ARN, as used in condition keys
+func oidcProviderIssuer(arn string) string {
+	if i := strings.Index(arn, ":oidc-provider/"); i >= 0 {
+		return arn[i+len(":oidc-provider/"):]
+	}
+	return arn
+}
+
+func containsOIDCProvider(providers []eksiamoperatorv1beta1.OIDCProvider, arn string) bool {
+	for _, v := range providers {
+		if v.ProviderARN == arn {
+			return true
+		}
+	}
+	return false
+}
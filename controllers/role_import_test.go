@@ -0,0 +1,143 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const (
+	testOtherIssuer      = "oidc.eks.eu-west-2.amazonaws.com/id/OTHER"
+	testOtherProviderARN = "arn:aws:iam::111111111111:oidc-provider/oidc.eks.eu-west-2.amazonaws.com/id/OTHER"
+)
+
+func TestImportTrustPolicy(t *testing.T) {
+	r := &RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+
+	tests := []struct {
+		name string
+		spec eksiamoperatorv1beta1.RoleSpec
+	}{
+		{
+			name: "exact and wildcard service accounts",
+			spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", ServiceAccounts: []string{"job-*", "one", "two"}},
+		},
+		{
+			name: "audiences",
+			spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", ServiceAccounts: []string{"one"}, Audiences: []string{"sts.amazonaws.com", "vault"}},
+		},
+		{
+			name: "multiple OIDC providers",
+			spec: eksiamoperatorv1beta1.RoleSpec{
+				Namespace:       "ns",
+				ServiceAccounts: []string{"job-?", "one"},
+				OIDCProviders:   []eksiamoperatorv1beta1.OIDCProvider{{ProviderARN: testOtherProviderARN, IssuerURL: "https://" + testOtherIssuer}},
+			},
+		},
+		{
+			name: "additional trust",
+			spec: eksiamoperatorv1beta1.RoleSpec{
+				Namespace:       "ns",
+				ServiceAccounts: []string{"one"},
+				AdditionalTrust: []eksiamoperatorv1beta1.TrustSpec{
+					{AWS: []string{"arn:aws:iam::111111111111:role/ci"}, Conditions: map[string]map[string][]string{"StringEquals": {"aws:PrincipalTag/team": {"platform"}}}},
+					{Services: []string{"lambda.amazonaws.com"}, Conditions: map[string]map[string][]string{"ArnLike": {"aws:SourceArn": {"arn:aws:lambda:*"}}}},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Importing the trust policy rendered for a spec returns the spec
+			role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app"}, Spec: tt.spec}
+			trustPolicy, err := r.generateTrustPolicy(role)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			imported, _, err := r.Import("eks-app", &internal.RoleState{TrustPolicy: trustPolicy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported.Spec.Statements = nil
+			if !reflect.DeepEqual(imported.Spec, tt.spec) {
+				t.Fatalf("expected spec %+v, got %+v", tt.spec, imported.Spec)
+			}
+		})
+	}
+}
+
+func TestImportTrustPolicyWithoutAudience(t *testing.T) {
+	r := &RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN, OIDCAudiences: []string{"vault"}}
+
+	trustPolicy := `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"` + testProviderARN + `"},` +
+		`"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"` + testIssuer + `:sub":"system:serviceaccount:ns:one"}}}]}`
+
+	imported, _, err := r.Import("eks-app", &internal.RoleState{TrustPolicy: trustPolicy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(imported.Spec.Audiences, []string{internal.DefaultOIDCAudience}) {
+		t.Fatalf("expected the default audience, got %v", imported.Spec.Audiences)
+	}
+}
+
+func TestImportAs(t *testing.T) {
+	r := &RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+	role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app"}, Spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns", ServiceAccounts: []string{"one"}}}
+	trustPolicy, err := r.generateTrustPolicy(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := &internal.RoleState{TrustPolicy: trustPolicy}
+
+	// An IAM role without the prefix cannot be imported under its own name
+	var importErr *ImportError
+	if _, _, err := r.Import("legacy-app", state); !errors.As(err, &importErr) || !strings.Contains(err.Error(), "import it under a new Role name") {
+		t.Fatalf("expected an import error explaining the rename, got %v", err)
+	}
+
+	imported, warnings, err := r.ImportAs("legacy-app", "app", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.Name != "app" || imported.Spec.Namespace != "ns" || !reflect.DeepEqual(imported.Spec.ServiceAccounts, []string{"one"}) {
+		t.Fatalf("expected Role app trusting ns/one, got %s with %+v", imported.Name, imported.Spec)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "creates the IAM role eks-app") {
+		t.Fatalf("expected a warning that the IAM role is replaced, got %v", warnings)
+	}
+
+	// Importing under the rendered name is the same as Import
+	if _, warnings, err := r.ImportAs("eks-app", "app", state); err != nil || len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v and %v", warnings, err)
+	}
+
+	if _, _, err := r.ImportAs("legacy-app", "App_1", state); !errors.As(err, &importErr) {
+		t.Fatalf("expected an import error for an invalid Role name, got %v", err)
+	}
+}
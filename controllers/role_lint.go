@@ -0,0 +1,95 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// lintPolicies returns the overly broad permissions found in the rendered inline and managed policies of a role,
+// without the ignored rules. Nothing is returned when policy linting is disabled.
+func (r *RoleReconciler) lintPolicies(inline, managed map[string]string) ([]internal.LintFinding, error) {
+	if !r.PolicyLint {
+		return nil, nil
+	}
+
+	policies := make(map[string]string, len(inline)+len(managed))
+	for k, v := range inline {
+		policies[k] = v
+	}
+	for k, v := range managed {
+		policies[k] = v
+	}
+
+	findings, err := internal.LintPolicies(policies)
+	if err != nil {
+		return nil, err
+	}
+	return internal.FilterLintFindings(findings, r.IgnoredLintRules), nil
+}
+
+// CheckPolicyFindings returns a validation error describing the policy lint findings of a rendered role when Roles
+// with findings are blocked
+func (r *RoleReconciler) CheckPolicyFindings(rendered *RenderedRole) error {
+	if !r.BlockPolicyFindings || len(rendered.Findings) == 0 {
+		return nil
+	}
+	return newValidationError("overly broad permissions: %s", strings.Join(policyFindingMessages(rendered.Findings), "; "))
+}
+
+// setPolicyFindings records the policy lint findings of a rendered role in the status of the Role and its metrics,
+// recording a Warning event when they change
+func (r *RoleReconciler) setPolicyFindings(role *eksiamoperatorv1beta1.Role, rendered *RenderedRole) {
+	findings := make([]eksiamoperatorv1beta1.PolicyFinding, 0, len(rendered.Findings))
+	for _, v := range rendered.Findings {
+		findings = append(findings, eksiamoperatorv1beta1.PolicyFinding{Rule: v.Rule, Policy: v.Policy, Message: v.Message})
+	}
+
+	if len(findings) > 0 && !reflect.DeepEqual(findings, role.Status.PolicyFindings) {
+		r.Recorder.Event(role, corev1.EventTypeWarning, eventReasonBroadPermissions, strings.Join(policyFindingMessages(rendered.Findings), "; "))
+	}
+	if len(findings) == 0 {
+		findings = nil
+	}
+	role.Status.PolicyFindings = findings
+
+	if r.PolicyLint {
+		observePolicyFindings(types.NamespacedName{Namespace: role.Namespace, Name: role.Name}, rendered.Findings)
+	}
+}
+
+// policyFindingMessages returns a description of each policy lint finding
+func policyFindingMessages(findings []internal.LintFinding) []string {
+	messages := make([]string, 0, len(findings))
+	for _, v := range findings {
+		if len(v.Policy) > 0 {
+			messages = append(messages, fmt.Sprintf("%s: policy %s %s", v.Rule, v.Policy, v.Message))
+		} else {
+			messages = append(messages, fmt.Sprintf("%s: %s", v.Rule, v.Message))
+		}
+	}
+	return messages
+}
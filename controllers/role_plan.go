@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// dryRun returns true if the IAM changes for a Role should only be planned, either because dry-run mode is enabled
+// for the operator or the Role has the dry-run annotation
+func (r *RoleReconciler) dryRun(role *eksiamoperatorv1beta1.Role) bool {
+	return r.DryRun || role.Annotations[eksiamoperatorv1beta1.DryRunAnnotation] == "true"
+}
+
+// planUpsert records the changes that would be made to the IAM role for a Role in its status, without making them
+func (r *RoleReconciler) planUpsert(ctx context.Context, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role, rendered *RenderedRole) error {
+	changes, err := awsClient.PlanUpsert(ctx, rendered.Name, rendered.TrustPolicy, rendered.InlinePolicies, rendered.ManagedPolicies, rendered.Tags)
+	if err != nil {
+		return err
+	}
+
+	r.planStatusUpdater(ctx, role, rendered.Name, changes)
+	return nil
+}
+
+// planDelete records the changes that would be made to delete the IAM roles for a Role in its status, without
+// making them. The finalizer is kept, so the Role is not deleted while in dry-run mode.
+func (r *RoleReconciler) planDelete(ctx context.Context, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role, name string) error {
+	changes := []internal.PlannedChange{}
+	for _, v := range managedRoleNames(role, name) {
+		planned, err := awsClient.PlanDelete(ctx, v)
+		if internal.IsOwnershipError(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		changes = append(changes, planned...)
+	}
+
+	r.planStatusUpdater(ctx, role, name, changes)
+	return nil
+}
+
+// planStatusUpdater writes a plan to the Role status. The sync state and observed generation are left unchanged,
+// as the spec has not been applied.
+func (r *RoleReconciler) planStatusUpdater(ctx context.Context, role *eksiamoperatorv1beta1.Role, name string, changes []internal.PlannedChange) {
+	plan := &eksiamoperatorv1beta1.RolePlan{
+		RoleName:           name,
+		ObservedGeneration: role.Generation,
+		PlannedAt:          metav1.Now(),
+	}
+	for _, v := range changes {
+		plan.Changes = append(plan.Changes, eksiamoperatorv1beta1.PlannedChange{
+			Action: eksiamoperatorv1beta1.PlanAction(v.Action),
+			Policy: v.Policy,
+			Diff:   v.Diff,
+		})
+	}
+	role.Status.Plan = plan
+
+	if len(changes) == 0 {
+		setCondition(role, eksiamoperatorv1beta1.ConditionTypeReady, metav1.ConditionTrue, eksiamoperatorv1beta1.ReasonDryRun, "Dry-run: IAM role is in sync")
+	} else {
+		message := fmt.Sprintf("Dry-run: %d IAM changes planned", len(changes))
+		setCondition(role, eksiamoperatorv1beta1.ConditionTypeReady, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonDryRun, message)
+		r.Recorder.Event(role, corev1.EventTypeNormal, eventReasonChangesPlanned, message)
+	}
+	setCondition(role, eksiamoperatorv1beta1.ConditionTypeStalled, metav1.ConditionFalse, eksiamoperatorv1beta1.ReasonDryRun, "")
+
+	r.Log.Info("Planned IAM changes", "role", name, "changes", len(changes))
+
+	if e := r.Status().Update(ctx, role); e != nil {
+		r.Log.Error(e, "unable to update Role status")
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// identityMode returns the identity mode of a Role, falling back to the operator default
+func (r *RoleReconciler) identityMode(role *eksiamoperatorv1beta1.Role) eksiamoperatorv1beta1.IdentityMode {
+	if len(role.Spec.IdentityMode) > 0 {
+		return role.Spec.IdentityMode
+	}
+	if len(r.IdentityMode) > 0 {
+		return r.IdentityMode
+	}
+	return eksiamoperatorv1beta1.IdentityModeIRSA
+}
+
+// syncPodIdentityAssociations creates, updates or deletes the EKS Pod Identity Associations for the Role's
+// service accounts, according to the Role's identity mode. The managed associations are tracked in the status.
+func (r *RoleReconciler) syncPodIdentityAssociations(ctx context.Context, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role) error {
+	existing := toInternalPodIdentityAssociations(role.Status.PodIdentityAssociations)
+
+	// Associations are no longer needed if the Role has been switched back to IRSA
+	if r.identityMode(role) != eksiamoperatorv1beta1.IdentityModePodIdentity {
+		if len(existing) == 0 {
+			return nil
+		}
+		if err := awsClient.DeletePodIdentityAssociations(ctx, r.ClusterName, existing); err != nil {
+			return err
+		}
+		role.Status.PodIdentityAssociations = nil
+		return nil
+	}
+
+	if len(r.ClusterName) == 0 {
+		return newValidationError("<config> clusterName must be set to use the PodIdentity identity mode")
+	}
+
+	for _, v := range role.Spec.ServiceAccounts {
+		if strings.ContainsAny(v, "*?") {
+			return newValidationError("service account %q: wildcards are not supported with the PodIdentity identity mode", v)
+		}
+	}
+
+	managed, err := awsClient.SyncPodIdentityAssociations(ctx, r.ClusterName, role.Namespace+"/"+role.Name, role.Spec.Namespace, role.Status.RoleARN, role.Spec.ServiceAccounts, existing)
+	role.Status.PodIdentityAssociations = fromInternalPodIdentityAssociations(managed)
+	return err
+}
+
+// deletePodIdentityAssociations deletes every EKS Pod Identity Association managed for the Role
+func (r *RoleReconciler) deletePodIdentityAssociations(ctx context.Context, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role) error {
+	if len(role.Status.PodIdentityAssociations) == 0 {
+		return nil
+	}
+	if err := awsClient.DeletePodIdentityAssociations(ctx, r.ClusterName, toInternalPodIdentityAssociations(role.Status.PodIdentityAssociations)); err != nil {
+		return err
+	}
+	role.Status.PodIdentityAssociations = nil
+	return nil
+}
+
+func toInternalPodIdentityAssociations(in []eksiamoperatorv1beta1.PodIdentityAssociation) []internal.PodIdentityAssociation {
+	out := []internal.PodIdentityAssociation{}
+	for _, v := range in {
+		out = append(out, internal.PodIdentityAssociation{Namespace: v.Namespace, ServiceAccount: v.ServiceAccount, AssociationID: v.AssociationID})
+	}
+	return out
+}
+
+func fromInternalPodIdentityAssociations(in []internal.PodIdentityAssociation) []eksiamoperatorv1beta1.PodIdentityAssociation {
+	out := []eksiamoperatorv1beta1.PodIdentityAssociation{}
+	for _, v := range in {
+		out = append(out, eksiamoperatorv1beta1.PodIdentityAssociation{Namespace: v.Namespace, ServiceAccount: v.ServiceAccount, AssociationID: v.AssociationID})
+	}
+	return out
+}
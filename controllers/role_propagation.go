@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// recordIAMChange notes that the IAM role of a Role was just changed, so the Role is Propagating until the settle
+// period has passed
+func recordIAMChange(role *eksiamoperatorv1beta1.Role) {
+	now := metav1.Now()
+	role.Status.LastIAMChangeTime = &now
+}
+
+// propagationRemaining returns how long is left of the settle period after the last IAM change of a Role, zero
+// once the change has settled
+func (r *RoleReconciler) propagationRemaining(role *eksiamoperatorv1beta1.Role) time.Duration {
+	if role.Status.LastIAMChangeTime == nil {
+		return 0
+	}
+	remaining := time.Until(role.Status.LastIAMChangeTime.Add(r.PropagationSettlePeriod))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// earliestRequeue returns the sooner of two requeue delays, where zero means no requeue
+func earliestRequeue(a, b time.Duration) time.Duration {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+func TestPropagationRemaining(t *testing.T) {
+	tests := []struct {
+		name         string
+		settlePeriod time.Duration
+		changedAgo   *time.Duration
+		min, max     time.Duration
+	}{
+		{name: "never changed", settlePeriod: 10 * time.Second, changedAgo: nil, min: 0, max: 0},
+		{name: "just changed", settlePeriod: 10 * time.Second, changedAgo: durationPtr(0), min: 9 * time.Second, max: 10 * time.Second},
+		{name: "settling", settlePeriod: 10 * time.Second, changedAgo: durationPtr(4 * time.Second), min: 5 * time.Second, max: 6 * time.Second},
+		{name: "settled", settlePeriod: 10 * time.Second, changedAgo: durationPtr(time.Minute), min: 0, max: 0},
+		{name: "no settle period", settlePeriod: 0, changedAgo: durationPtr(0), min: 0, max: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RoleReconciler{PropagationSettlePeriod: tt.settlePeriod}
+			role := &eksiamoperatorv1beta1.Role{}
+			if tt.changedAgo != nil {
+				changed := metav1.NewTime(time.Now().Add(-*tt.changedAgo))
+				role.Status.LastIAMChangeTime = &changed
+			}
+			if remaining := r.propagationRemaining(role); remaining < tt.min || remaining > tt.max {
+				t.Fatalf("expected between %s and %s remaining, got %s", tt.min, tt.max, remaining)
+			}
+		})
+	}
+}
+
+func TestEarliestRequeue(t *testing.T) {
+	tests := []struct {
+		a, b     time.Duration
+		expected time.Duration
+	}{
+		{a: 0, b: 0, expected: 0},
+		{a: time.Second, b: 0, expected: time.Second},
+		{a: 0, b: time.Second, expected: time.Second},
+		{a: time.Second, b: time.Minute, expected: time.Second},
+		{a: time.Minute, b: time.Second, expected: time.Second},
+		{a: time.Second, b: time.Second, expected: time.Second},
+	}
+
+	for _, tt := range tests {
+		if requeue := earliestRequeue(tt.a, tt.b); requeue != tt.expected {
+			t.Errorf("expected earliestRequeue(%s, %s) to be %s, got %s", tt.a, tt.b, tt.expected, requeue)
+		}
+	}
+}
+
+func durationPtr(d time.Duration) *time.Duration {
+	return &d
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// serviceAccountRoleAnnotation is the annotation used by the EKS pod identity webhook to select the IAM role
+// assumed by pods running as a service account
+const serviceAccountRoleAnnotation = "eks.amazonaws.com/role-arn"
+
+// seedRoleName records the IAM role name in the status of a Role reconciled before the name was recorded (by an
+// earlier version of the operator), before anything can fail. Its IAM role was created under the name rendered now,
+// unless the naming config changed in the same upgrade, so later renames are migrated from this name.
+func seedRoleName(role *eksiamoperatorv1beta1.Role, name string) {
+	if len(role.Status.RoleName) == 0 && role.Status.ObservedGeneration > 0 {
+		role.Status.RoleName = name
+	}
+}
+
+// migrateRenamedRole compares the IAM role name recorded in the status with the newly generated role name. If they
+// differ, service accounts annotated with the previous role are re-pointed to the new role, and the previous role
+// is added to the retired roles list so that it is deleted once the grace period expires.
+func (r *RoleReconciler) migrateRenamedRole(ctx context.Context, role *eksiamoperatorv1beta1.Role, name, arn string) error {
+
+	// A role name that was previously retired is in use again, so it must not be deleted
+	retired := []eksiamoperatorv1beta1.RetiredRole{}
+	for _, v := range role.Status.RetiredRoles {
+		if v.Name != name {
+			retired = append(retired, v)
+		}
+	}
+	role.Status.RetiredRoles = retired
+
+	previous := role.Status.RoleName
+	if len(previous) > 0 && previous != name {
+		r.Log.Info("Role name changed, migrating to new IAM role", "role", name, "previous", previous)
+
+		if err := r.updateServiceAccountRoleARN(ctx, role, previous, arn); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRoleRenamed, "IAM role renamed from %s to %s, %s will be deleted after %s", previous, name, previous, r.RoleRenameGracePeriod)
+
+		role.Status.RetiredRoles = append(role.Status.RetiredRoles, eksiamoperatorv1beta1.RetiredRole{
+			Name:        previous,
+			DeleteAfter: metav1.NewTime(time.Now().Add(r.RoleRenameGracePeriod)),
+		})
+	}
+
+	role.Status.RoleName = name
+	role.Status.RoleARN = arn
+	return nil
+}
+
+// updateServiceAccountRoleARN re-points every service account in the namespace which is annotated with the
+// previous IAM role to the new IAM role ARN
+func (r *RoleReconciler) updateServiceAccountRoleARN(ctx context.Context, role *eksiamoperatorv1beta1.Role, previous, arn string) error {
+	ns := role.Spec.Namespace
+
+	var serviceAccounts corev1.ServiceAccountList
+	if err := r.List(ctx, &serviceAccounts, client.InNamespace(ns)); err != nil {
+		return err
+	}
+
+	for i := range serviceAccounts.Items {
+		sa := &serviceAccounts.Items[i]
+		if !roleARNHasName(sa.Annotations[serviceAccountRoleAnnotation], previous) {
+			continue
+		}
+
+		r.Log.Info("Updating service account role annotation", "namespace", ns, "serviceAccount", sa.Name, "roleArn", arn)
+		patch := client.MergeFrom(sa.DeepCopy())
+		sa.Annotations[serviceAccountRoleAnnotation] = arn
+		if err := r.Patch(ctx, sa, patch); err != nil {
+			return err
+		}
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonServiceAccountMoved, "Updated service account %s/%s to use IAM role %s", ns, sa.Name, arn)
+	}
+
+	return nil
+}
+
+// deleteRetiredRoles deletes retired IAM roles whose grace period has expired. If any retired roles remain, the
+// duration until the next one expires is returned so the Role can be requeued.
+func (r *RoleReconciler) deleteRetiredRoles(ctx context.Context, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role) (time.Duration, error) {
+	var requeueAfter time.Duration
+
+	remaining := []eksiamoperatorv1beta1.RetiredRole{}
+	for i, v := range role.Status.RetiredRoles {
+		if wait := time.Until(v.DeleteAfter.Time); wait > 0 {
+			remaining = append(remaining, v)
+			if requeueAfter == 0 || wait < requeueAfter {
+				requeueAfter = wait
+			}
+			continue
+		}
+
+		r.Log.Info("Grace period expired, deleting retired IAM role", "role", v.Name)
+		if err := awsClient.Delete(ctx, v.Name); err != nil {
+			role.Status.RetiredRoles = append(remaining, role.Status.RetiredRoles[i:]...)
+			return 0, err
+		}
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRoleDeleted, "Deleted retired IAM role %s", v.Name)
+	}
+
+	role.Status.RetiredRoles = remaining
+	return requeueAfter, nil
+}
+
+// managedRoleNames returns the names of every IAM role managed for a Role, i.e. the role recorded in the status,
+// the role for the currently generated name (which differs if a rename is in progress) and any retired roles
+// which have not been deleted yet
+func managedRoleNames(role *eksiamoperatorv1beta1.Role, name string) []string {
+	names := []string{name}
+	if len(role.Status.RoleName) > 0 && role.Status.RoleName != name {
+		names = append(names, role.Status.RoleName)
+	}
+	for _, v := range role.Status.RetiredRoles {
+		if v.Name != name && v.Name != role.Status.RoleName {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+// roleARNHasName returns true if the ARN is an IAM role ARN for a role with the given name (ignoring any path)
+func roleARNHasName(arn, name string) bool {
+	if !strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":role/") {
+		return false
+	}
+	return arn[strings.LastIndex(arn, "/")+1:] == name
+}
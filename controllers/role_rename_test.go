@@ -0,0 +1,313 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	iamtypes "github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// fakeRoleIAM implements the IAM APIs used to delete roles, for roles without policies held in memory
+type fakeRoleIAM struct {
+	internal.IAMAPI
+
+	// Whether each IAM role has the owner tag, by name
+	roles      map[string]bool
+	deleted    []string
+	failDelete map[string]bool
+}
+
+func (f *fakeRoleIAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	owned, ok := f.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, &iamtypes.NoSuchEntityException{}
+	}
+	role := &iamtypes.Role{RoleName: params.RoleName}
+	if owned {
+		role.Tags = []iamtypes.Tag{{Key: aws.String(internal.RoleOwnerTag), Value: aws.String("true")}}
+	}
+	return &iam.GetRoleOutput{Role: role}, nil
+}
+
+func (f *fakeRoleIAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	return &iam.ListAttachedRolePoliciesOutput{}, nil
+}
+
+func (f *fakeRoleIAM) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	return &iam.ListRolePoliciesOutput{}, nil
+}
+
+func (f *fakeRoleIAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	name := aws.ToString(params.RoleName)
+	if f.failDelete[name] {
+		return nil, errors.New("ServiceFailure")
+	}
+	delete(f.roles, name)
+	f.deleted = append(f.deleted, name)
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+// newRenameReconciler returns a RoleReconciler backed by a fake client holding the given service accounts
+func newRenameReconciler(t *testing.T, serviceAccounts ...client.Object) *RoleReconciler {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return &RoleReconciler{
+		Client:                fake.NewClientBuilder().WithScheme(scheme).WithObjects(serviceAccounts...).Build(),
+		Log:                   logr.Discard(),
+		Recorder:              record.NewFakeRecorder(100),
+		RoleRenameGracePeriod: time.Hour,
+	}
+}
+
+// serviceAccount returns a service account in namespace ns annotated with an IAM role ARN, if arn is not empty
+func serviceAccount(name, arn string) *corev1.ServiceAccount {
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Annotations: map[string]string{}}}
+	if len(arn) > 0 {
+		sa.Annotations[serviceAccountRoleAnnotation] = arn
+	}
+	return sa
+}
+
+func TestSeedRoleName(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   eksiamoperatorv1beta1.RoleStatus
+		expected string
+	}{
+		{name: "reconciled before the name was recorded", status: eksiamoperatorv1beta1.RoleStatus{ObservedGeneration: 3}, expected: "eks-app"},
+		{name: "recorded name", status: eksiamoperatorv1beta1.RoleStatus{ObservedGeneration: 3, RoleName: "old-app"}, expected: "old-app"},
+		{name: "never reconciled", status: eksiamoperatorv1beta1.RoleStatus{}, expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &eksiamoperatorv1beta1.Role{Status: tt.status}
+			seedRoleName(role, "eks-app")
+			if role.Status.RoleName != tt.expected {
+				t.Fatalf("expected role name %q, got %q", tt.expected, role.Status.RoleName)
+			}
+		})
+	}
+}
+
+func TestMigrateRenamedRole(t *testing.T) {
+	const (
+		previousARN = "arn:aws:iam::111111111111:role/old-app"
+		arn         = "arn:aws:iam::111111111111:role/eks-app"
+	)
+
+	tests := []struct {
+		name    string
+		status  eksiamoperatorv1beta1.RoleStatus
+		retired []string
+		moved   bool
+	}{
+		{name: "first reconcile", status: eksiamoperatorv1beta1.RoleStatus{}, retired: []string{}},
+		{name: "unchanged name", status: eksiamoperatorv1beta1.RoleStatus{RoleName: "eks-app"}, retired: []string{}},
+		{name: "renamed", status: eksiamoperatorv1beta1.RoleStatus{RoleName: "old-app"}, retired: []string{"old-app"}, moved: true},
+		{
+			// A retired name in use again is no longer deleted
+			name: "renamed back",
+			status: eksiamoperatorv1beta1.RoleStatus{
+				RoleName:     "old-app",
+				RetiredRoles: []eksiamoperatorv1beta1.RetiredRole{{Name: "eks-app"}, {Name: "older-app"}},
+			},
+			retired: []string{"older-app", "old-app"},
+			moved:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := newRenameReconciler(t, serviceAccount("app", previousARN), serviceAccount("other", "arn:aws:iam::111111111111:role/other"))
+			role := &eksiamoperatorv1beta1.Role{Spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns"}, Status: tt.status}
+
+			if err := r.migrateRenamedRole(ctx, role, "eks-app", arn); err != nil {
+				t.Fatal(err)
+			}
+			if role.Status.RoleName != "eks-app" || role.Status.RoleARN != arn {
+				t.Fatalf("expected the new role to be recorded, got %s %s", role.Status.RoleName, role.Status.RoleARN)
+			}
+
+			retired := []string{}
+			for _, v := range role.Status.RetiredRoles {
+				retired = append(retired, v.Name)
+				if v.Name == "old-app" && time.Until(v.DeleteAfter.Time) < 59*time.Minute {
+					t.Fatalf("expected old-app to be deleted after the grace period, got %s", v.DeleteAfter)
+				}
+			}
+			if len(retired) != len(tt.retired) {
+				t.Fatalf("expected retired roles %v, got %v", tt.retired, retired)
+			}
+			for i := range retired {
+				if retired[i] != tt.retired[i] {
+					t.Fatalf("expected retired roles %v, got %v", tt.retired, retired)
+				}
+			}
+
+			var sa corev1.ServiceAccount
+			if err := r.Get(ctx, client.ObjectKey{Namespace: "ns", Name: "app"}, &sa); err != nil {
+				t.Fatal(err)
+			}
+			if moved := sa.Annotations[serviceAccountRoleAnnotation] == arn; moved != tt.moved {
+				t.Fatalf("expected the service account to be moved: %t, got annotation %s", tt.moved, sa.Annotations[serviceAccountRoleAnnotation])
+			}
+		})
+	}
+}
+
+func TestUpdateServiceAccountRoleARN(t *testing.T) {
+	ctx := context.Background()
+	const arn = "arn:aws:iam::111111111111:role/eks-app"
+	r := newRenameReconciler(t,
+		serviceAccount("app", "arn:aws:iam::111111111111:role/old-app"),
+		serviceAccount("path", "arn:aws:iam::111111111111:role/team/old-app"),
+		serviceAccount("prefixed", "arn:aws:iam::111111111111:role/old-app-2"),
+		serviceAccount("unannotated", ""),
+	)
+	other := serviceAccount("app", "arn:aws:iam::111111111111:role/old-app")
+	other.Namespace = "other"
+	if err := r.Create(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+
+	role := &eksiamoperatorv1beta1.Role{Spec: eksiamoperatorv1beta1.RoleSpec{Namespace: "ns"}}
+	if err := r.updateServiceAccountRoleARN(ctx, role, "old-app", arn); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the service accounts in the namespace of the Role annotated with the previous role are moved
+	expected := map[client.ObjectKey]string{
+		{Namespace: "ns", Name: "app"}:         arn,
+		{Namespace: "ns", Name: "path"}:        arn,
+		{Namespace: "ns", Name: "prefixed"}:    "arn:aws:iam::111111111111:role/old-app-2",
+		{Namespace: "ns", Name: "unannotated"}: "",
+		{Namespace: "other", Name: "app"}:      "arn:aws:iam::111111111111:role/old-app",
+	}
+	for key, annotation := range expected {
+		var sa corev1.ServiceAccount
+		if err := r.Get(ctx, key, &sa); err != nil {
+			t.Fatal(err)
+		}
+		if sa.Annotations[serviceAccountRoleAnnotation] != annotation {
+			t.Errorf("expected service account %s to be annotated with %q, got %q", key, annotation, sa.Annotations[serviceAccountRoleAnnotation])
+		}
+	}
+}
+
+func TestDeleteRetiredRoles(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name       string
+		retired    []eksiamoperatorv1beta1.RetiredRole
+		failDelete map[string]bool
+		deleted    []string
+		remaining  []string
+		requeue    bool
+		fails      bool
+	}{
+		{name: "none", deleted: nil, remaining: []string{}},
+		{
+			name: "expired and pending",
+			retired: []eksiamoperatorv1beta1.RetiredRole{
+				{Name: "expired", DeleteAfter: metav1.NewTime(now.Add(-time.Minute))},
+				{Name: "pending", DeleteAfter: metav1.NewTime(now.Add(time.Hour))},
+				{Name: "sooner", DeleteAfter: metav1.NewTime(now.Add(time.Minute))},
+			},
+			deleted:   []string{"expired"},
+			remaining: []string{"pending", "sooner"},
+			requeue:   true,
+		},
+		{
+			name: "already deleted",
+			retired: []eksiamoperatorv1beta1.RetiredRole{
+				{Name: "missing", DeleteAfter: metav1.NewTime(now.Add(-time.Minute))},
+			},
+			remaining: []string{},
+		},
+		{
+			// Roles not yet deleted when a deletion fails are kept, to be retried
+			name: "failed deletion",
+			retired: []eksiamoperatorv1beta1.RetiredRole{
+				{Name: "pending", DeleteAfter: metav1.NewTime(now.Add(time.Hour))},
+				{Name: "expired", DeleteAfter: metav1.NewTime(now.Add(-time.Minute))},
+				{Name: "failing", DeleteAfter: metav1.NewTime(now.Add(-time.Minute))},
+				{Name: "later", DeleteAfter: metav1.NewTime(now.Add(-time.Minute))},
+			},
+			failDelete: map[string]bool{"failing": true},
+			deleted:    []string{"expired"},
+			remaining:  []string{"pending", "failing", "later"},
+			fails:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRenameReconciler(t)
+			fakeIAM := &fakeRoleIAM{roles: map[string]bool{"expired": true, "pending": true, "sooner": true, "failing": true, "later": true}, failDelete: tt.failDelete}
+			awsClient := internal.NewAWSRoleClientFromAPIs(fakeIAM, nil, logr.Discard())
+			role := &eksiamoperatorv1beta1.Role{Status: eksiamoperatorv1beta1.RoleStatus{RetiredRoles: tt.retired}}
+
+			requeueAfter, err := r.deleteRetiredRoles(context.Background(), awsClient, role)
+			if (err != nil) != tt.fails {
+				t.Fatalf("expected an error: %t, got %v", tt.fails, err)
+			}
+			if tt.requeue != (requeueAfter > 0 && requeueAfter <= time.Minute) {
+				t.Fatalf("expected a requeue when the next retired role expires: %t, got %s", tt.requeue, requeueAfter)
+			}
+			if len(fakeIAM.deleted) != len(tt.deleted) || (len(tt.deleted) > 0 && fakeIAM.deleted[0] != tt.deleted[0]) {
+				t.Fatalf("expected roles %v to be deleted, got %v", tt.deleted, fakeIAM.deleted)
+			}
+
+			remaining := []string{}
+			for _, v := range role.Status.RetiredRoles {
+				remaining = append(remaining, v.Name)
+			}
+			if len(remaining) != len(tt.remaining) {
+				t.Fatalf("expected retired roles %v to remain, got %v", tt.remaining, remaining)
+			}
+			for i := range remaining {
+				if remaining[i] != tt.remaining[i] {
+					t.Fatalf("expected retired roles %v to remain, got %v", tt.remaining, remaining)
+				}
+			}
+		})
+	}
+}
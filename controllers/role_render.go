@@ -0,0 +1,77 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// RenderedRole is the IAM role generated for a Role: its name, trust policy, inline policies and customer managed
+// policies (keyed by policy name) and tags, with the policies as JSON documents. Warnings describe problems found
+// with the Role which did not prevent it from being rendered, and Findings the overly broad permissions found by the
+// policy linter.
+type RenderedRole struct {
+	Name            string
+	TrustPolicy     string
+	InlinePolicies  map[string]string
+	ManagedPolicies map[string]string
+	Tags            map[string]string
+	Warnings        []string
+	Findings        []internal.LintFinding
+}
+
+// Render generates the IAM role for a Role, exactly as it is applied by Reconcile. No AWS or Kubernetes APIs are
+// called, so it can be used offline.
+func (r *RoleReconciler) Render(role *eksiamoperatorv1beta1.Role) (*RenderedRole, error) {
+	warnings, err := r.validateActions(role)
+	if err != nil {
+		return nil, err
+	}
+
+	trustPolicy, err := r.generateTrustPolicy(role)
+	if err != nil {
+		return nil, err
+	}
+
+	name := r.roleName(role)
+	statements, err := r.expandActions(role.Spec.Statements)
+	if err != nil {
+		return nil, err
+	}
+	policies, managed, err := r.generateInlinePolicies(name, statements)
+	if err != nil {
+		return nil, err
+	}
+
+	findings, err := r.lintPolicies(policies, managed)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RenderedRole{Name: name, TrustPolicy: trustPolicy, InlinePolicies: policies, ManagedPolicies: managed, Tags: r.roleTags(role), Warnings: warnings, Findings: findings}, nil
+}
+
+// roleTags returns the tags identifying the Role (and the cluster, when known) an IAM role is managed for
+func (r *RoleReconciler) roleTags(role *eksiamoperatorv1beta1.Role) map[string]string {
+	tags := map[string]string{internal.RoleSourceTag: role.Namespace + "/" + role.Name}
+	if len(r.ClusterName) > 0 {
+		tags[internal.RoleClusterTag] = r.ClusterName
+	}
+	return tags
+}
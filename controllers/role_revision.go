@@ -0,0 +1,252 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+//+kubebuilder:rbac:groups=eks-iam-operator.neilmcgibbon.com,resources=rolerevisions,verbs=get;list;watch;create;delete
+
+const (
+	// Longest name of a Kubernetes object
+	maxObjectNameLength = 253
+	// Longest value of a Kubernetes label
+	maxLabelValueLength = 63
+)
+
+// revisionName returns the name of a RoleRevision of a Role
+func revisionName(role *eksiamoperatorv1beta1.Role, revision int64) string {
+	suffix := fmt.Sprintf("-%d", revision)
+	return shortenName(role.Name, maxObjectNameLength-len(suffix)) + suffix
+}
+
+// revisionLabel returns the value of the RevisionRoleLabel of the RoleRevisions of a Role
+func revisionLabel(role *eksiamoperatorv1beta1.Role) string {
+	return shortenName(role.Name, maxLabelValueLength)
+}
+
+// shortenName returns a name cut to at most max characters, ending in a hash of the full name if it was cut so
+// that names sharing a long prefix stay distinct
+func shortenName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	hash := fmt.Sprintf("%08x", h.Sum32())
+	return strings.TrimRight(name[:max-len(hash)-1], "-.") + "-" + hash
+}
+
+// listRevisions returns the RoleRevisions of a Role, oldest first
+func (r *RoleReconciler) listRevisions(ctx context.Context, role *eksiamoperatorv1beta1.Role) ([]eksiamoperatorv1beta1.RoleRevision, error) {
+	var revisions eksiamoperatorv1beta1.RoleRevisionList
+	if err := r.List(ctx, &revisions, client.InNamespace(role.Namespace), client.MatchingLabels{eksiamoperatorv1beta1.RevisionRoleLabel: revisionLabel(role)}); err != nil {
+		return nil, err
+	}
+	// Ignore revisions not created by the operator for this Role, so a RoleRevision carrying the label cannot
+	// affect numbering, pruning or rollback
+	owned := make([]eksiamoperatorv1beta1.RoleRevision, 0, len(revisions.Items))
+	for _, revision := range revisions.Items {
+		if metav1.IsControlledBy(&revision, role) {
+			owned = append(owned, revision)
+		}
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		return owned[i].Spec.Revision < owned[j].Spec.Revision
+	})
+	return owned, nil
+}
+
+// recordRevision records the policies applied to the IAM role of a Role as a new RoleRevision, unless they are
+// the same as those of the latest revision, and prunes the oldest revisions beyond the history limit
+func (r *RoleReconciler) recordRevision(ctx context.Context, role *eksiamoperatorv1beta1.Role, name string, rendered *RenderedRole) error {
+	revisions, err := r.listRevisions(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	next := role.Status.CurrentRevision + 1
+	if len(revisions) > 0 {
+		latest := revisions[len(revisions)-1]
+		if revisionMatches(&latest, name, rendered) {
+			role.Status.CurrentRevision = latest.Spec.Revision
+			return nil
+		}
+		if latest.Spec.Revision >= next {
+			next = latest.Spec.Revision + 1
+		}
+	}
+
+	revision := &eksiamoperatorv1beta1.RoleRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionName(role, next),
+			Namespace: role.Namespace,
+			Labels:    map[string]string{eksiamoperatorv1beta1.RevisionRoleLabel: revisionLabel(role)},
+		},
+		Spec: eksiamoperatorv1beta1.RoleRevisionSpec{
+			Revision:        next,
+			RoleName:        name,
+			RoleGeneration:  role.Generation,
+			TrustPolicy:     rendered.TrustPolicy,
+			InlinePolicies:  rendered.InlinePolicies,
+			ManagedPolicies: rendered.ManagedPolicies,
+		},
+	}
+	if err := controllerutil.SetControllerReference(role, revision, r.Scheme); err != nil {
+		return err
+	}
+	if err := r.Create(ctx, revision); err != nil {
+		return err
+	}
+	role.Status.CurrentRevision = next
+	r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRevisionRecorded, "Recorded the policies of IAM role %s as revision %d", name, next)
+
+	return r.pruneRevisions(ctx, role, append(revisions, *revision))
+}
+
+// pruneRevisions deletes the oldest of the RoleRevisions of a Role (sorted oldest first) beyond the history limit,
+// keeping the revision the Role is rolled back to
+func (r *RoleReconciler) pruneRevisions(ctx context.Context, role *eksiamoperatorv1beta1.Role, revisions []eksiamoperatorv1beta1.RoleRevision) error {
+	for i := 0; i < len(revisions)-r.RevisionHistoryLimit; i++ {
+		if revisions[i].Spec.Revision == role.Status.RolledBackToRevision {
+			continue
+		}
+		if err := r.Delete(ctx, &revisions[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revisionMatches returns true if a RoleRevision holds the same IAM role name and policies as a rendered role
+func revisionMatches(revision *eksiamoperatorv1beta1.RoleRevision, name string, rendered *RenderedRole) bool {
+	return revision.Spec.RoleName == name &&
+		revision.Spec.TrustPolicy == rendered.TrustPolicy &&
+		policyMapsEqual(revision.Spec.InlinePolicies, rendered.InlinePolicies) &&
+		policyMapsEqual(revision.Spec.ManagedPolicies, rendered.ManagedPolicies)
+}
+
+// policyMapsEqual returns true if two maps of policy documents are the same, treating nil and empty maps alike
+func policyMapsEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRollback replaces the rendered policies of a Role with those of the RoleRevision named by its rollback
+// annotation, if it has one. The policies of the revision are linted and validated as if rendered from the spec.
+func (r *RoleReconciler) applyRollback(ctx context.Context, role *eksiamoperatorv1beta1.Role, rendered *RenderedRole) error {
+	value, ok := role.Annotations[eksiamoperatorv1beta1.RollbackAnnotation]
+	if !ok {
+		role.Status.RolledBackToRevision = 0
+		return nil
+	}
+
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 1 {
+		return newValidationError("%s annotation must be a revision number, got %q", eksiamoperatorv1beta1.RollbackAnnotation, value)
+	}
+
+	var revision eksiamoperatorv1beta1.RoleRevision
+	if err := r.Get(ctx, types.NamespacedName{Namespace: role.Namespace, Name: revisionName(role, number)}, &revision); err != nil {
+		if apierrors.IsNotFound(err) {
+			return newValidationError("revision %d of the Role does not exist", number)
+		}
+		return err
+	}
+	// Only revisions recorded by the operator for this Role are trusted, not any RoleRevision of the same name
+	if !metav1.IsControlledBy(&revision, role) || revision.Spec.Revision != number {
+		return newValidationError("RoleRevision %s is not revision %d of the Role", revision.Name, number)
+	}
+
+	rendered.TrustPolicy = revision.Spec.TrustPolicy
+	rendered.InlinePolicies = map[string]string{}
+	for k, v := range revision.Spec.InlinePolicies {
+		rendered.InlinePolicies[k] = v
+	}
+	rendered.ManagedPolicies = map[string]string{}
+	for k, v := range revision.Spec.ManagedPolicies {
+		rendered.ManagedPolicies[k] = v
+	}
+
+	if err := r.checkRevisionPolicies(rendered); err != nil {
+		return err
+	}
+
+	if role.Status.RolledBackToRevision != number {
+		r.Recorder.Eventf(role, corev1.EventTypeNormal, eventReasonRolledBackToRevision, "Applying the policies of revision %d in place of the spec", number)
+	}
+	role.Status.RolledBackToRevision = number
+	return nil
+}
+
+// checkRevisionPolicies lints the policies of a revision being rolled back to and validates their actions, as is
+// done for policies rendered from the spec, so that rolling back cannot bypass blocking findings or strict action
+// validation
+func (r *RoleReconciler) checkRevisionPolicies(rendered *RenderedRole) error {
+	findings, err := r.lintPolicies(rendered.InlinePolicies, rendered.ManagedPolicies)
+	if err != nil {
+		return err
+	}
+	rendered.Findings = findings
+
+	if r.ActionCatalog == nil || !r.StrictActionValidation {
+		return nil
+	}
+
+	unknown := []string{}
+	for _, policies := range []map[string]string{rendered.InlinePolicies, rendered.ManagedPolicies} {
+		for _, name := range internal.SortedKeys(policies) {
+			actions, err := internal.PolicyActions(policies[name])
+			if err != nil {
+				return newValidationError("policy %s: %v", name, err)
+			}
+			for _, action := range actions {
+				if problem, isUnknown := r.ActionCatalog.ValidateAction(action); isUnknown {
+					unknown = append(unknown, fmt.Sprintf("policy %s: %s", name, problem))
+				}
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return newValidationError("unknown IAM actions: %s", strings.Join(unknown, "; "))
+	}
+	return nil
+}
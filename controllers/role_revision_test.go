@@ -0,0 +1,267 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const testRevisionPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"%s","Resource":"*"}]}`
+
+// newRevisionReconciler returns a RoleReconciler backed by a fake client holding the given objects, and a Role for
+// its RoleRevisions
+func newRevisionReconciler(t *testing.T, objects ...client.Object) (*RoleReconciler, *eksiamoperatorv1beta1.Role) {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := eksiamoperatorv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &RoleReconciler{
+		Client:               fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		Scheme:               scheme,
+		Recorder:             record.NewFakeRecorder(100),
+		RevisionHistoryLimit: 3,
+	}
+	role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "app-uid"}}
+	return r, role
+}
+
+// renderedAction returns a rendered role with one inline policy allowing an action
+func renderedAction(action string) *RenderedRole {
+	return &RenderedRole{TrustPolicy: "{}", InlinePolicies: map[string]string{"app": fmt.Sprintf(testRevisionPolicy, action)}}
+}
+
+// revisionNumbers returns the numbers of the RoleRevisions in the namespace of a Role, sorted
+func revisionNumbers(t *testing.T, r *RoleReconciler, role *eksiamoperatorv1beta1.Role) []int64 {
+	t.Helper()
+
+	var revisions eksiamoperatorv1beta1.RoleRevisionList
+	if err := r.List(context.Background(), &revisions, client.InNamespace(role.Namespace)); err != nil {
+		t.Fatal(err)
+	}
+	numbers := []int64{}
+	for _, revision := range revisions.Items {
+		numbers = append(numbers, revision.Spec.Revision)
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+// foreignRevision returns a RoleRevision labelled for the Role, but not created by the operator
+func foreignRevision(number int64, action string) *eksiamoperatorv1beta1.RoleRevision {
+	return &eksiamoperatorv1beta1.RoleRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("app-%d", number),
+			Namespace: "default",
+			Labels:    map[string]string{eksiamoperatorv1beta1.RevisionRoleLabel: "app"},
+		},
+		Spec: eksiamoperatorv1beta1.RoleRevisionSpec{Revision: number, RoleName: "eks-app", TrustPolicy: "{}", InlinePolicies: map[string]string{"app": fmt.Sprintf(testRevisionPolicy, action)}},
+	}
+}
+
+func TestRecordRevision(t *testing.T) {
+	ctx := context.Background()
+	r, role := newRevisionReconciler(t, foreignRevision(9, "s3:*"))
+
+	tests := []struct {
+		name     string
+		rendered *RenderedRole
+		current  int64
+		kept     []int64
+	}{
+		{name: "first revision", rendered: renderedAction("s3:GetObject"), current: 1, kept: []int64{1, 9}},
+		{name: "unchanged policies", rendered: renderedAction("s3:GetObject"), current: 1, kept: []int64{1, 9}},
+		{name: "changed policies", rendered: renderedAction("s3:PutObject"), current: 2, kept: []int64{1, 2, 9}},
+		{name: "changed back", rendered: renderedAction("s3:GetObject"), current: 3, kept: []int64{1, 2, 3, 9}},
+		// The oldest revision beyond the history limit is pruned, but never a revision the operator did not record
+		{name: "pruned", rendered: renderedAction("s3:ListBucket"), current: 4, kept: []int64{2, 3, 4, 9}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := r.recordRevision(ctx, role, "eks-app", tt.rendered); err != nil {
+				t.Fatal(err)
+			}
+			if role.Status.CurrentRevision != tt.current {
+				t.Fatalf("expected current revision %d, got %d", tt.current, role.Status.CurrentRevision)
+			}
+			if kept := revisionNumbers(t, r, role); fmt.Sprint(kept) != fmt.Sprint(tt.kept) {
+				t.Fatalf("expected revisions %v, got %v", tt.kept, kept)
+			}
+		})
+	}
+}
+
+func TestRecordRevisionContinuesNumbering(t *testing.T) {
+	// A Role whose status was lost continues from its latest revision, rather than reusing its numbers
+	r, role := newRevisionReconciler(t)
+	for _, action := range []string{"s3:GetObject", "s3:PutObject"} {
+		if err := r.recordRevision(context.Background(), role, "eks-app", renderedAction(action)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	role.Status.CurrentRevision = 0
+	if err := r.recordRevision(context.Background(), role, "eks-app", renderedAction("s3:ListBucket")); err != nil {
+		t.Fatal(err)
+	}
+	if role.Status.CurrentRevision != 3 {
+		t.Fatalf("expected revision 3, got %d", role.Status.CurrentRevision)
+	}
+}
+
+func TestPruneRevisionsKeepsRolledBackRevision(t *testing.T) {
+	r, role := newRevisionReconciler(t)
+	r.RevisionHistoryLimit = 2
+
+	role.Status.RolledBackToRevision = 1
+	for _, action := range []string{"s3:GetObject", "s3:PutObject", "s3:ListBucket", "s3:DeleteObject"} {
+		if err := r.recordRevision(context.Background(), role, "eks-app", renderedAction(action)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if kept := revisionNumbers(t, r, role); fmt.Sprint(kept) != fmt.Sprint([]int64{1, 3, 4}) {
+		t.Fatalf("expected the rolled back revision to be kept, got %v", kept)
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	catalog, err := internal.DefaultActionCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		annotation string
+		record     []string
+		foreign    *eksiamoperatorv1beta1.RoleRevision
+		strict     bool
+		expected   string
+		invalid    string
+	}{
+		{name: "recorded revision", annotation: "1", record: []string{"s3:GetObject", "s3:PutObject"}, expected: "s3:GetObject"},
+		{name: "not a number", annotation: "latest", record: []string{"s3:GetObject"}, invalid: "must be a revision number"},
+		{name: "missing revision", annotation: "2", record: []string{"s3:GetObject"}, invalid: "does not exist"},
+		{name: "revision not recorded by the operator", annotation: "1", foreign: foreignRevision(1, "s3:*"), invalid: "is not revision 1"},
+		{
+			name:       "unknown actions with strict validation",
+			annotation: "1",
+			record:     []string{"s3:GetObjekt", "s3:GetObject"},
+			strict:     true,
+			invalid:    "unknown IAM actions",
+		},
+		{name: "unknown actions without strict validation", annotation: "1", record: []string{"s3:GetObjekt", "s3:GetObject"}, expected: "s3:GetObjekt"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			objects := []client.Object{}
+			if tt.foreign != nil {
+				objects = append(objects, tt.foreign)
+			}
+			r, role := newRevisionReconciler(t, objects...)
+			for _, action := range tt.record {
+				if err := r.recordRevision(ctx, role, "eks-app", renderedAction(action)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r.ActionCatalog = catalog
+			r.StrictActionValidation = tt.strict
+
+			role.Annotations = map[string]string{eksiamoperatorv1beta1.RollbackAnnotation: tt.annotation}
+			rendered := renderedAction("s3:ListBucket")
+			err := r.applyRollback(ctx, role, rendered)
+
+			if tt.invalid != "" {
+				var invalid *validationError
+				if !errors.As(err, &invalid) || !strings.Contains(err.Error(), tt.invalid) {
+					t.Fatalf("expected a validation error containing %q, got %v", tt.invalid, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(rendered.InlinePolicies["app"], tt.expected) {
+				t.Fatalf("expected the policies of the revision to be applied, got %v", rendered.InlinePolicies)
+			}
+			if role.Status.RolledBackToRevision != 1 {
+				t.Fatalf("expected the Role to be rolled back to revision 1, got %d", role.Status.RolledBackToRevision)
+			}
+
+			// Removing the annotation stops the rollback
+			delete(role.Annotations, eksiamoperatorv1beta1.RollbackAnnotation)
+			if err := r.applyRollback(ctx, role, rendered); err != nil || role.Status.RolledBackToRevision != 0 {
+				t.Fatalf("expected the rollback to stop, got revision %d and %v", role.Status.RolledBackToRevision, err)
+			}
+		})
+	}
+}
+
+func TestRevisionNames(t *testing.T) {
+	long := strings.Repeat("a", 250)
+	tests := []struct {
+		name  string
+		role  string
+		label string
+	}{
+		{name: "short name", role: "app", label: "app"},
+		{name: "long name", role: long},
+		{name: "long name with the same prefix", role: long + "b"},
+	}
+
+	names := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := &eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: tt.role}}
+			name, label := revisionName(role, 12345), revisionLabel(role)
+			if len(name) > maxObjectNameLength || len(label) > maxLabelValueLength {
+				t.Fatalf("expected a name of at most %d and a label of at most %d characters, got %d and %d", maxObjectNameLength, maxLabelValueLength, len(name), len(label))
+			}
+			if !strings.HasSuffix(name, "-12345") {
+				t.Fatalf("expected the name to end in the revision, got %s", name)
+			}
+			if tt.label != "" && label != tt.label {
+				t.Fatalf("expected label %s, got %s", tt.label, label)
+			}
+			if names[name] {
+				t.Fatalf("expected distinct Roles to have distinct revision names, got %s twice", name)
+			}
+			names[name] = true
+		})
+	}
+
+}
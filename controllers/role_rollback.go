@@ -0,0 +1,50 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// recordRollback records the outcome of rolling back a failed change to the IAM role of a Role in its status and
+// as an event. Errors from failures before any change was made are ignored.
+func (r *RoleReconciler) recordRollback(role *eksiamoperatorv1beta1.Role, name string, err error) {
+	var rollbackErr *internal.RollbackError
+	if !errors.As(err, &rollbackErr) {
+		return
+	}
+
+	role.Status.LastRollback = &eksiamoperatorv1beta1.RollbackStatus{
+		Time:      metav1.Now(),
+		Error:     rollbackErr.Err.Error(),
+		Succeeded: rollbackErr.RollbackErr == nil,
+	}
+
+	if rollbackErr.RollbackErr != nil {
+		role.Status.LastRollback.RollbackError = rollbackErr.RollbackErr.Error()
+		r.Recorder.Eventf(role, corev1.EventTypeWarning, eventReasonRollbackFailed, "Failed to roll back IAM role %s: %v", name, rollbackErr.RollbackErr)
+		return
+	}
+	r.Recorder.Eventf(role, corev1.EventTypeWarning, eventReasonRolledBack, "Rolled back IAM role %s after a failed change: %v", name, rollbackErr.Err)
+}
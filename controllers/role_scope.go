@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// namespaceInScope returns true if the Roles of a namespace are reconciled, i.e. the labels of the namespace match
+// the namespace selector (if any)
+func (r *RoleReconciler) namespaceInScope(ctx context.Context, name string) (bool, error) {
+	if r.NamespaceSelector == nil {
+		return true, nil
+	}
+
+	var namespace corev1.Namespace
+	if err := r.Get(ctx, client.ObjectKey{Name: name}, &namespace); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return r.NamespaceSelector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// namespaceRoles returns a request for every Role in a namespace, so that they are reconciled when the labels of the
+// namespace change
+func (r *RoleReconciler) namespaceRoles(ctx context.Context, o client.Object) []reconcile.Request {
+	var roles eksiamoperatorv1beta1.RoleList
+	if err := r.List(ctx, &roles, client.InNamespace(o.GetName())); err != nil {
+		r.Log.Error(err, "Unable to list the Roles of a namespace", "namespace", o.GetName())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(roles.Items))
+	for _, v := range roles.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&v)})
+	}
+	return requests
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+// newScopeClient returns a fake client holding a namespace labelled team=payments, an unlabelled namespace, and
+// a Role in each
+func newScopeClient(t *testing.T) client.Client {
+	t.Helper()
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := eksiamoperatorv1beta1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments", Labels: map[string]string{"team": "payments"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "search"}},
+		&eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "payments"}},
+		&eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "payments"}},
+		&eksiamoperatorv1beta1.Role{ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "search"}},
+	).Build()
+}
+
+func TestNamespaceInScope(t *testing.T) {
+	selector := labels.SelectorFromSet(labels.Set{"team": "payments"})
+
+	tests := []struct {
+		name      string
+		selector  labels.Selector
+		namespace string
+		expected  bool
+	}{
+		{name: "no selector", selector: nil, namespace: "search", expected: true},
+		{name: "no selector and missing namespace", selector: nil, namespace: "missing", expected: true},
+		{name: "matching labels", selector: selector, namespace: "payments", expected: true},
+		{name: "other labels", selector: selector, namespace: "search", expected: false},
+		// A deleted namespace is out of scope, rather than an error
+		{name: "missing namespace", selector: selector, namespace: "missing", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &RoleReconciler{Client: newScopeClient(t), NamespaceSelector: tt.selector}
+			inScope, err := r.namespaceInScope(context.Background(), tt.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inScope != tt.expected {
+				t.Fatalf("expected namespace %s in scope: %t, got %t", tt.namespace, tt.expected, inScope)
+			}
+		})
+	}
+}
+
+func TestNamespaceRoles(t *testing.T) {
+	r := &RoleReconciler{Client: newScopeClient(t)}
+
+	requests := r.namespaceRoles(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "payments"}})
+	if len(requests) != 2 {
+		t.Fatalf("expected a request for each Role in the namespace, got %v", requests)
+	}
+	for _, v := range requests {
+		if v.Namespace != "payments" {
+			t.Fatalf("expected only Roles in the namespace, got %v", requests)
+		}
+	}
+}
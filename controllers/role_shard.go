@@ -0,0 +1,282 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+)
+
+const (
+	// shardLeaseLabel is set on the Leases of the replicas sharing the Roles
+	shardLeaseLabel = "eks-iam-operator.neilmcgibbon.com/shard-lease"
+
+	// shardLeasePrefix is prepended to the identity of a replica to name its Lease
+	shardLeasePrefix = "eks-iam-operator-shard-"
+
+	// garbageCollectorShardKey is the key of the shard which runs garbage collection
+	garbageCollectorShardKey = "garbage-collector"
+
+	// staleLeaseDurations is how many lease durations a Lease must go unrenewed before it is deleted, e.g. after its
+	// replica crashed, so rollouts do not leave Leases behind
+	staleLeaseDurations = 10
+)
+
+// RoleShards splits the Roles between the replicas of the operator, so that each reconciles the Roles it owns rather
+// than the leader reconciling every Role. Each replica renews a Lease, and the replicas whose Lease has been renewed
+// within the lease duration are the members. A Role is owned by the member with the highest hash of its identity
+// and the Role's namespace/name (rendezvous hashing), so only the Roles of a replica joining or leaving move.
+type RoleShards struct {
+	// Reader used to list Leases, which should read from the API server rather than the cache
+	Reader client.Reader
+	Client client.Client
+	Log    logr.Logger
+
+	// Identity of this replica, e.g. its pod name, and the namespace of the Leases
+	Identity  string
+	Namespace string
+
+	// How long a Lease is valid without being renewed
+	LeaseDuration time.Duration
+
+	// Called when the members change, so the Roles can be reconciled by their new owners. It runs in a single
+	// worker goroutine, so calls never overlap, and changes made while it runs are coalesced into one more call.
+	OnChange func(ctx context.Context)
+
+	mu       sync.RWMutex
+	members  []string
+	observed map[string]leaseObservation
+	changed  chan struct{}
+}
+
+// leaseObservation is the renew time of a Lease, and when it was last seen to change by this replica. Leases are
+// judged by the local clock, so clock skew between replicas does not matter.
+type leaseObservation struct {
+	renewTime time.Time
+	changedAt time.Time
+}
+
+// NeedLeaderElection makes the shards run on every replica
+func (s *RoleShards) NeedLeaderElection() bool {
+	return false
+}
+
+// Start renews the Lease of this replica and refreshes the members until the context is cancelled, then deletes the
+// Lease so that the Roles of this replica move to the others without waiting for it to expire
+func (s *RoleShards) Start(ctx context.Context) error {
+	s.observed = map[string]leaseObservation{}
+	s.changed = make(chan struct{}, 1)
+	go s.notifyChanges(ctx)
+
+	ticker := time.NewTicker(s.LeaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		if err := s.renew(ctx); err != nil {
+			s.Log.Error(err, "Unable to renew the shard lease")
+		}
+		if err := s.refresh(ctx); err != nil {
+			s.Log.Error(err, "Unable to list the shard leases")
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.release()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Owns returns true if a key (the namespace/name of a Role) is owned by this replica. Nothing is owned until the
+// members are first known.
+func (s *RoleShards) Owns(key string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	owner, best := "", uint64(0)
+	for _, member := range s.members {
+		if weight := shardWeight(member, key); len(owner) == 0 || weight > best {
+			owner, best = member, weight
+		}
+	}
+	return len(owner) > 0 && owner == s.Identity
+}
+
+// shardWeight returns the rendezvous hashing weight of a member for a key. FNV-1a is mixed with the murmur3
+// finalizer, as its high bits alone do not spread similar inputs evenly.
+func shardWeight(member, key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// renew creates or renews the Lease of this replica
+func (s *RoleShards) renew(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(s.LeaseDuration.Seconds())
+
+	var lease coordinationv1.Lease
+	err := s.Reader.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: shardLeasePrefix + s.Identity}, &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardLeasePrefix + s.Identity,
+				Namespace: s.Namespace,
+				Labels:    map[string]string{shardLeaseLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &s.Identity,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return s.Client.Create(ctx, &lease)
+	}
+	if err != nil {
+		return err
+	}
+
+	lease.Spec.HolderIdentity = &s.Identity
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	return s.Client.Update(ctx, &lease)
+}
+
+// refresh lists the Leases to find the members, calling OnChange when they change
+func (s *RoleShards) refresh(ctx context.Context) error {
+	var leases coordinationv1.LeaseList
+	if err := s.Reader.List(ctx, &leases, client.InNamespace(s.Namespace), client.MatchingLabels{shardLeaseLabel: "true"}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	observed := map[string]leaseObservation{}
+	members := []string{}
+	for _, v := range leases.Items {
+		if v.Spec.HolderIdentity == nil || v.Spec.RenewTime == nil {
+			continue
+		}
+		duration := s.LeaseDuration
+		if v.Spec.LeaseDurationSeconds != nil {
+			duration = time.Duration(*v.Spec.LeaseDurationSeconds) * time.Second
+		}
+
+		o, ok := s.observed[v.Name]
+		if !ok || !o.renewTime.Equal(v.Spec.RenewTime.Time) {
+			o = leaseObservation{renewTime: v.Spec.RenewTime.Time, changedAt: now}
+		}
+		observed[v.Name] = o
+
+		if now.Sub(o.changedAt) <= duration {
+			members = append(members, *v.Spec.HolderIdentity)
+			continue
+		}
+
+		// The Lease is only deleted if it was not renewed since it was listed
+		if now.Sub(o.changedAt) > staleLeaseDurations*duration {
+			s.Log.Info("Deleting stale shard lease", "lease", v.Name, "renewTime", v.Spec.RenewTime.Time)
+			lease := v
+			if err := s.Client.Delete(ctx, &lease, client.Preconditions{ResourceVersion: &lease.ResourceVersion}); err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+				s.Log.Error(err, "Unable to delete the stale shard lease", "lease", v.Name)
+			}
+		}
+	}
+	sort.Strings(members)
+	s.observed = observed
+
+	s.mu.Lock()
+	changed := !reflect.DeepEqual(members, s.members)
+	s.members = members
+	s.mu.Unlock()
+
+	shardReplicas.Set(float64(len(members)))
+	if changed {
+		s.Log.Info("Shard members changed, rebalancing Roles", "members", members)
+		// The worker is signalled without blocking, as the Lease must keep being renewed while the Roles are queued
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// notifyChanges calls OnChange once for each signalled change of members (or once for several signalled while it
+// runs), until the context is cancelled
+func (s *RoleShards) notifyChanges(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.changed:
+			if s.OnChange != nil {
+				s.OnChange(ctx)
+			}
+		}
+	}
+}
+
+// release deletes the Lease of this replica
+func (s *RoleShards) release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Name: shardLeasePrefix + s.Identity, Namespace: s.Namespace}}
+	if err := s.Client.Delete(ctx, lease); err != nil && !apierrors.IsNotFound(err) {
+		s.Log.Error(err, "Unable to delete the shard lease")
+	}
+	return nil
+}
+
+// resyncAll reconciles every Role again, e.g. after the shard members change
+func (r *RoleReconciler) resyncAll(ctx context.Context) {
+	var roles eksiamoperatorv1beta1.RoleList
+	if err := r.List(ctx, &roles); err != nil {
+		r.Log.Error(err, "Unable to list the Roles to reconcile")
+		return
+	}
+	for i := range roles.Items {
+		select {
+		case r.resync <- event.GenericEvent{Object: &roles.Items[i]}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestShardOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []string
+	}{
+		{name: "one member", members: []string{"a"}},
+		{name: "two members", members: []string{"a", "b"}},
+		{name: "five members", members: []string{"a", "b", "c", "d", "e"}},
+		{name: "similar names", members: []string{"operator-0", "operator-1", "operator-2"}},
+	}
+
+	const keys = 3000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owned := map[string]int{}
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprintf("default/role-%d", i)
+				owners := 0
+				for _, member := range tt.members {
+					s := &RoleShards{Identity: member, members: tt.members}
+					if s.Owns(key) {
+						owned[member]++
+						owners++
+					}
+				}
+				if owners != 1 {
+					t.Fatalf("expected %s to have one owner, got %d", key, owners)
+				}
+			}
+
+			// Each member owns its share of the keys, give or take 20%
+			share := keys / len(tt.members)
+			for _, member := range tt.members {
+				if owned[member] < share*8/10 || owned[member] > share*12/10 {
+					t.Errorf("expected %s to own about %d keys, got %d", member, share, owned[member])
+				}
+			}
+		})
+	}
+}
+
+func TestShardOwnershipMovesOnlyLeavingMember(t *testing.T) {
+	before, after := []string{"a", "b", "c"}, []string{"a", "c"}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("default/role-%d", i)
+		for _, member := range after {
+			ownedBefore := (&RoleShards{Identity: member, members: before}).Owns(key)
+			ownedAfter := (&RoleShards{Identity: member, members: after}).Owns(key)
+			if ownedBefore && !ownedAfter {
+				t.Fatalf("expected %s to keep %s when b leaves", member, key)
+			}
+		}
+	}
+}
+
+func TestShardOwnsNothingWithoutMembers(t *testing.T) {
+	if (&RoleShards{Identity: "a"}).Owns("default/role") {
+		t.Fatal("expected nothing to be owned before the members are known")
+	}
+}
+
+func TestShardRefresh(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	lease := func(identity string) *coordinationv1.Lease {
+		seconds := int32(30)
+		renewTime := metav1.NewMicroTime(renewed)
+		return &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: shardLeasePrefix + identity, Namespace: "operator", Labels: map[string]string{shardLeaseLabel: "true"}},
+			Spec:       coordinationv1.LeaseSpec{HolderIdentity: &identity, LeaseDurationSeconds: &seconds, RenewTime: &renewTime},
+		}
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(lease("a"), lease("b"), lease("c"), lease("d")).Build()
+
+	s := &RoleShards{
+		Reader: c, Client: c, Log: logr.Discard(),
+		Identity: "a", Namespace: "operator", LeaseDuration: 30 * time.Second,
+		members: []string{"a", "b", "c", "d"},
+		changed: make(chan struct{}, 1),
+		// Leases are judged by when this replica saw them last change: a just now (or never, for a new Lease), b
+		// within the lease duration, c expired, and d expired well past the lease duration
+		observed: map[string]leaseObservation{
+			shardLeasePrefix + "b": {renewTime: renewed, changedAt: time.Now().Add(-10 * time.Second)},
+			shardLeasePrefix + "c": {renewTime: renewed, changedAt: time.Now().Add(-time.Minute)},
+			shardLeasePrefix + "d": {renewTime: renewed, changedAt: time.Now().Add(-time.Hour)},
+		},
+	}
+
+	if err := s.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.members) != 2 || s.members[0] != "a" || s.members[1] != "b" {
+		t.Fatalf("expected the members with current leases, got %v", s.members)
+	}
+	select {
+	case <-s.changed:
+	default:
+		t.Fatal("expected the change of members to be signalled")
+	}
+
+	var leases coordinationv1.LeaseList
+	if err := c.List(ctx, &leases, client.InNamespace("operator")); err != nil {
+		t.Fatal(err)
+	}
+	if len(leases.Items) != 3 {
+		t.Fatalf("expected only the stale lease of d to be deleted, got %d leases", len(leases.Items))
+	}
+	for _, v := range leases.Items {
+		if v.Name == shardLeasePrefix+"d" {
+			t.Fatal("expected the stale lease of d to be deleted")
+		}
+	}
+
+	// Refreshing again without a change does not signal
+	if err := s.refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.changed:
+		t.Fatal("expected no change of members to be signalled")
+	default:
+	}
+}
+
+func TestShardChangesCoalesce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	calls := make(chan struct{}, 10)
+	release := make(chan struct{})
+	s := &RoleShards{changed: make(chan struct{}, 1)}
+	s.OnChange = func(ctx context.Context) {
+		calls <- struct{}{}
+		<-release
+	}
+	go s.notifyChanges(ctx)
+
+	// Changes signalled while OnChange runs are coalesced into a single further call
+	s.changed <- struct{}{}
+	<-calls
+	for i := 0; i < 3; i++ {
+		select {
+		case s.changed <- struct{}{}:
+		default:
+		}
+	}
+	release <- struct{}{}
+	<-calls
+	release <- struct{}{}
+
+	select {
+	case <-calls:
+		t.Fatal("expected the changes signalled during a call to be coalesced")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-logr/logr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+)
+
+// exitDrift is the exit code of the diff subcommand when an IAM role differs from its Role
+const exitDrift = 3
+
+// runDiff implements the diff subcommand, printing a unified diff between the IAM roles rendered for Roles (read
+// from manifests or the cluster) and the current IAM roles. It returns the process exit code: exitOK when every
+// IAM role is in sync, and exitDrift when any differs.
+func runDiff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manager diff -config <file> (-from-cluster [-namespace <namespace>] | <role.yaml>...)")
+		flags.PrintDefaults()
+	}
+	configFlag := flags.String("config", "", "The operator config file used to render the roles.")
+	fromClusterFlag := flags.Bool("from-cluster", false, "Read the Roles from the cluster (using the current kubeconfig), rather than manifest files.")
+	namespaceFlag := flags.String("namespace", "", "Only read Roles in this namespace, with -from-cluster.")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *fromClusterFlag == (flags.NArg() > 0) {
+		flags.Usage()
+		return exitUsage
+	}
+
+	ctrlConfig, err := loadCLIConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load the config file: %v\n", err)
+		return exitError
+	}
+
+	ctx := context.Background()
+
+	var roles []eksiamoperatorv1beta1.Role
+	if *fromClusterFlag {
+		roles, err = listClusterRoles(ctx, *namespaceFlag)
+	} else {
+		roles, err = readRoleManifests(flags.Args())
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	awsClient, err := internal.NewAWSRoleClient(ctx, logr.Discard())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create AWS client: %v\n", err)
+		return exitError
+	}
+
+	reconciler, err := newRoleReconciler(ctrlConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure the Role reconciler: %v\n", err)
+		return exitError
+	}
+	return diffRoles(ctx, reconciler, awsClient, roles, os.Stdout)
+}
+
+// diffRoles writes the diffs of Roles to w, returning the exit code of the diff subcommand
+func diffRoles(ctx context.Context, reconciler *controllers.RoleReconciler, awsClient *internal.AWSRoleClient, roles []eksiamoperatorv1beta1.Role, w io.Writer) int {
+	drifted := 0
+	for i := range roles {
+		diff, notes, err := diffRole(ctx, reconciler, awsClient, &roles[i])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "role %s/%s: %v\n", roles[i].Namespace, roles[i].Name, err)
+			return exitError
+		}
+		fmt.Fprint(w, notes)
+		if len(diff) > 0 {
+			fmt.Fprint(w, diff)
+			drifted++
+		}
+	}
+
+	if drifted > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d IAM roles differ from their Roles\n", drifted, len(roles))
+		return exitDrift
+	}
+	return exitOK
+}
+
+// diffRole renders a Role and returns a unified diff between the current IAM role and the rendered role, or an
+// empty string if they match, and notes on the IAM role which are not drift (the managed policies attached to it
+// which the operator leaves alone). An IAM role without the owner tag differs, as the controller will not update
+// it. When the role name has changed but the Role has not been reconciled since (its status still has the previous
+// name), the IAM role of the previous name is diffed, as that is the role being renamed.
+func diffRole(ctx context.Context, reconciler *controllers.RoleReconciler, awsClient *internal.AWSRoleClient, role *eksiamoperatorv1beta1.Role) (string, string, error) {
+	rendered, err := reconciler.Render(role)
+	if err != nil {
+		return "", "", err
+	}
+
+	name, header := rendered.Name, ""
+	if len(role.Status.RoleName) > 0 && role.Status.RoleName != rendered.Name {
+		name = role.Status.RoleName
+		header = fmt.Sprintf("# IAM role %s will be renamed to %s\n", name, rendered.Name)
+	}
+
+	current, err := awsClient.GetRoleState(ctx, name)
+	if err != nil {
+		return "", "", err
+	}
+
+	// The controller refuses to update an IAM role it does not own
+	if current != nil {
+		if _, owned := current.Tags[internal.RoleOwnerTag]; !owned {
+			header += fmt.Sprintf("# IAM role %s is not tagged as managed by the operator, which will not update it\n", name)
+		}
+	}
+
+	notes := ""
+	for _, arn := range internal.ForeignPolicies(current) {
+		notes += fmt.Sprintf("# IAM role %s also has managed policy %s attached, which the operator leaves alone\n", name, arn)
+	}
+
+	return header + internal.DiffRoleStates(rendered.Name, current, internal.DesiredRoleState(rendered.TrustPolicy, rendered.InlinePolicies, rendered.ManagedPolicies, rendered.Tags)), notes, nil
+}
+
+// listClusterRoles lists the Roles in the cluster, in every namespace if namespace is empty
+func listClusterRoles(ctx context.Context, namespace string) ([]eksiamoperatorv1beta1.Role, error) {
+	restConfig, err := ctrl.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+
+	var list eksiamoperatorv1beta1.RoleList
+	if err := c.List(ctx, &list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
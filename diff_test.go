@@ -0,0 +1,232 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+)
+
+// fakeIAM implements the read-only IAM APIs used by the diff subcommand, for IAM roles held in memory
+type fakeIAM struct {
+	internal.IAMAPI
+
+	roles    map[string]*internal.RoleState
+	getError error
+}
+
+func (f *fakeIAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	if f.getError != nil {
+		return nil, f.getError
+	}
+	state, ok := f.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	role := &types.Role{RoleName: params.RoleName, AssumeRolePolicyDocument: aws.String(url.QueryEscape(state.TrustPolicy))}
+	for k, v := range state.Tags {
+		role.Tags = append(role.Tags, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return &iam.GetRoleOutput{Role: role}, nil
+}
+
+func (f *fakeIAM) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	return &iam.ListRolePoliciesOutput{PolicyNames: internal.SortedKeys(f.roles[aws.ToString(params.RoleName)].InlinePolicies)}, nil
+}
+
+func (f *fakeIAM) GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error) {
+	doc := f.roles[aws.ToString(params.RoleName)].InlinePolicies[aws.ToString(params.PolicyName)]
+	return &iam.GetRolePolicyOutput{PolicyDocument: aws.String(url.QueryEscape(doc))}, nil
+}
+
+func (f *fakeIAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	out := &iam.ListAttachedRolePoliciesOutput{}
+	for _, arn := range f.roles[aws.ToString(params.RoleName)].AttachedPolicies {
+		out.AttachedPolicies = append(out.AttachedPolicies, types.AttachedPolicy{PolicyArn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+// testDiffRole returns a Role and a reconciler for it, and the IAM role the operator renders for it
+func testDiffRole(t *testing.T) (*controllers.RoleReconciler, *eksiamoperatorv1beta1.Role, *internal.RoleState) {
+	t.Helper()
+
+	reconciler := &controllers.RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+	role := &eksiamoperatorv1beta1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "payments"},
+		Spec: eksiamoperatorv1beta1.RoleSpec{
+			Namespace:       "payments",
+			ServiceAccounts: []string{"app"},
+			Statements:      map[string][]eksiamoperatorv1beta1.StatementSpec{"s3": {{Actions: []string{"s3:GetObject"}, Resources: []string{"arn:aws:s3:::payments/*"}}}},
+		},
+	}
+	rendered, err := reconciler.Render(role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := map[string]string{internal.RoleOwnerTag: "true"}
+	for k, v := range rendered.Tags {
+		tags[k] = v
+	}
+	return reconciler, role, &internal.RoleState{TrustPolicy: rendered.TrustPolicy, InlinePolicies: rendered.InlinePolicies, Tags: tags}
+}
+
+func TestDiffRole(t *testing.T) {
+	tests := []struct {
+		name string
+		// Modifies the IAM role, named eks-app, and the Role
+		setup    func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState
+		diff     []string
+		notes    []string
+		expected int
+	}{
+		{
+			name: "in sync",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				return map[string]*internal.RoleState{"eks-app": state}
+			},
+			expected: exitOK,
+		},
+		{
+			name: "foreign managed policy",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				state.AttachedPolicies = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+				return map[string]*internal.RoleState{"eks-app": state}
+			},
+			notes:    []string{"also has managed policy arn:aws:iam::aws:policy/ReadOnlyAccess attached"},
+			expected: exitOK,
+		},
+		{
+			name: "missing role",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				return map[string]*internal.RoleState{}
+			},
+			diff:     []string{"+++ b/eks-app/trust-policy", "s3:GetObject"},
+			expected: exitDrift,
+		},
+		{
+			name: "changed inline policy",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				state.InlinePolicies = map[string]string{"s3": strings.Replace(state.InlinePolicies["s3"], "GetObject", "PutObject", 1)}
+				return map[string]*internal.RoleState{"eks-app": state}
+			},
+			diff:     []string{"+++ b/eks-app/inline-policies/s3", "s3:PutObject"},
+			expected: exitDrift,
+		},
+		{
+			name: "not owned",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				delete(state.Tags, internal.RoleOwnerTag)
+				return map[string]*internal.RoleState{"eks-app": state}
+			},
+			diff:     []string{"# IAM role eks-app is not tagged as managed by the operator"},
+			expected: exitDrift,
+		},
+		{
+			name: "renamed",
+			setup: func(state *internal.RoleState, role *eksiamoperatorv1beta1.Role) map[string]*internal.RoleState {
+				// The Role was renamed from the IAM role legacy-app, which is otherwise in sync
+				role.Status.RoleName = "legacy-app"
+				return map[string]*internal.RoleState{"legacy-app": state}
+			},
+			diff:     []string{"# IAM role legacy-app will be renamed to eks-app"},
+			expected: exitDrift,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reconciler, role, state := testDiffRole(t)
+			awsClient := internal.NewAWSRoleClientFromAPIs(&fakeIAM{roles: tt.setup(state, role)}, nil, logr.Discard())
+
+			diff, notes, err := diffRole(context.Background(), reconciler, awsClient, role)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.diff) == 0 && len(diff) > 0 {
+				t.Fatalf("expected no diff, got\n%s", diff)
+			}
+			for _, v := range tt.diff {
+				if !strings.Contains(diff, v) {
+					t.Fatalf("expected the diff to contain %q, got\n%s", v, diff)
+				}
+			}
+			for _, v := range tt.notes {
+				if !strings.Contains(notes, v) {
+					t.Fatalf("expected the notes to contain %q, got\n%s", v, notes)
+				}
+			}
+
+			var out bytes.Buffer
+			if code := diffRoles(context.Background(), reconciler, awsClient, []eksiamoperatorv1beta1.Role{*role}, &out); code != tt.expected {
+				t.Fatalf("expected exit code %d, got %d", tt.expected, code)
+			}
+			if out.String() != notes+diff {
+				t.Fatalf("expected the notes and diff to be written, got\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestDiffRolesError(t *testing.T) {
+	reconciler, role, _ := testDiffRole(t)
+	awsClient := internal.NewAWSRoleClientFromAPIs(&fakeIAM{getError: errors.New("AccessDenied")}, nil, logr.Discard())
+
+	if code := diffRoles(context.Background(), reconciler, awsClient, []eksiamoperatorv1beta1.Role{*role}, &bytes.Buffer{}); code != exitError {
+		t.Fatalf("expected exit code %d, got %d", exitError, code)
+	}
+}
+
+func TestRunDiffUsage(t *testing.T) {
+	config := writeTestFile(t, "config.yaml", testConfig)
+	manifest := writeTestFile(t, "role.yaml", testRoleManifest)
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "no Roles", args: []string{"-config", config}, expected: exitUsage},
+		{name: "manifests and cluster", args: []string{"-config", config, "-from-cluster", manifest}, expected: exitUsage},
+		{name: "unknown flag", args: []string{"-output", "json", manifest}, expected: exitUsage},
+		{name: "no config", args: []string{manifest}, expected: exitError},
+		{name: "missing manifest", args: []string{"-config", config, "missing.yaml"}, expected: exitError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runDiff(tt.args); code != tt.expected {
+				t.Fatalf("expected exit code %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
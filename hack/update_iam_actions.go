@@ -0,0 +1,104 @@
+//go:build ignore
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// update_iam_actions regenerates the IAM action catalog from the service map of the AWS Policy Generator, e.g.
+//
+//	go run hack/update_iam_actions.go internal/iam_actions.json
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const policyGeneratorURL = "https://awspolicygen.s3.amazonaws.com/js/policies.js"
+
+// policyGeneratorConfig is the part of the AWS Policy Generator config listing the actions of each service
+type policyGeneratorConfig struct {
+	ServiceMap map[string]struct {
+		StringPrefix string   `json:"StringPrefix"`
+		Actions      []string `json:"Actions"`
+	} `json:"serviceMap"`
+}
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: go run hack/update_iam_actions.go <catalog.json>")
+		os.Exit(2)
+	}
+
+	if err := update(os.Args[1]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func update(path string) error {
+	resp, err := http.Get(policyGeneratorURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", policyGeneratorURL, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// The config is assigned to a JavaScript variable, so the JSON object is decoded from its first brace
+	start := bytes.IndexByte(body, '{')
+	if start < 0 {
+		return fmt.Errorf("GET %s: no policy generator config found", policyGeneratorURL)
+	}
+	var config policyGeneratorConfig
+	if err := json.NewDecoder(bytes.NewReader(body[start:])).Decode(&config); err != nil {
+		return err
+	}
+
+	services := map[string][]string{}
+	for _, v := range config.ServiceMap {
+		prefix := strings.ToLower(v.StringPrefix)
+		services[prefix] = append(services[prefix], v.Actions...)
+	}
+	for prefix, actions := range services {
+		unique := map[string]bool{}
+		for _, v := range actions {
+			unique[v] = true
+		}
+		services[prefix] = make([]string, 0, len(unique))
+		for v := range unique {
+			services[prefix] = append(services[prefix], v)
+		}
+		sort.Strings(services[prefix])
+	}
+
+	out, err := json.MarshalIndent(map[string]interface{}{"services": services}, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d services\n", len(services))
+	return os.WriteFile(path, append(out, '\n'), 0644)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/yaml"
+
+	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
+	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+)
+
+// roleManifest is a Role manifest written by the import subcommand, without the status
+type roleManifest struct {
+	APIVersion string                         `json:"apiVersion"`
+	Kind       string                         `json:"kind"`
+	Metadata   roleManifestMetadata           `json:"metadata"`
+	Spec       eksiamoperatorv1beta1.RoleSpec `json:"spec"`
+}
+
+type roleManifestMetadata struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
+
+// runImport implements the import subcommand, printing Role manifests for existing IAM roles, selected by name,
+// name prefix or tag. Roles which cannot be expressed by a Role spec are reported, and the exit code is exitError
+// if any role could not be imported. A single role can be imported under another Role name, for a role whose name
+// does not have the configured prefix and suffix.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manager import -config <file> [-namespace <namespace>] [-prefix <prefix>] [-tag <key>=<value>] [<role name>...]")
+		fmt.Fprintln(flags.Output(), "       manager import -config <file> [-namespace <namespace>] -role-name <Role name> <role name>")
+		flags.PrintDefaults()
+	}
+	configFlag := flags.String("config", "", "The operator config file, for the role and inline policy name prefixes and suffixes, and the OIDC providers.")
+	namespaceFlag := flags.String("namespace", "default", "Namespace of the Role manifests.")
+	prefixFlag := flags.String("prefix", "", "Import every IAM role whose name starts with this prefix.")
+	tagFlag := flags.String("tag", "", "Only import IAM roles with this tag, as key=value.")
+	roleNameFlag := flags.String("role-name", "", "Import a single IAM role as a Role of this name. The operator creates a new IAM role named after the Role in its place.")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 && len(*prefixFlag) == 0 && len(*tagFlag) == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+	if len(*roleNameFlag) > 0 && (flags.NArg() != 1 || len(*prefixFlag) > 0 || len(*tagFlag) > 0) {
+		fmt.Fprintln(os.Stderr, "-role-name imports a single IAM role, given by name")
+		return exitUsage
+	}
+
+	var tagKey, tagValue string
+	if len(*tagFlag) > 0 {
+		parts := strings.SplitN(*tagFlag, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			fmt.Fprintf(os.Stderr, "-tag must be key=value, got %q\n", *tagFlag)
+			return exitUsage
+		}
+		tagKey, tagValue = parts[0], parts[1]
+	}
+
+	ctrlConfig, err := loadCLIConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load the config file: %v\n", err)
+		return exitError
+	}
+
+	ctx := context.Background()
+	awsClient, err := internal.NewAWSRoleClient(ctx, logr.Discard())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to create AWS client: %v\n", err)
+		return exitError
+	}
+
+	names := flags.Args()
+	if len(names) == 0 {
+		if names, err = awsClient.ListRoleNames(ctx, *prefixFlag, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to list IAM roles: %v\n", err)
+			return exitError
+		}
+	}
+
+	// Roles are filtered by tag before their policies are read
+	if len(tagKey) > 0 {
+		if names, err = awsClient.FilterRolesByTags(ctx, names, map[string]string{tagKey: tagValue}); err != nil {
+			fmt.Fprintf(os.Stderr, "unable to read IAM role tags: %v\n", err)
+			return exitError
+		}
+	}
+
+	reconciler, err := newRoleReconciler(ctrlConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure the Role reconciler: %v\n", err)
+		return exitError
+	}
+	failed := 0
+	for _, name := range names {
+		state, err := awsClient.GetRoleState(ctx, name)
+		if err == nil && state == nil {
+			err = fmt.Errorf("IAM role %s does not exist", name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		manifest, err := importRole(reconciler, name, *roleNameFlag, state, *namespaceFlag)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed++
+			continue
+		}
+		fmt.Printf("---\n%s", manifest)
+	}
+
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d IAM roles could not be imported\n", failed)
+		return exitError
+	}
+	return exitOK
+}
+
+// importRole converts an IAM role into a Role manifest, named roleName or, if that is empty, after the IAM role.
+// Warnings are printed for anything dropped, and for any difference between the IAM role and the role the operator
+// would render for the manifest.
+func importRole(reconciler *controllers.RoleReconciler, name, roleName string, state *internal.RoleState, namespace string) ([]byte, error) {
+	var role *eksiamoperatorv1beta1.Role
+	var warnings []string
+	var err error
+	if len(roleName) > 0 {
+		role, warnings, err = reconciler.ImportAs(name, roleName, state)
+	} else {
+		role, warnings, err = reconciler.Import(name, state)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := reconciler.Render(role)
+	if err != nil {
+		return nil, fmt.Errorf("IAM role %s: imported Role cannot be rendered: %w", name, err)
+	}
+	if !internal.PoliciesEqual(rendered.TrustPolicy, state.TrustPolicy) {
+		warnings = append(warnings, fmt.Sprintf("IAM role %s: the trust policy rendered for the Role differs, check it with the diff subcommand", name))
+	}
+	for policy, doc := range rendered.InlinePolicies {
+		if !internal.PoliciesEqual(doc, state.InlinePolicies[policy]) {
+			warnings = append(warnings, fmt.Sprintf("IAM role %s: inline policy %s rendered for the Role differs, check it with the diff subcommand", name, policy))
+		}
+	}
+	// Managed policies are named after the IAM role, which is new when imported under another Role name
+	for policy, doc := range rendered.ManagedPolicies {
+		if !internal.PoliciesEqual(doc, state.ManagedPolicies[name+strings.TrimPrefix(policy, rendered.Name)]) {
+			warnings = append(warnings, fmt.Sprintf("IAM role %s: managed policy %s rendered for the Role differs, check it with the diff subcommand", name, policy))
+		}
+	}
+	if _, owned := state.Tags[internal.RoleOwnerTag]; !owned && rendered.Name == name {
+		warnings = append(warnings, fmt.Sprintf("IAM role %s: not tagged as managed by the operator, tag it with %s=true to let the operator adopt it", name, internal.RoleOwnerTag))
+	}
+	for _, v := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", v)
+	}
+
+	return yaml.Marshal(roleManifest{
+		APIVersion: role.APIVersion,
+		Kind:       role.Kind,
+		Metadata:   roleManifestMetadata{Name: role.Name, Namespace: namespace},
+		Spec:       role.Spec,
+	})
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The IAM action catalog built into the operator, regenerated with `make update-iam-actions`
+//
+//go:embed iam_actions.json
+var embeddedActionCatalog []byte
+
+// ActionCatalog is a catalog of AWS service prefixes and their IAM actions, used to find typos in policy actions
+// without calling AWS. Services whose actions are not catalogued only have their prefix validated.
+type ActionCatalog struct {
+	// Actions of each service prefix, keyed by lower case action name, with the canonical action name
+	services map[string]map[string]string
+}
+
+// actionCatalogFile is the JSON format of an action catalog: the action names of each service prefix, where an
+// empty list means the actions of the service are not catalogued
+type actionCatalogFile struct {
+	Services map[string][]string `json:"services"`
+}
+
+// DefaultActionCatalog returns the action catalog built into the operator
+func DefaultActionCatalog() (*ActionCatalog, error) {
+	return ParseActionCatalog(embeddedActionCatalog)
+}
+
+// LoadActionCatalog reads an action catalog file, which replaces the built-in catalog
+func LoadActionCatalog(path string) (*ActionCatalog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseActionCatalog(data)
+}
+
+// ParseActionCatalog parses a JSON action catalog
+func ParseActionCatalog(data []byte) (*ActionCatalog, error) {
+	var file actionCatalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid IAM action catalog: %w", err)
+	}
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("invalid IAM action catalog: no services")
+	}
+
+	c := &ActionCatalog{services: map[string]map[string]string{}}
+	for prefix, actions := range file.Services {
+		prefix = strings.ToLower(prefix)
+		if len(actions) == 0 {
+			c.services[prefix] = nil
+			continue
+		}
+		c.services[prefix] = map[string]string{}
+		for _, action := range actions {
+			c.services[prefix][strings.ToLower(action)] = action
+		}
+	}
+	return c, nil
+}
+
+// Coverage returns the number of service prefixes in the catalog, and how many of them have catalogued actions
+func (c *ActionCatalog) Coverage() (services int, catalogued int) {
+	for _, actions := range c.services {
+		if actions != nil {
+			catalogued++
+		}
+	}
+	return len(c.services), catalogued
+}
+
+// Expand returns the catalogued actions matched by an action, which may contain wildcards, as service:Action names.
+// ok is false when the service is unknown, or its actions are not catalogued.
+func (c *ActionCatalog) Expand(action string) (actions []string, ok bool) {
+	prefix, name, found := strings.Cut(strings.ToLower(action), ":")
+	if action == "*" {
+		prefix, name = "*", "*"
+	} else if !found {
+		return nil, false
+	}
+
+	for service, catalogued := range c.services {
+		if !WildcardMatch(prefix, service) {
+			continue
+		}
+		if catalogued == nil {
+			return nil, false
+		}
+		for lower, canonical := range catalogued {
+			if WildcardMatch(name, lower) {
+				actions = append(actions, service+":"+canonical)
+			}
+		}
+	}
+	sort.Strings(actions)
+	return actions, true
+}
+
+// ValidateAction returns a description of the problem with an action, or an empty string if there is none. unknown
+// is true when the action does not exist in the catalog (or its service is unknown), rather than only differing from
+// the catalog in case, which IAM ignores.
+func (c *ActionCatalog) ValidateAction(action string) (problem string, unknown bool) {
+	if action == "*" {
+		return "", false
+	}
+
+	prefix, name, found := strings.Cut(action, ":")
+	if !found || len(prefix) == 0 || len(name) == 0 {
+		return fmt.Sprintf("%s is not of the form service:action", action), true
+	}
+
+	wildcard := strings.ContainsAny(action, "*?")
+	if strings.ContainsAny(prefix, "*?") {
+		return fmt.Sprintf("%s has a wildcard in the service prefix", action), true
+	}
+
+	catalogued, ok := c.services[strings.ToLower(prefix)]
+	if !ok {
+		return fmt.Sprintf("%s has an unknown service prefix %q", action, prefix), true
+	}
+	if catalogued == nil {
+		return "", false
+	}
+
+	if wildcard {
+		if matched, _ := c.Expand(action); len(matched) == 0 {
+			return fmt.Sprintf("%s does not match any %s action", action, prefix), true
+		}
+		return "", false
+	}
+
+	canonical, ok := catalogued[strings.ToLower(name)]
+	if !ok {
+		if suggestion := closestAction(name, catalogued); len(suggestion) > 0 {
+			return fmt.Sprintf("%s is not a known action, did you mean %s:%s?", action, prefix, suggestion), true
+		}
+		return fmt.Sprintf("%s is not a known action", action), true
+	}
+	if canonical != name {
+		return fmt.Sprintf("%s is written %s:%s in the IAM documentation", action, prefix, canonical), false
+	}
+	return "", false
+}
+
+// closestAction returns the catalogued action with the smallest edit distance to a name, if it is close enough to be
+// a likely typo
+func closestAction(name string, catalogued map[string]string) string {
+	lowers := make([]string, 0, len(catalogued))
+	for lower := range catalogued {
+		lowers = append(lowers, lower)
+	}
+	sort.Strings(lowers)
+
+	best, bestDistance := "", len(name)/3+1
+	for _, lower := range lowers {
+		if d := editDistance(strings.ToLower(name), lower); d < bestDistance {
+			best, bestDistance = catalogued[lower], d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAction(t *testing.T) {
+	catalog, err := DefaultActionCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		action  string
+		problem string
+		unknown bool
+	}{
+		{action: "*"},
+		{action: "s3:GetObject"},
+		{action: "s3:Get*"},
+		{action: "ec2:DescribeInstances"},
+		{action: "s3:GetObjects", problem: "did you mean s3:GetObject?", unknown: true},
+		{action: "dynamodb:Getitem", problem: "is written dynamodb:GetItem"},
+		{action: "s3:Frobnicate*", problem: "does not match any s3 action", unknown: true},
+		{action: "s4:GetObject", problem: "unknown service prefix", unknown: true},
+		{action: "GetObject", problem: "not of the form service:action", unknown: true},
+	}
+
+	for _, tt := range tests {
+		problem, unknown := catalog.ValidateAction(tt.action)
+		if unknown != tt.unknown || (len(tt.problem) == 0) != (len(problem) == 0) || !strings.Contains(problem, tt.problem) {
+			t.Errorf("ValidateAction(%q) = %q, %v, want %q, %v", tt.action, problem, unknown, tt.problem, tt.unknown)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	catalog, err := DefaultActionCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actions, ok := catalog.Expand("sqs:*Message")
+	if !ok || strings.Join(actions, ",") != "sqs:DeleteMessage,sqs:ReceiveMessage,sqs:SendMessage" {
+		t.Errorf("Expand(sqs:*Message) = %v, %v", actions, ok)
+	}
+	if _, ok := catalog.Expand("ec2:Describe*"); ok {
+		t.Errorf("Expand(ec2:Describe*) is ok, but ec2 actions are not catalogued")
+	}
+}
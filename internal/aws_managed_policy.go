@@ -0,0 +1,384 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// ManagedPolicyPath is the path of the customer managed policies created by the operator, for statements which do
+// not fit in the inline policy size limit of a role
+const ManagedPolicyPath = "/eks-iam-operator/"
+
+// IAM keeps at most 5 versions of a managed policy
+const maxPolicyVersions = 5
+
+// ManagedPolicyResult describes the customer managed policies of a role after SyncManagedPolicies, and the changes
+// made to them
+type ManagedPolicyResult struct {
+	ARNs    []string
+	Created []string
+	Updated []string
+	Deleted []string
+
+	// What the changes replaced, for rollbackManagedPolicies: the policies attached to the role, the previous
+	// default version of updated policies (by ARN) and the documents of deleted policies (by name)
+	attached         []string
+	previousVersions map[string]string
+	deletedDocuments map[string]string
+}
+
+// Changed returns true if SyncManagedPolicies modified the managed policies in any way
+func (r *ManagedPolicyResult) Changed() bool {
+	if r == nil {
+		return false
+	}
+	return len(r.Created) > 0 || len(r.Updated) > 0 || len(r.Deleted) > 0
+}
+
+// SyncManagedPolicies creates (or updates, with a new default version) the operator's customer managed policies
+// for a role and attaches them to the role. Operator managed policies attached to the role which are no longer
+// needed are detached and deleted. Managed policies outside the operator's path are left alone.
+func (c *AWSRoleClient) SyncManagedPolicies(ctx context.Context, role string, roleARN string, policies map[string]string) (*ManagedPolicyResult, error) {
+	result := &ManagedPolicyResult{ARNs: []string{}, previousVersions: map[string]string{}, deletedDocuments: map[string]string{}}
+
+	attached, err := c.ListAttachedPolicies(ctx, role)
+	if err != nil {
+		return result, err
+	}
+	result.attached = attached
+
+	for _, name := range SortedKeys(policies) {
+		arn, err := managedPolicyARN(roleARN, name)
+		if err != nil {
+			return result, err
+		}
+
+		policy, current, err := c.getManagedPolicy(ctx, arn)
+		if err != nil {
+			return result, err
+		}
+
+		switch {
+		case policy == nil:
+			if err := c.createManagedPolicy(ctx, name, policies[name]); err != nil {
+				return result, err
+			}
+			result.Created = append(result.Created, name)
+		case !PoliciesEqual(current, policies[name]):
+			result.previousVersions[arn] = aws.ToString(policy.DefaultVersionId)
+			if err := c.updateManagedPolicy(ctx, arn, policies[name]); err != nil {
+				return result, err
+			}
+			result.Updated = append(result.Updated, name)
+		}
+
+		if !ContainsString(attached, arn) {
+			c.log.Info("Attaching managed policy", "role", role, "policy", name)
+			if _, err := c.iam.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+				RoleName:  aws.String(role),
+				PolicyArn: aws.String(arn),
+			}); err != nil {
+				return result, err
+			}
+		}
+		result.ARNs = append(result.ARNs, arn)
+	}
+
+	for _, arn := range attached {
+		if !isOperatorManagedPolicy(arn) || ContainsString(result.ARNs, arn) {
+			continue
+		}
+		// Policies in the operator's path without the owner tag were attached by hand, and are left alone
+		_, doc, err := c.getManagedPolicy(ctx, arn)
+		if IsOwnershipError(err) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.deletedDocuments[managedPolicyName(arn)] = doc
+		deleted, err := c.deleteManagedPolicy(ctx, role, arn)
+		if err != nil {
+			return result, err
+		}
+		if deleted {
+			result.Deleted = append(result.Deleted, managedPolicyName(arn))
+		}
+	}
+
+	return result, nil
+}
+
+// rollbackManagedPolicies undoes the changes made by SyncManagedPolicies to the managed policies of a role: deleted
+// policies are created and attached again, updated policies are returned to their previous default version, and
+// created (or newly attached) policies are detached, deleting those which were created
+func (c *AWSRoleClient) rollbackManagedPolicies(ctx context.Context, role string, roleARN string, result *ManagedPolicyResult) error {
+	if result == nil {
+		return nil
+	}
+
+	for _, name := range SortedKeys(result.deletedDocuments) {
+		arn, err := managedPolicyARN(roleARN, name)
+		if err != nil {
+			return err
+		}
+		policy, _, err := c.getManagedPolicy(ctx, arn)
+		if err != nil {
+			return err
+		}
+		if policy == nil {
+			if err := c.createManagedPolicy(ctx, name, result.deletedDocuments[name]); err != nil {
+				return err
+			}
+		}
+		c.log.Info("Attaching managed policy", "role", role, "policy", name)
+		if _, err := c.iam.AttachRolePolicy(ctx, &iam.AttachRolePolicyInput{
+			RoleName:  aws.String(role),
+			PolicyArn: aws.String(arn),
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, arn := range SortedKeys(result.previousVersions) {
+		c.log.Info("Restoring managed policy version", "policy", arn, "version", result.previousVersions[arn])
+		if _, err := c.iam.SetDefaultPolicyVersion(ctx, &iam.SetDefaultPolicyVersionInput{
+			PolicyArn: aws.String(arn),
+			VersionId: aws.String(result.previousVersions[arn]),
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, arn := range result.ARNs {
+		if ContainsString(result.attached, arn) {
+			continue
+		}
+		if ContainsString(result.Created, managedPolicyName(arn)) {
+			if _, err := c.deleteManagedPolicy(ctx, role, arn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := c.detachManagedPolicy(ctx, role, arn); err != nil {
+			return err
+		}
+	}
+
+	// A policy created just before the failure may not have been attached (so is not in ARNs)
+	for _, name := range result.Created {
+		arn, err := managedPolicyARN(roleARN, name)
+		if err != nil {
+			return err
+		}
+		if !ContainsString(result.ARNs, arn) {
+			if _, err := c.deleteManagedPolicy(ctx, role, arn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// detachManagedPolicies detaches every managed policy from a role (so it can be deleted), and deletes those owned by
+// the operator
+func (c *AWSRoleClient) detachManagedPolicies(ctx context.Context, role string) error {
+	attached, err := c.ListAttachedPolicies(ctx, role)
+	if err != nil {
+		return err
+	}
+
+	for _, arn := range attached {
+		if isOperatorManagedPolicy(arn) {
+			if _, err := c.deleteManagedPolicy(ctx, role, arn); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.log.Info("Detaching managed policy", "role", role, "policy", arn)
+		if _, err := c.iam.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+			RoleName:  aws.String(role),
+			PolicyArn: aws.String(arn),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getManagedPolicy calls the AWS IAM API to return a managed policy and the document of its default version, or a
+// nil policy if it does not exist. Existing policies must be owned by the operator.
+func (c *AWSRoleClient) getManagedPolicy(ctx context.Context, arn string) (*types.Policy, string, error) {
+	out, err := c.iam.GetPolicy(ctx, &iam.GetPolicyInput{PolicyArn: aws.String(arn)})
+
+	var noSuchEntityException *types.NoSuchEntityException
+	if err != nil && errors.As(err, &noSuchEntityException) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !hasTag(out.Policy.Tags, RoleOwnerTag) {
+		return nil, "", &OwnershipError{Resource: "IAM policy", Name: arn}
+	}
+
+	version, err := c.iam.GetPolicyVersion(ctx, &iam.GetPolicyVersionInput{
+		PolicyArn: aws.String(arn),
+		VersionId: out.Policy.DefaultVersionId,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// IAM returns policy documents URL encoded
+	doc, err := url.QueryUnescape(aws.ToString(version.PolicyVersion.Document))
+	return out.Policy, doc, err
+}
+
+// createManagedPolicy calls the AWS IAM API to create a managed policy in the operator's path, tagged with the
+// owner tag
+func (c *AWSRoleClient) createManagedPolicy(ctx context.Context, name string, doc string) error {
+	c.log.Info("Creating managed policy", "policy", name)
+	_, err := c.iam.CreatePolicy(ctx, &iam.CreatePolicyInput{
+		PolicyName:     aws.String(name),
+		Path:           aws.String(ManagedPolicyPath),
+		PolicyDocument: aws.String(doc),
+		Tags: []types.Tag{{
+			Key:   aws.String(RoleOwnerTag),
+			Value: aws.String("true"),
+		}},
+	})
+	return err
+}
+
+// updateManagedPolicy calls the AWS IAM API to create a new default version of a managed policy, deleting the
+// oldest version first if the policy already has the maximum number of versions
+func (c *AWSRoleClient) updateManagedPolicy(ctx context.Context, arn string, doc string) error {
+	versions, err := c.listNonDefaultPolicyVersions(ctx, arn)
+	if err != nil {
+		return err
+	}
+
+	if len(versions) >= maxPolicyVersions-1 {
+		oldest := versions[0]
+		for _, v := range versions {
+			if aws.ToTime(v.CreateDate).Before(aws.ToTime(oldest.CreateDate)) {
+				oldest = v
+			}
+		}
+		if _, err := c.iam.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(arn),
+			VersionId: oldest.VersionId,
+		}); err != nil {
+			return err
+		}
+	}
+
+	c.log.Info("Updating managed policy", "policy", arn)
+	_, err = c.iam.CreatePolicyVersion(ctx, &iam.CreatePolicyVersionInput{
+		PolicyArn:      aws.String(arn),
+		PolicyDocument: aws.String(doc),
+		SetAsDefault:   true,
+	})
+	return err
+}
+
+// deleteManagedPolicy detaches a managed policy from a role, and deletes it (with all of its versions) if it is
+// owned by the operator, returning whether it was deleted
+func (c *AWSRoleClient) deleteManagedPolicy(ctx context.Context, role string, arn string) (bool, error) {
+	if err := c.detachManagedPolicy(ctx, role, arn); err != nil {
+		return false, err
+	}
+
+	policy, _, err := c.getManagedPolicy(ctx, arn)
+	if IsOwnershipError(err) || (err == nil && policy == nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The policy may still be attached elsewhere (e.g. by hand), in which case IAM refuses to delete it
+	if aws.ToInt32(policy.AttachmentCount) > 0 {
+		c.log.Info("Managed policy is still attached elsewhere, not deleting it", "policy", arn)
+		return false, nil
+	}
+
+	versions, err := c.listNonDefaultPolicyVersions(ctx, arn)
+	if err != nil {
+		return false, err
+	}
+	for _, v := range versions {
+		if _, err := c.iam.DeletePolicyVersion(ctx, &iam.DeletePolicyVersionInput{
+			PolicyArn: aws.String(arn),
+			VersionId: v.VersionId,
+		}); err != nil {
+			return false, err
+		}
+	}
+
+	c.log.Info("Deleting managed policy", "policy", arn)
+	_, err = c.iam.DeletePolicy(ctx, &iam.DeletePolicyInput{PolicyArn: aws.String(arn)})
+	return err == nil, err
+}
+
+// detachManagedPolicy calls the AWS IAM API to detach a managed policy from a role. Detaching a policy which is not
+// attached is not an error.
+func (c *AWSRoleClient) detachManagedPolicy(ctx context.Context, role string, arn string) error {
+	c.log.Info("Detaching managed policy", "role", role, "policy", arn)
+	_, err := c.iam.DetachRolePolicy(ctx, &iam.DetachRolePolicyInput{
+		RoleName:  aws.String(role),
+		PolicyArn: aws.String(arn),
+	})
+
+	var noSuchEntityException *types.NoSuchEntityException
+	if err != nil && errors.As(err, &noSuchEntityException) {
+		return nil
+	}
+	return err
+}
+
+// listNonDefaultPolicyVersions calls the AWS IAM API to return the versions of a managed policy, other than the
+// default version
+func (c *AWSRoleClient) listNonDefaultPolicyVersions(ctx context.Context, arn string) ([]types.PolicyVersion, error) {
+	out, err := c.iam.ListPolicyVersions(ctx, &iam.ListPolicyVersionsInput{PolicyArn: aws.String(arn)})
+	if err != nil {
+		return nil, err
+	}
+
+	versions := []types.PolicyVersion{}
+	for _, v := range out.Versions {
+		if !v.IsDefaultVersion {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+// managedPolicyARN returns the ARN of an operator managed policy, in the same partition and account as a role
+func managedPolicyARN(roleARN string, name string) (string, error) {
+	parts := strings.SplitN(roleARN, ":", 6)
+	if len(parts) != 6 || parts[0] != "arn" {
+		return "", fmt.Errorf("invalid role ARN %q", roleARN)
+	}
+	return fmt.Sprintf("arn:%s:iam::%s:policy%s%s", parts[1], parts[4], ManagedPolicyPath, name), nil
+}
+
+// isOperatorManagedPolicy returns true if a managed policy ARN is in the operator's path
+func isOperatorManagedPolicy(arn string) bool {
+	return strings.Contains(arn, ":policy"+ManagedPolicyPath)
+}
+
+// managedPolicyName returns the name of a managed policy from its ARN
+func managedPolicyName(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+)
+
+// PodIdentityAssociation identifies an EKS Pod Identity Association between a service account and an IAM role
+type PodIdentityAssociation struct {
+	Namespace      string
+	ServiceAccount string
+	AssociationID  string
+}
+
+// SyncPodIdentityAssociations ensures an EKS Pod Identity Association to the role exists for each of the service
+// accounts in the namespace, and deletes any previously managed associations which are no longer wanted. The
+// associations are tagged with the source (the namespace/name of the Role). The associations now managed are
+// returned, including any created before an error occurred.
+func (c *AWSRoleClient) SyncPodIdentityAssociations(ctx context.Context, cluster, source, ns, roleARN string, serviceAccounts []string, existing []PodIdentityAssociation) ([]PodIdentityAssociation, error) {
+	managed := []PodIdentityAssociation{}
+	wanted := map[string]bool{}
+
+	for _, sa := range serviceAccounts {
+		wanted[ns+"/"+sa] = true
+
+		id, err := c.upsertPodIdentityAssociation(ctx, cluster, source, ns, sa, roleARN)
+		if err != nil {
+			return mergePodIdentityAssociations(managed, existing), err
+		}
+		managed = append(managed, PodIdentityAssociation{Namespace: ns, ServiceAccount: sa, AssociationID: id})
+	}
+
+	for i, v := range existing {
+		if wanted[v.Namespace+"/"+v.ServiceAccount] {
+			continue
+		}
+		if err := c.deletePodIdentityAssociation(ctx, cluster, v); err != nil {
+			return mergePodIdentityAssociations(managed, existing[i:]), err
+		}
+	}
+
+	return managed, nil
+}
+
+// DeletePodIdentityAssociations deletes EKS Pod Identity Associations. Associations which no longer exist are
+// ignored.
+func (c *AWSRoleClient) DeletePodIdentityAssociations(ctx context.Context, cluster string, associations []PodIdentityAssociation) error {
+	for _, v := range associations {
+		if err := c.deletePodIdentityAssociation(ctx, cluster, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertPodIdentityAssociation creates the EKS Pod Identity Association for a service account, or points the
+// existing association at the role if it is owned by the operator for the same source. Associations created
+// before they were tagged with their source are adopted. The association ID is returned.
+func (c *AWSRoleClient) upsertPodIdentityAssociation(ctx context.Context, cluster, source, ns, sa, roleARN string) (string, error) {
+	existing, err := c.getPodIdentityAssociation(ctx, cluster, ns, sa)
+	if err != nil {
+		return "", err
+	}
+
+	if existing == nil {
+		c.log.Info("Creating pod identity association", "cluster", cluster, "namespace", ns, "serviceAccount", sa)
+		out, err := c.eks.CreatePodIdentityAssociation(ctx, &eks.CreatePodIdentityAssociationInput{
+			ClusterName:    aws.String(cluster),
+			Namespace:      aws.String(ns),
+			ServiceAccount: aws.String(sa),
+			RoleArn:        aws.String(roleARN),
+			Tags:           map[string]string{RoleOwnerTag: "true", RoleSourceTag: source},
+		})
+		if err != nil {
+			return "", err
+		}
+		return aws.ToString(out.Association.AssociationId), nil
+	}
+
+	if _, ok := existing.Tags[RoleOwnerTag]; !ok {
+		return "", &OwnershipError{Resource: "pod identity association for service account", Name: ns + "/" + sa}
+	}
+
+	// Another Role may not take over the association of a service account
+	if owner, ok := existing.Tags[RoleSourceTag]; ok && owner != source {
+		return "", fmt.Errorf("pod identity association for service account %s/%s is managed by Role %s", ns, sa, owner)
+	}
+	if _, ok := existing.Tags[RoleSourceTag]; !ok {
+		c.log.Info("Tagging pod identity association", "cluster", cluster, "namespace", ns, "serviceAccount", sa, "source", source)
+		if _, err := c.eks.TagResource(ctx, &eks.TagResourceInput{
+			ResourceArn: existing.AssociationArn,
+			Tags:        map[string]string{RoleSourceTag: source},
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if aws.ToString(existing.RoleArn) != roleARN {
+		c.log.Info("Updating pod identity association role", "cluster", cluster, "namespace", ns, "serviceAccount", sa)
+		if _, err := c.eks.UpdatePodIdentityAssociation(ctx, &eks.UpdatePodIdentityAssociationInput{
+			ClusterName:   aws.String(cluster),
+			AssociationId: existing.AssociationId,
+			RoleArn:       aws.String(roleARN),
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	return aws.ToString(existing.AssociationId), nil
+}
+
+// getPodIdentityAssociation calls the AWS EKS API to return the association for a service account, or nil if the
+// service account has no association
+func (c *AWSRoleClient) getPodIdentityAssociation(ctx context.Context, cluster, ns, sa string) (*types.PodIdentityAssociation, error) {
+	list, err := c.eks.ListPodIdentityAssociations(ctx, &eks.ListPodIdentityAssociationsInput{
+		ClusterName:    aws.String(cluster),
+		Namespace:      aws.String(ns),
+		ServiceAccount: aws.String(sa),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// EKS only allows a single association per service account
+	if len(list.Associations) == 0 {
+		return nil, nil
+	}
+
+	out, err := c.eks.DescribePodIdentityAssociation(ctx, &eks.DescribePodIdentityAssociationInput{
+		ClusterName:   aws.String(cluster),
+		AssociationId: list.Associations[0].AssociationId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out.Association, nil
+}
+
+// deletePodIdentityAssociation calls the AWS EKS API to delete an association, ignoring associations which no
+// longer exist
+func (c *AWSRoleClient) deletePodIdentityAssociation(ctx context.Context, cluster string, association PodIdentityAssociation) error {
+	c.log.Info("Deleting pod identity association", "cluster", cluster, "namespace", association.Namespace, "serviceAccount", association.ServiceAccount)
+	_, err := c.eks.DeletePodIdentityAssociation(ctx, &eks.DeletePodIdentityAssociationInput{
+		ClusterName:   aws.String(cluster),
+		AssociationId: aws.String(association.AssociationID),
+	})
+
+	var notFound *types.ResourceNotFoundException
+	if err != nil && errors.As(err, &notFound) {
+		return nil
+	}
+
+	return err
+}
+
+// mergePodIdentityAssociations returns the associations in a, plus any in b for service accounts not in a
+func mergePodIdentityAssociations(a, b []PodIdentityAssociation) []PodIdentityAssociation {
+	seen := map[string]bool{}
+	for _, v := range a {
+		seen[v.Namespace+"/"+v.ServiceAccount] = true
+	}
+	for _, v := range b {
+		if !seen[v.Namespace+"/"+v.ServiceAccount] {
+			a = append(a, v)
+		}
+	}
+	return a
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/go-logr/logr"
+)
+
+// fakeEKS is an in-memory implementation of the EKS Pod Identity Association API
+type fakeEKS struct {
+	associations map[string]*types.PodIdentityAssociation
+	nextID       int
+}
+
+func newFakeEKS() *fakeEKS {
+	return &fakeEKS{associations: map[string]*types.PodIdentityAssociation{}}
+}
+
+func (f *fakeEKS) ListPodIdentityAssociations(ctx context.Context, params *eks.ListPodIdentityAssociationsInput, optFns ...func(*eks.Options)) (*eks.ListPodIdentityAssociationsOutput, error) {
+	out := &eks.ListPodIdentityAssociationsOutput{}
+	for _, v := range f.associations {
+		if aws.ToString(v.Namespace) == aws.ToString(params.Namespace) && aws.ToString(v.ServiceAccount) == aws.ToString(params.ServiceAccount) {
+			out.Associations = append(out.Associations, types.PodIdentityAssociationSummary{AssociationId: v.AssociationId})
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeEKS) DescribePodIdentityAssociation(ctx context.Context, params *eks.DescribePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.DescribePodIdentityAssociationOutput, error) {
+	v, ok := f.associations[aws.ToString(params.AssociationId)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	return &eks.DescribePodIdentityAssociationOutput{Association: v}, nil
+}
+
+func (f *fakeEKS) CreatePodIdentityAssociation(ctx context.Context, params *eks.CreatePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.CreatePodIdentityAssociationOutput, error) {
+	f.nextID++
+	v := &types.PodIdentityAssociation{
+		AssociationId:  aws.String(fmt.Sprintf("a-%d", f.nextID)),
+		AssociationArn: aws.String(fmt.Sprintf("arn:aws:eks:eu-west-1:111111111111:podidentityassociation/cluster/a-%d", f.nextID)),
+		ClusterName:    params.ClusterName,
+		Namespace:      params.Namespace,
+		ServiceAccount: params.ServiceAccount,
+		RoleArn:        params.RoleArn,
+		Tags:           params.Tags,
+	}
+	f.associations[*v.AssociationId] = v
+	return &eks.CreatePodIdentityAssociationOutput{Association: v}, nil
+}
+
+func (f *fakeEKS) UpdatePodIdentityAssociation(ctx context.Context, params *eks.UpdatePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.UpdatePodIdentityAssociationOutput, error) {
+	v, ok := f.associations[aws.ToString(params.AssociationId)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	v.RoleArn = params.RoleArn
+	return &eks.UpdatePodIdentityAssociationOutput{Association: v}, nil
+}
+
+func (f *fakeEKS) DeletePodIdentityAssociation(ctx context.Context, params *eks.DeletePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.DeletePodIdentityAssociationOutput, error) {
+	if _, ok := f.associations[aws.ToString(params.AssociationId)]; !ok {
+		return nil, &types.ResourceNotFoundException{}
+	}
+	delete(f.associations, aws.ToString(params.AssociationId))
+	return &eks.DeletePodIdentityAssociationOutput{}, nil
+}
+
+func (f *fakeEKS) TagResource(ctx context.Context, params *eks.TagResourceInput, optFns ...func(*eks.Options)) (*eks.TagResourceOutput, error) {
+	for _, v := range f.associations {
+		if aws.ToString(v.AssociationArn) == aws.ToString(params.ResourceArn) {
+			if v.Tags == nil {
+				v.Tags = map[string]string{}
+			}
+			for k, tag := range params.Tags {
+				v.Tags[k] = tag
+			}
+			return &eks.TagResourceOutput{}, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{}
+}
+
+func (f *fakeEKS) DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error) {
+	return nil, &types.ResourceNotFoundException{}
+}
+
+func TestSyncPodIdentityAssociations(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeEKS()
+	c := NewAWSRoleClientFromAPIs(nil, fake, logr.Discard())
+
+	managed, err := c.SyncPodIdentityAssociations(ctx, "cluster", "default/a", "default", "arn:aws:iam::111111111111:role/a", []string{"one", "two"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(managed) != 2 || len(fake.associations) != 2 {
+		t.Fatalf("expected 2 associations, got %d managed and %d in EKS", len(managed), len(fake.associations))
+	}
+
+	// Changing the role and dropping a service account updates one association and deletes the other
+	managed, err = c.SyncPodIdentityAssociations(ctx, "cluster", "default/a", "default", "arn:aws:iam::111111111111:role/b", []string{"one"}, managed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(managed) != 1 || len(fake.associations) != 1 {
+		t.Fatalf("expected 1 association, got %d managed and %d in EKS", len(managed), len(fake.associations))
+	}
+	if arn := aws.ToString(fake.associations[managed[0].AssociationID].RoleArn); arn != "arn:aws:iam::111111111111:role/b" {
+		t.Fatalf("expected association to be updated to role b, got %s", arn)
+	}
+
+	// Deleting associations which no longer exist is not an error
+	delete(fake.associations, managed[0].AssociationID)
+	if err = c.DeletePodIdentityAssociations(ctx, "cluster", managed); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSyncPodIdentityAssociationsNotOwned(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeEKS()
+	c := NewAWSRoleClientFromAPIs(nil, fake, logr.Discard())
+
+	if _, err := fake.CreatePodIdentityAssociation(ctx, &eks.CreatePodIdentityAssociationInput{
+		Namespace:      aws.String("default"),
+		ServiceAccount: aws.String("one"),
+		RoleArn:        aws.String("arn:aws:iam::111111111111:role/other"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.SyncPodIdentityAssociations(ctx, "cluster", "default/a", "default", "arn:aws:iam::111111111111:role/a", []string{"one"}, nil); err == nil {
+		t.Fatal("expected an error for an association without the operator owner tag")
+	}
+}
+
+func TestSyncPodIdentityAssociationsOtherRole(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeEKS()
+	c := NewAWSRoleClientFromAPIs(nil, fake, logr.Discard())
+
+	if _, err := c.SyncPodIdentityAssociations(ctx, "cluster", "default/a", "default", "arn:aws:iam::111111111111:role/a", []string{"one"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second Role for the same service account does not take over the association
+	if _, err := c.SyncPodIdentityAssociations(ctx, "cluster", "default/b", "default", "arn:aws:iam::111111111111:role/b", []string{"one"}, nil); err == nil {
+		t.Fatal("expected an error for an association managed by another Role")
+	}
+	for _, v := range fake.associations {
+		if arn := aws.ToString(v.RoleArn); arn != "arn:aws:iam::111111111111:role/a" {
+			t.Fatalf("expected the association to keep role a, got %s", arn)
+		}
+	}
+}
+
+func TestSyncPodIdentityAssociationsAdoptsUntagged(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeEKS()
+	c := NewAWSRoleClientFromAPIs(nil, fake, logr.Discard())
+
+	// An association created by the operator before associations were tagged with their Role
+	out, err := fake.CreatePodIdentityAssociation(ctx, &eks.CreatePodIdentityAssociationInput{
+		Namespace:      aws.String("default"),
+		ServiceAccount: aws.String("one"),
+		RoleArn:        aws.String("arn:aws:iam::111111111111:role/a"),
+		Tags:           map[string]string{RoleOwnerTag: "true"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.SyncPodIdentityAssociations(ctx, "cluster", "default/a", "default", "arn:aws:iam::111111111111:role/a", []string{"one"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if source := out.Association.Tags[RoleSourceTag]; source != "default/a" {
+		t.Fatalf("expected the association to be tagged with its Role, got %q", source)
+	}
+}
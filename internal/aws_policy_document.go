@@ -1,5 +1,10 @@
 package internal
 
+import (
+	"encoding/json"
+	"reflect"
+)
+
 type AWSPolicyDocument struct {
 	Statement []AWSPolicyDocumentStatement `json:"Statement"`
 	Version   string                       `json:"Version"`
@@ -9,19 +14,88 @@ type AWSPolicyDocumentStatement struct {
 	Sid       string                         `json:"Sid,omitempty"`
 	Effect    string                         `json:"Effect"`
 	Resources interface{}                    `json:"Resource,omitempty"`
-	Principal map[string]string              `json:"Principal,omitempty"`
+	Principal map[string]interface{}         `json:"Principal,omitempty"`
 	Actions   interface{}                    `json:"Action,omitempty"`
 	Condition map[string]map[string][]string `json:"Condition,omitempty"`
 }
 
 func NewAWSTrustPolicy() *AWSPolicyDocument {
 	return &AWSPolicyDocument{
-		Statement: []AWSPolicyDocumentStatement{{
-			Effect:    "Allow",
-			Principal: map[string]string{},
-			Condition: map[string]map[string][]string{},
-			Actions:   "sts:AssumeRoleWithWebIdentity",
-		}},
-		Version: "2012-10-17",
+		Statement: []AWSPolicyDocumentStatement{},
+		Version:   "2012-10-17",
+	}
+}
+
+func NewAWSTrustStatement(principalType string, principal interface{}) AWSPolicyDocumentStatement {
+	return AWSPolicyDocumentStatement{
+		Effect:    "Allow",
+		Principal: map[string]interface{}{principalType: principal},
+		Condition: map[string]map[string][]string{},
+		Actions:   "sts:AssumeRole",
+	}
+}
+
+func NewAWSWebIdentityTrustStatement(oidcProviderARN string) AWSPolicyDocumentStatement {
+	return AWSPolicyDocumentStatement{
+		Effect:    "Allow",
+		Principal: map[string]interface{}{"Federated": oidcProviderARN},
+		Condition: map[string]map[string][]string{},
+		Actions:   "sts:AssumeRoleWithWebIdentity",
+	}
+}
+
+func NewAWSPodIdentityTrustStatement() AWSPolicyDocumentStatement {
+	return AWSPolicyDocumentStatement{
+		Effect:    "Allow",
+		Principal: map[string]interface{}{"Service": "pods.eks.amazonaws.com"},
+		Condition: map[string]map[string][]string{},
+		Actions:   []string{"sts:AssumeRole", "sts:TagSession"},
+	}
+}
+
+// PolicyActions returns the actions of the Allow statements of a JSON policy document
+func PolicyActions(policy string) ([]string, error) {
+	var doc AWSPolicyDocument
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil {
+		return nil, err
+	}
+
+	actions := []string{}
+	for _, stmt := range doc.Statement {
+		if stmt.Effect == "Allow" {
+			actions = append(actions, valueList(stmt.Actions)...)
+		}
+	}
+	return actions, nil
+}
+
+// PoliciesEqual returns true if two JSON policy documents are semantically equal, ignoring formatting. As in IAM,
+// a single value is equal to a list containing only that value.
+func PoliciesEqual(a, b string) bool {
+	var da, db interface{}
+	if err := json.Unmarshal([]byte(a), &da); err != nil {
+		return false
+	}
+	if err := json.Unmarshal([]byte(b), &db); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(unwrapSingleValues(da), unwrapSingleValues(db))
+}
+
+// unwrapSingleValues replaces every list containing a single value in a parsed JSON document with the value
+func unwrapSingleValues(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = unwrapSingleValues(e)
+		}
+	case []interface{}:
+		if len(v) == 1 {
+			return unwrapSingleValues(v[0])
+		}
+		for i, e := range v {
+			v[i] = unwrapSingleValues(e)
+		}
 	}
+	return v
 }
@@ -3,85 +3,306 @@ package internal
 import (
 	"context"
 	"errors"
+	"net/url"
+	"sort"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go-v2/service/iam"
 	"github.com/aws/aws-sdk-go-v2/service/iam/types"
 	"github.com/go-logr/logr"
 )
 
-const roleOwnerTag = "eks-iam-operator.neilmcgibbon.com"
+// RoleOwnerTag is the tag set on the IAM resources created (and owned) by the operator
+const RoleOwnerTag = "eks-iam-operator.neilmcgibbon.com"
+
+// Tags set on IAM roles to identify the cluster and the Role they were created for
+const (
+	RoleClusterTag = "eks-iam-operator.neilmcgibbon.com/cluster"
+	RoleSourceTag  = "eks-iam-operator.neilmcgibbon.com/role"
+)
+
+// Bounds on waiting for a newly created role to become readable, as IAM is eventually consistent and policies
+// written straight after creating a role may fail (or not take effect) otherwise
+const (
+	roleExistsTimeout  = time.Minute
+	roleExistsMaxDelay = 5 * time.Second
+)
+
+// Bound on rolling back a failed change to a role, which is not limited by the (possibly expired) context of the
+// change itself
+const rollbackTimeout = 30 * time.Second
+
+// IAMAPI is the subset of the AWS IAM API used by AWSRoleClient
+type IAMAPI interface {
+	GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error)
+	CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error)
+	DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error)
+	UpdateAssumeRolePolicy(ctx context.Context, params *iam.UpdateAssumeRolePolicyInput, optFns ...func(*iam.Options)) (*iam.UpdateAssumeRolePolicyOutput, error)
+	ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error)
+	PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error)
+	GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error)
+	DeleteRolePolicy(ctx context.Context, params *iam.DeleteRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DeleteRolePolicyOutput, error)
+	TagRole(ctx context.Context, params *iam.TagRoleInput, optFns ...func(*iam.Options)) (*iam.TagRoleOutput, error)
+	ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error)
+	ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error)
+	AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error)
+	DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error)
+	GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error)
+	GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error)
+	CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error)
+	CreatePolicyVersion(ctx context.Context, params *iam.CreatePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyVersionOutput, error)
+	ListPolicyVersions(ctx context.Context, params *iam.ListPolicyVersionsInput, optFns ...func(*iam.Options)) (*iam.ListPolicyVersionsOutput, error)
+	DeletePolicyVersion(ctx context.Context, params *iam.DeletePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyVersionOutput, error)
+	SetDefaultPolicyVersion(ctx context.Context, params *iam.SetDefaultPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.SetDefaultPolicyVersionOutput, error)
+	DeletePolicy(ctx context.Context, params *iam.DeletePolicyInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyOutput, error)
+	ListOpenIDConnectProviders(ctx context.Context, params *iam.ListOpenIDConnectProvidersInput, optFns ...func(*iam.Options)) (*iam.ListOpenIDConnectProvidersOutput, error)
+	GetOpenIDConnectProvider(ctx context.Context, params *iam.GetOpenIDConnectProviderInput, optFns ...func(*iam.Options)) (*iam.GetOpenIDConnectProviderOutput, error)
+	CreateOpenIDConnectProvider(ctx context.Context, params *iam.CreateOpenIDConnectProviderInput, optFns ...func(*iam.Options)) (*iam.CreateOpenIDConnectProviderOutput, error)
+	AddClientIDToOpenIDConnectProvider(ctx context.Context, params *iam.AddClientIDToOpenIDConnectProviderInput, optFns ...func(*iam.Options)) (*iam.AddClientIDToOpenIDConnectProviderOutput, error)
+}
+
+// EKSAPI is the subset of the AWS EKS API used by AWSRoleClient
+type EKSAPI interface {
+	ListPodIdentityAssociations(ctx context.Context, params *eks.ListPodIdentityAssociationsInput, optFns ...func(*eks.Options)) (*eks.ListPodIdentityAssociationsOutput, error)
+	DescribePodIdentityAssociation(ctx context.Context, params *eks.DescribePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.DescribePodIdentityAssociationOutput, error)
+	CreatePodIdentityAssociation(ctx context.Context, params *eks.CreatePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.CreatePodIdentityAssociationOutput, error)
+	UpdatePodIdentityAssociation(ctx context.Context, params *eks.UpdatePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.UpdatePodIdentityAssociationOutput, error)
+	DeletePodIdentityAssociation(ctx context.Context, params *eks.DeletePodIdentityAssociationInput, optFns ...func(*eks.Options)) (*eks.DeletePodIdentityAssociationOutput, error)
+	TagResource(ctx context.Context, params *eks.TagResourceInput, optFns ...func(*eks.Options)) (*eks.TagResourceOutput, error)
+	DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput, optFns ...func(*eks.Options)) (*eks.DescribeClusterOutput, error)
+}
 
 type AWSRoleClient struct {
-	cfg aws.Config
+	iam IAMAPI
+	eks EKSAPI
 	log logr.Logger
 }
 
 func NewAWSRoleClient(ctx context.Context, l logr.Logger) (*AWSRoleClient, error) {
-	cl := &AWSRoleClient{log: l}
 	c, err := config.LoadDefaultConfig(ctx, config.WithRegion("eu-west-1"))
 
 	if err != nil {
-		return cl, err
+		return &AWSRoleClient{log: l}, err
 	}
+	c.APIOptions = append(c.APIOptions, addAPIMetricsMiddleware)
 
-	cl.cfg = c
-	return cl, nil
+	return NewAWSRoleClientFromAPIs(iam.NewFromConfig(c), eks.NewFromConfig(c), l), nil
 }
 
-// Upsert creates or updates a role, using the provided assume role policy and map of inline policies
-func (c *AWSRoleClient) Upsert(ctx context.Context, name string, trustPolicy string, inlinePolicies map[string]string) error {
+// NewAWSRoleClientFromAPIs returns a client using the provided IAM and EKS API implementations, e.g. local fakes
+func NewAWSRoleClientFromAPIs(iamAPI IAMAPI, eksAPI EKSAPI, l logr.Logger) *AWSRoleClient {
+	return &AWSRoleClient{iam: iamAPI, eks: eksAPI, log: l}
+}
+
+// UpsertResult describes the changes made to a role by Upsert
+type UpsertResult struct {
+	ARN                string
+	Created            bool
+	TrustPolicyUpdated bool
+	TagsUpdated        bool
+	PoliciesAdded      []string
+	PoliciesUpdated    []string
+	PoliciesDeleted    []string
+
+	// Customer managed policies of the role, synced by Upsert between writing and deleting inline policies
+	Managed *ManagedPolicyResult
+}
+
+// Changed returns true if Upsert modified the role in any way
+func (r *UpsertResult) Changed() bool {
+	return r.Created || r.TrustPolicyUpdated || r.TagsUpdated || len(r.PoliciesAdded) > 0 || len(r.PoliciesUpdated) > 0 || len(r.PoliciesDeleted) > 0 || r.Managed.Changed()
+}
+
+// roleSnapshot holds the trust policy and inline policy documents of a role before Upsert changes it
+type roleSnapshot struct {
+	trustPolicy string
+	policies    map[string]string
+}
+
+// Upsert creates or updates a role, using the provided assume role policy, map of inline policies, customer
+// managed policies and tags (in addition to the owner tag). Only the trust policy, inline policies and tags which
+// differ from the current role are written. Managed policies are synced before inline policies are deleted, so
+// statements moving from an inline to a managed policy are granted throughout. The changes made (and the ARN of
+// the role) are returned.
+//
+// The trust policy and inline policies of an existing role are snapshotted before it is changed, and restored (with
+// its managed policies) if a later change fails with a terminal error (a created role is deleted again), so a role is
+// never left half updated by a change which cannot succeed. The error returned is then a RollbackError, with the outcome of the rollback.
+// Changes failing with a retryable error, e.g. throttling, are kept, as retrying completes them.
+func (c *AWSRoleClient) Upsert(ctx context.Context, name string, trustPolicy string, inlinePolicies map[string]string, managedPolicies map[string]string, tags map[string]string) (*UpsertResult, error) {
+	result := &UpsertResult{Managed: &ManagedPolicyResult{ARNs: []string{}}}
+	snapshot := &roleSnapshot{policies: map[string]string{}}
 
 	existing, err := c.getRole(ctx, name)
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	// Holder for existing policies, to determine deletions
-	inlinePoliciesToDelete := []string{}
-
 	// Create role (or check we can edit role if it exists)
 	if existing != nil {
 		// IAM role exists, lets check we can modify it
-		if roleHasTag(existing, roleOwnerTag) == false {
-			return errors.New("Not upserting as role does not have the operator owner tag")
+		if roleHasTag(existing, RoleOwnerTag) == false {
+			return result, &OwnershipError{Resource: "IAM role", Name: name}
 		}
 		existingInlinePolicies, err := c.getRoleInlinePolicies(ctx, name)
 		if err != nil {
-			return err
+			return result, err
+		}
+
+		// Compare existing inline policies to determine additions and updates
+		for _, policy := range SortedKeys(inlinePolicies) {
+			if !ContainsString(existingInlinePolicies, policy) {
+				result.PoliciesAdded = append(result.PoliciesAdded, policy)
+				continue
+			}
+			current, err := c.getRoleInlinePolicy(ctx, name, policy)
+			if err != nil {
+				return result, err
+			}
+			if !PoliciesEqual(current, inlinePolicies[policy]) {
+				result.PoliciesUpdated = append(result.PoliciesUpdated, policy)
+				snapshot.policies[policy] = current
+			}
 		}
 
-		inlinePoliciesToDelete = getInlinePoliciesToDelete(existingInlinePolicies, inlinePolicies)
+		result.PoliciesDeleted = getInlinePoliciesToDelete(existingInlinePolicies, inlinePolicies)
+		for _, policy := range result.PoliciesDeleted {
+			if snapshot.policies[policy], err = c.getRoleInlinePolicy(ctx, name, policy); err != nil {
+				return result, err
+			}
+		}
+
+		snapshot.trustPolicy, err = url.QueryUnescape(aws.ToString(existing.AssumeRolePolicyDocument))
+		if err != nil {
+			return result, err
+		}
+		result.TrustPolicyUpdated = !PoliciesEqual(snapshot.trustPolicy, trustPolicy)
+		result.TagsUpdated = len(missingTags(existing.Tags, tags)) > 0
 
 	} else {
 		// IAM role does not exist, create it
-		if err := c.createRole(ctx, name, trustPolicy); err != nil {
+		if existing, err = c.createRole(ctx, name, trustPolicy, tags); err != nil {
+			return result, err
+		}
+		result.Created = true
+		result.PoliciesAdded = SortedKeys(inlinePolicies)
+	}
+
+	result.ARN = aws.ToString(existing.Arn)
+
+	if err = c.applyUpsert(ctx, name, existing, result, trustPolicy, inlinePolicies, managedPolicies, tags); err != nil {
+		if !IsTerminalError(err) {
+			return result, err
+		}
+		rollbackCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+		defer cancel()
+		return result, &RollbackError{Err: err, RollbackErr: c.rollbackUpsert(rollbackCtx, name, result, snapshot)}
+	}
+
+	return result, nil
+}
+
+// applyUpsert makes the changes to a role determined by Upsert, after the role exists
+func (c *AWSRoleClient) applyUpsert(ctx context.Context, name string, existing *types.Role, result *UpsertResult, trustPolicy string, inlinePolicies map[string]string, managedPolicies map[string]string, tags map[string]string) error {
+	if result.Created {
+		if err := c.waitForRole(ctx, name); err != nil {
 			return err
 		}
 	}
 
-	// @todo only do this if we need to
-	if err = c.updateRoleTrustPolicy(ctx, name, trustPolicy); err != nil {
+	if result.TrustPolicyUpdated {
+		if err := c.updateRoleTrustPolicy(ctx, name, trustPolicy); err != nil {
+			return err
+		}
+	}
+
+	if result.TagsUpdated {
+		if err := c.tagRole(ctx, name, missingTags(existing.Tags, tags)); err != nil {
+			return err
+		}
+	}
+
+	// Add or update role inline policies
+	put := map[string]string{}
+	for _, policy := range append(append([]string{}, result.PoliciesAdded...), result.PoliciesUpdated...) {
+		put[policy] = inlinePolicies[policy]
+	}
+	if err := c.upsertRoleInlinePolicies(ctx, name, put); err != nil {
 		return err
 	}
 
-	// Update role inline policies
-	if err = c.upsertRoleInlinePolicies(ctx, name, inlinePolicies); err != nil {
+	// Attach the customer managed policies holding the statements which do not fit inline, before deleting the
+	// inline policies they may replace
+	managed, err := c.SyncManagedPolicies(ctx, name, result.ARN, managedPolicies)
+	result.Managed = managed
+	if err != nil {
 		return err
 	}
 
 	// Delete role inline policies
-	if err = c.deleteRoleInlinePolicies(ctx, name, inlinePoliciesToDelete); err != nil {
+	return c.deleteRoleInlinePolicies(ctx, name, result.PoliciesDeleted)
+}
+
+// rollbackUpsert restores the trust policy and inline policies of a role from the snapshot taken by Upsert, and
+// undoes the changes to its managed policies, or deletes the role if Upsert created it. Changes which were not made before the failure are restored anyway, as
+// restoring is idempotent. Tags added to the role are kept.
+func (c *AWSRoleClient) rollbackUpsert(ctx context.Context, name string, result *UpsertResult, snapshot *roleSnapshot) error {
+	c.log.Info("Rolling back changes to IAM role", "role", name)
+
+	if result.Created {
+		return c.Delete(ctx, name)
+	}
+
+	if result.TrustPolicyUpdated {
+		if err := c.updateRoleTrustPolicy(ctx, name, snapshot.trustPolicy); err != nil {
+			return err
+		}
+	}
+
+	for _, policy := range result.PoliciesAdded {
+		c.log.Info("Deleting inline role policy", "role", name, "policy", policy)
+		_, err := c.iam.DeleteRolePolicy(ctx, &iam.DeleteRolePolicyInput{
+			RoleName:   aws.String(name),
+			PolicyName: aws.String(policy),
+		})
+		var noSuchEntityException *types.NoSuchEntityException
+		if err != nil && !errors.As(err, &noSuchEntityException) {
+			return err
+		}
+	}
+
+	if err := c.upsertRoleInlinePolicies(ctx, name, snapshot.policies); err != nil {
 		return err
 	}
 
-	return nil
+	return c.rollbackManagedPolicies(ctx, name, result.ARN, result.Managed)
 }
 
-// Delete deletes a role and its associated inline policies.
+// Delete deletes a role and its associated inline policies, detaching any managed policies and deleting those
+// created by the operator. Deleting a role that does not exist is not an error, and a role without the owner tag
+// is left untouched, returning an OwnershipError.
 func (c *AWSRoleClient) Delete(ctx context.Context, name string) error {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
+
+	existing, err := c.getRole(ctx, name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		c.log.Info("AWS role does not exist, nothing to delete", "role", name)
+		return nil
+	}
+	if !roleHasTag(existing, RoleOwnerTag) {
+		return &OwnershipError{Resource: "IAM role", Name: name}
+	}
+
+	// Managed policies must be detached before a role can be deleted
+	if err = c.detachManagedPolicies(ctx, name); err != nil {
+		return err
+	}
 
 	existingInlinePolicies, err := c.getRoleInlinePolicies(ctx, name)
 	if err != nil {
@@ -105,26 +326,40 @@ func (c *AWSRoleClient) Delete(ctx context.Context, name string) error {
 	return err
 }
 
-// createRole calls the AWS IAM API to create a new role, using the provided assume role policy
-func (c *AWSRoleClient) createRole(ctx context.Context, name string, trustPolicy string) error {
-	client := iam.NewFromConfig(c.cfg)
+// createRole calls the AWS IAM API to create a new role, using the provided assume role policy, tagged with the
+// owner tag and the provided tags
+func (c *AWSRoleClient) createRole(ctx context.Context, name string, trustPolicy string, tags map[string]string) (*types.Role, error) {
+	client := c.iam
 
 	c.log.Info("Creating IAM role", "role", name)
-	_, err := client.CreateRole(ctx, &iam.CreateRoleInput{
+	out, err := client.CreateRole(ctx, &iam.CreateRoleInput{
 		RoleName:                 aws.String(name),
 		AssumeRolePolicyDocument: aws.String(trustPolicy),
-		Tags: []types.Tag{{
-			Key:   aws.String(roleOwnerTag),
+		Tags: append([]types.Tag{{
+			Key:   aws.String(RoleOwnerTag),
 			Value: aws.String("true"),
-		}},
+		}}, toTags(tags)...),
 	})
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	return out.Role, nil
+}
+
+// waitForRole polls the AWS IAM API until a newly created role can be read
+func (c *AWSRoleClient) waitForRole(ctx context.Context, name string) error {
+	waiter := iam.NewRoleExistsWaiter(c.iam, func(o *iam.RoleExistsWaiterOptions) {
+		o.MaxDelay = roleExistsMaxDelay
+	})
+
+	c.log.Info("Waiting for IAM role to become readable", "role", name)
+	return waiter.Wait(ctx, &iam.GetRoleInput{RoleName: aws.String(name)}, roleExistsTimeout)
 }
 
 // getRole calls the AWS IAM API to return the an AWS IAM role instance
 func (c *AWSRoleClient) getRole(ctx context.Context, name string) (*types.Role, error) {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
 	entity, err := client.GetRole(ctx, &iam.GetRoleInput{RoleName: aws.String(name)})
 
 	var noSuchEntityException *types.NoSuchEntityException
@@ -144,7 +379,7 @@ func (c *AWSRoleClient) getRole(ctx context.Context, name string) (*types.Role,
 
 // getRoleInlinePolicies calls the AWS IAM API to return a string array of currently applied inline policies
 func (c *AWSRoleClient) getRoleInlinePolicies(ctx context.Context, role string) ([]string, error) {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
 
 	c.log.Info("Retrieving list of current role policies", "role", role)
 	existingPolicies, err := client.ListRolePolicies(ctx, &iam.ListRolePoliciesInput{RoleName: aws.String(role)})
@@ -155,9 +390,23 @@ func (c *AWSRoleClient) getRoleInlinePolicies(ctx context.Context, role string)
 	return existingPolicies.PolicyNames, nil
 }
 
+// getRoleInlinePolicy calls the AWS IAM API to return the policy document of an inline policy
+func (c *AWSRoleClient) getRoleInlinePolicy(ctx context.Context, role string, policy string) (string, error) {
+	out, err := c.iam.GetRolePolicy(ctx, &iam.GetRolePolicyInput{
+		RoleName:   aws.String(role),
+		PolicyName: aws.String(policy),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// IAM returns policy documents URL encoded
+	return url.QueryUnescape(aws.ToString(out.PolicyDocument))
+}
+
 // updateRoleTrustPolicy calls the AWS IAM API to overwite the existing assume role policy on the role
 func (c *AWSRoleClient) updateRoleTrustPolicy(ctx context.Context, role string, trustPolicy string) error {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
 
 	c.log.Info("Updating role trust policy document", "role", role)
 	if _, err := client.UpdateAssumeRolePolicy(ctx, &iam.UpdateAssumeRolePolicyInput{
@@ -170,10 +419,20 @@ func (c *AWSRoleClient) updateRoleTrustPolicy(ctx context.Context, role string,
 	return nil
 }
 
+// tagRole calls the AWS IAM API to add (or overwrite) tags on the role
+func (c *AWSRoleClient) tagRole(ctx context.Context, role string, tags map[string]string) error {
+	c.log.Info("Tagging role", "role", role)
+	_, err := c.iam.TagRole(ctx, &iam.TagRoleInput{
+		RoleName: aws.String(role),
+		Tags:     toTags(tags),
+	})
+	return err
+}
+
 // upsertRoleInlinePolicies iterates over a string array of inline policies and calls the AWS IAM API to
 // add (or overwrite)
 func (c *AWSRoleClient) upsertRoleInlinePolicies(ctx context.Context, role string, inlinePolicies map[string]string) error {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
 
 	for policy, doc := range inlinePolicies {
 		c.log.Info("Upserting inline policy", "role", role, "policy", policy)
@@ -191,7 +450,7 @@ func (c *AWSRoleClient) upsertRoleInlinePolicies(ctx context.Context, role strin
 // deleteRoleInlinePolicies iterates over a string array of inline policies and calls the AWS IAM API to
 // delete.
 func (c *AWSRoleClient) deleteRoleInlinePolicies(ctx context.Context, role string, inlinePolicies []string) error {
-	client := iam.NewFromConfig(c.cfg)
+	client := c.iam
 
 	for _, policy := range inlinePolicies {
 		c.log.Info("Deleting inline role policy", "role", role, "policy", policy)
@@ -208,7 +467,12 @@ func (c *AWSRoleClient) deleteRoleInlinePolicies(ctx context.Context, role strin
 
 // roleHasTag iterates over IAM role object tags and returns true if passed tag exists
 func roleHasTag(role *types.Role, tag string) bool {
-	for _, v := range role.Tags {
+	return hasTag(role.Tags, tag)
+}
+
+// hasTag iterates over IAM tags and returns true if passed tag exists
+func hasTag(tags []types.Tag, tag string) bool {
+	for _, v := range tags {
 		if *v.Key == tag {
 			return true
 		}
@@ -216,22 +480,62 @@ func roleHasTag(role *types.Role, tag string) bool {
 	return false
 }
 
-// roleHasTag iterates over a string array of existing inline policy names, and compares it to map keys
-// in the new inline policies to add. If there is no match, the inline policy is added to a the return
-// value (to be deleted)
+// missingTags returns the tags which are not set (or set to a different value) in existing tags
+func missingTags(existing []types.Tag, tags map[string]string) map[string]string {
+	missing := map[string]string{}
+	for k, v := range tags {
+		found := false
+		for _, t := range existing {
+			if aws.ToString(t.Key) == k && aws.ToString(t.Value) == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing[k] = v
+		}
+	}
+	return missing
+}
+
+// toTags converts a map of tags to IAM tags, sorted by key
+func toTags(tags map[string]string) []types.Tag {
+	out := []types.Tag{}
+	for _, k := range SortedKeys(tags) {
+		out = append(out, types.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	return out
+}
+
+// getInlinePoliciesToDelete iterates over a string array of existing inline policy names, and compares it to map
+// keys in the new inline policies to add. If there is no match, the inline policy is added to the return value
+// (to be deleted)
 func getInlinePoliciesToDelete(existing []string, new map[string]string) []string {
 	delete := []string{}
 	for _, v := range existing {
-		for k, _ := range new {
-			keep := true
-			if v == k {
-				keep = false
-				break
-			}
-			if keep == false {
-				delete = append(delete, v)
-			}
+		if _, keep := new[v]; !keep {
+			delete = append(delete, v)
 		}
 	}
 	return delete
 }
+
+// SortedKeys returns the keys of a map in sorted order
+func SortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ContainsString returns true if the string array contains the value
+func ContainsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
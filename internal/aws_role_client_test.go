@@ -0,0 +1,407 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/aws/smithy-go"
+	"github.com/go-logr/logr"
+)
+
+// fakeIAMRole is a role held by fakeIAM
+type fakeIAMRole struct {
+	role     types.Role
+	policies map[string]string
+}
+
+// fakeIAMPolicy is a customer managed policy held by fakeIAM, with its versions by ID
+type fakeIAMPolicy struct {
+	tags           []types.Tag
+	versions       map[string]string
+	defaultVersion string
+	nextVersion    int
+}
+
+// fakeIAM is an in-memory implementation of the IAM role and managed policy APIs, which fails PutRolePolicy and
+// DeleteRolePolicy for the policies in failPut and failDelete with their error and records the calls which attach,
+// detach or delete policies. Calls to the rest of the API panic.
+type fakeIAM struct {
+	IAMAPI
+	roles      map[string]*fakeIAMRole
+	policies   map[string]*fakeIAMPolicy
+	attached   map[string][]string
+	failPut    map[string]error
+	failDelete map[string]error
+	calls      []string
+
+	// OIDC providers, by ARN
+	oidcProviders map[string]*iam.GetOpenIDConnectProviderOutput
+}
+
+func newFakeIAM() *fakeIAM {
+	return &fakeIAM{roles: map[string]*fakeIAMRole{}, policies: map[string]*fakeIAMPolicy{}, attached: map[string][]string{}, failPut: map[string]error{}, failDelete: map[string]error{}, oidcProviders: map[string]*iam.GetOpenIDConnectProviderOutput{}}
+}
+
+func (f *fakeIAM) GetRole(ctx context.Context, params *iam.GetRoleInput, optFns ...func(*iam.Options)) (*iam.GetRoleOutput, error) {
+	v, ok := f.roles[aws.ToString(params.RoleName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	role := v.role
+	return &iam.GetRoleOutput{Role: &role}, nil
+}
+
+func (f *fakeIAM) CreateRole(ctx context.Context, params *iam.CreateRoleInput, optFns ...func(*iam.Options)) (*iam.CreateRoleOutput, error) {
+	v := &fakeIAMRole{
+		role: types.Role{
+			RoleName:                 params.RoleName,
+			Arn:                      aws.String("arn:aws:iam::111111111111:role/" + aws.ToString(params.RoleName)),
+			AssumeRolePolicyDocument: params.AssumeRolePolicyDocument,
+			Tags:                     params.Tags,
+		},
+		policies: map[string]string{},
+	}
+	f.roles[aws.ToString(params.RoleName)] = v
+	role := v.role
+	return &iam.CreateRoleOutput{Role: &role}, nil
+}
+
+func (f *fakeIAM) DeleteRole(ctx context.Context, params *iam.DeleteRoleInput, optFns ...func(*iam.Options)) (*iam.DeleteRoleOutput, error) {
+	delete(f.roles, aws.ToString(params.RoleName))
+	return &iam.DeleteRoleOutput{}, nil
+}
+
+func (f *fakeIAM) ListRoles(ctx context.Context, params *iam.ListRolesInput, optFns ...func(*iam.Options)) (*iam.ListRolesOutput, error) {
+	out := &iam.ListRolesOutput{}
+	for _, name := range sortedRoleNames(f.roles) {
+		out.Roles = append(out.Roles, types.Role{RoleName: aws.String(name)})
+	}
+	return out, nil
+}
+
+func sortedRoleNames(roles map[string]*fakeIAMRole) []string {
+	names := make(map[string]string, len(roles))
+	for k := range roles {
+		names[k] = k
+	}
+	return SortedKeys(names)
+}
+
+func (f *fakeIAM) UpdateAssumeRolePolicy(ctx context.Context, params *iam.UpdateAssumeRolePolicyInput, optFns ...func(*iam.Options)) (*iam.UpdateAssumeRolePolicyOutput, error) {
+	f.roles[aws.ToString(params.RoleName)].role.AssumeRolePolicyDocument = params.PolicyDocument
+	return &iam.UpdateAssumeRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) TagRole(ctx context.Context, params *iam.TagRoleInput, optFns ...func(*iam.Options)) (*iam.TagRoleOutput, error) {
+	v := f.roles[aws.ToString(params.RoleName)]
+	v.role.Tags = append(v.role.Tags, params.Tags...)
+	return &iam.TagRoleOutput{}, nil
+}
+
+func (f *fakeIAM) ListRolePolicies(ctx context.Context, params *iam.ListRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListRolePoliciesOutput, error) {
+	return &iam.ListRolePoliciesOutput{PolicyNames: SortedKeys(f.roles[aws.ToString(params.RoleName)].policies)}, nil
+}
+
+func (f *fakeIAM) GetRolePolicy(ctx context.Context, params *iam.GetRolePolicyInput, optFns ...func(*iam.Options)) (*iam.GetRolePolicyOutput, error) {
+	doc, ok := f.roles[aws.ToString(params.RoleName)].policies[aws.ToString(params.PolicyName)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	return &iam.GetRolePolicyOutput{PolicyDocument: aws.String(doc)}, nil
+}
+
+func (f *fakeIAM) PutRolePolicy(ctx context.Context, params *iam.PutRolePolicyInput, optFns ...func(*iam.Options)) (*iam.PutRolePolicyOutput, error) {
+	if err, ok := f.failPut[aws.ToString(params.PolicyName)]; ok {
+		return nil, err
+	}
+	f.roles[aws.ToString(params.RoleName)].policies[aws.ToString(params.PolicyName)] = aws.ToString(params.PolicyDocument)
+	return &iam.PutRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) DeleteRolePolicy(ctx context.Context, params *iam.DeleteRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DeleteRolePolicyOutput, error) {
+	if err, ok := f.failDelete[aws.ToString(params.PolicyName)]; ok {
+		return nil, err
+	}
+	policies := f.roles[aws.ToString(params.RoleName)].policies
+	if _, ok := policies[aws.ToString(params.PolicyName)]; !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	delete(policies, aws.ToString(params.PolicyName))
+	f.calls = append(f.calls, "DeleteRolePolicy "+aws.ToString(params.PolicyName))
+	return &iam.DeleteRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) ListAttachedRolePolicies(ctx context.Context, params *iam.ListAttachedRolePoliciesInput, optFns ...func(*iam.Options)) (*iam.ListAttachedRolePoliciesOutput, error) {
+	out := &iam.ListAttachedRolePoliciesOutput{}
+	for _, arn := range f.attached[aws.ToString(params.RoleName)] {
+		out.AttachedPolicies = append(out.AttachedPolicies, types.AttachedPolicy{PolicyArn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) AttachRolePolicy(ctx context.Context, params *iam.AttachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.AttachRolePolicyOutput, error) {
+	f.attached[aws.ToString(params.RoleName)] = append(f.attached[aws.ToString(params.RoleName)], aws.ToString(params.PolicyArn))
+	f.calls = append(f.calls, "AttachRolePolicy "+aws.ToString(params.PolicyArn))
+	return &iam.AttachRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) DetachRolePolicy(ctx context.Context, params *iam.DetachRolePolicyInput, optFns ...func(*iam.Options)) (*iam.DetachRolePolicyOutput, error) {
+	role, arn := aws.ToString(params.RoleName), aws.ToString(params.PolicyArn)
+	if !ContainsString(f.attached[role], arn) {
+		return nil, &types.NoSuchEntityException{}
+	}
+	attached := []string{}
+	for _, v := range f.attached[role] {
+		if v != arn {
+			attached = append(attached, v)
+		}
+	}
+	f.attached[role] = attached
+	f.calls = append(f.calls, "DetachRolePolicy "+arn)
+	return &iam.DetachRolePolicyOutput{}, nil
+}
+
+func (f *fakeIAM) GetPolicy(ctx context.Context, params *iam.GetPolicyInput, optFns ...func(*iam.Options)) (*iam.GetPolicyOutput, error) {
+	arn := aws.ToString(params.PolicyArn)
+	v, ok := f.policies[arn]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	var attachments int32
+	for _, attached := range f.attached {
+		if ContainsString(attached, arn) {
+			attachments++
+		}
+	}
+	return &iam.GetPolicyOutput{Policy: &types.Policy{
+		Arn:              aws.String(arn),
+		Tags:             v.tags,
+		DefaultVersionId: aws.String(v.defaultVersion),
+		AttachmentCount:  aws.Int32(attachments),
+	}}, nil
+}
+
+func (f *fakeIAM) GetPolicyVersion(ctx context.Context, params *iam.GetPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.GetPolicyVersionOutput, error) {
+	v, ok := f.policies[aws.ToString(params.PolicyArn)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	doc, ok := v.versions[aws.ToString(params.VersionId)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	return &iam.GetPolicyVersionOutput{PolicyVersion: &types.PolicyVersion{VersionId: params.VersionId, Document: aws.String(doc)}}, nil
+}
+
+func (f *fakeIAM) ListPolicyVersions(ctx context.Context, params *iam.ListPolicyVersionsInput, optFns ...func(*iam.Options)) (*iam.ListPolicyVersionsOutput, error) {
+	v := f.policies[aws.ToString(params.PolicyArn)]
+	out := &iam.ListPolicyVersionsOutput{}
+	for _, id := range SortedKeys(v.versions) {
+		out.Versions = append(out.Versions, types.PolicyVersion{VersionId: aws.String(id), IsDefaultVersion: id == v.defaultVersion})
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) CreatePolicy(ctx context.Context, params *iam.CreatePolicyInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyOutput, error) {
+	arn := "arn:aws:iam::111111111111:policy" + aws.ToString(params.Path) + aws.ToString(params.PolicyName)
+	f.policies[arn] = &fakeIAMPolicy{tags: params.Tags, versions: map[string]string{"v1": aws.ToString(params.PolicyDocument)}, defaultVersion: "v1", nextVersion: 2}
+	return &iam.CreatePolicyOutput{Policy: &types.Policy{Arn: aws.String(arn)}}, nil
+}
+
+func (f *fakeIAM) CreatePolicyVersion(ctx context.Context, params *iam.CreatePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.CreatePolicyVersionOutput, error) {
+	v := f.policies[aws.ToString(params.PolicyArn)]
+	id := fmt.Sprintf("v%d", v.nextVersion)
+	v.nextVersion++
+	v.versions[id] = aws.ToString(params.PolicyDocument)
+	if params.SetAsDefault {
+		v.defaultVersion = id
+	}
+	return &iam.CreatePolicyVersionOutput{PolicyVersion: &types.PolicyVersion{VersionId: aws.String(id)}}, nil
+}
+
+func (f *fakeIAM) SetDefaultPolicyVersion(ctx context.Context, params *iam.SetDefaultPolicyVersionInput, optFns ...func(*iam.Options)) (*iam.SetDefaultPolicyVersionOutput, error) {
+	v := f.policies[aws.ToString(params.PolicyArn)]
+	if _, ok := v.versions[aws.ToString(params.VersionId)]; !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	v.defaultVersion = aws.ToString(params.VersionId)
+	return &iam.SetDefaultPolicyVersionOutput{}, nil
+}
+
+func (f *fakeIAM) DeletePolicyVersion(ctx context.Context, params *iam.DeletePolicyVersionInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyVersionOutput, error) {
+	delete(f.policies[aws.ToString(params.PolicyArn)].versions, aws.ToString(params.VersionId))
+	return &iam.DeletePolicyVersionOutput{}, nil
+}
+
+func (f *fakeIAM) DeletePolicy(ctx context.Context, params *iam.DeletePolicyInput, optFns ...func(*iam.Options)) (*iam.DeletePolicyOutput, error) {
+	delete(f.policies, aws.ToString(params.PolicyArn))
+	f.calls = append(f.calls, "DeletePolicy "+aws.ToString(params.PolicyArn))
+	return &iam.DeletePolicyOutput{}, nil
+}
+
+const (
+	testTrustPolicy    = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+	testNewTrustPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Service":"lambda.amazonaws.com"},"Action":"sts:AssumeRole"}]}`
+	testS3Policy       = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:GetObject","Resource":"*"}]}`
+	testNewS3Policy    = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"s3:PutObject","Resource":"*"}]}`
+	testSQSPolicy      = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"sqs:SendMessage","Resource":"*"}]}`
+)
+
+func TestUpsertRollsBackFailedUpdate(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	if _, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{"s3": testS3Policy, "sqs": testSQSPolicy}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changing the trust policy and s3 policy, deleting sqs and adding a policy which fails restores the role
+	fake.failPut["sns"] = &types.MalformedPolicyDocumentException{}
+	_, err := c.Upsert(ctx, "app", testNewTrustPolicy, map[string]string{"s3": testNewS3Policy, "sns": testSQSPolicy}, nil, nil)
+
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) {
+		t.Fatalf("expected a RollbackError, got %v", err)
+	}
+	if rollbackErr.RollbackErr != nil {
+		t.Fatalf("expected the rollback to succeed, got %v", rollbackErr.RollbackErr)
+	}
+	if !IsTerminalError(err) {
+		t.Fatal("expected the original error to be classified through the RollbackError")
+	}
+
+	role := fake.roles["app"]
+	if trust := aws.ToString(role.role.AssumeRolePolicyDocument); trust != testTrustPolicy {
+		t.Fatalf("expected the trust policy to be restored, got %s", trust)
+	}
+	if len(role.policies) != 2 || role.policies["s3"] != testS3Policy || role.policies["sqs"] != testSQSPolicy {
+		t.Fatalf("expected the inline policies to be restored, got %v", role.policies)
+	}
+}
+
+func TestUpsertRollsBackFailedCreate(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	fake.failPut["sqs"] = &types.MalformedPolicyDocumentException{}
+	_, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{"s3": testS3Policy, "sqs": testSQSPolicy}, nil, nil)
+
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || rollbackErr.RollbackErr != nil {
+		t.Fatalf("expected a successful rollback, got %v", err)
+	}
+	if _, ok := fake.roles["app"]; ok {
+		t.Fatal("expected the created role to be deleted")
+	}
+}
+
+func TestUpsertDoesNotRollBackRetryableError(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	if _, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{"s3": testS3Policy}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A throttled change is left in place to be completed by the retry, rather than undone
+	fake.failPut["sqs"] = &smithy.GenericAPIError{Code: "Throttling"}
+	_, err := c.Upsert(ctx, "app", testNewTrustPolicy, map[string]string{"s3": testS3Policy, "sqs": testSQSPolicy}, nil, nil)
+
+	var rollbackErr *RollbackError
+	if err == nil || errors.As(err, &rollbackErr) {
+		t.Fatalf("expected the error without a rollback, got %v", err)
+	}
+	if trust := aws.ToString(fake.roles["app"].role.AssumeRolePolicyDocument); trust != testNewTrustPolicy {
+		t.Fatalf("expected the trust policy update to be kept, got %s", trust)
+	}
+}
+
+func TestUpsertAttachesManagedPoliciesBeforeDeletingInline(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	if _, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{"s3": testS3Policy}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving the s3 statements to a managed policy attaches it before the inline policy is deleted
+	fake.calls = nil
+	result, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{}, map[string]string{"app-s3-1": testS3Policy}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"AttachRolePolicy arn:aws:iam::111111111111:policy/eks-iam-operator/app-s3-1", "DeleteRolePolicy s3"}
+	if len(fake.calls) != 2 || fake.calls[0] != expected[0] || fake.calls[1] != expected[1] {
+		t.Fatalf("expected calls %v, got %v", expected, fake.calls)
+	}
+	if len(result.Managed.Created) != 1 || len(result.PoliciesDeleted) != 1 {
+		t.Fatalf("expected a managed policy to be created and an inline policy deleted, got %+v", result)
+	}
+}
+
+func TestUpsertRollsBackManagedPolicies(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	s3ARN := "arn:aws:iam::111111111111:policy/eks-iam-operator/app-s3-1"
+	sqsARN := "arn:aws:iam::111111111111:policy/eks-iam-operator/app-sqs-1"
+	snsARN := "arn:aws:iam::111111111111:policy/eks-iam-operator/app-sns-1"
+	managed := map[string]string{"app-s3-1": testS3Policy, "app-sqs-1": testSQSPolicy}
+	if _, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{"legacy": testS3Policy}, managed, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Updating s3, replacing sqs with sns and then failing to delete the inline policy undoes the managed changes
+	fake.failDelete["legacy"] = &smithy.GenericAPIError{Code: "AccessDenied"}
+	managed = map[string]string{"app-s3-1": testNewS3Policy, "app-sns-1": testSQSPolicy}
+	_, err := c.Upsert(ctx, "app", testTrustPolicy, map[string]string{}, managed, nil)
+
+	var rollbackErr *RollbackError
+	if !errors.As(err, &rollbackErr) || rollbackErr.RollbackErr != nil {
+		t.Fatalf("expected a successful rollback, got %v", err)
+	}
+
+	if attached := fake.attached["app"]; len(attached) != 2 || !ContainsString(attached, s3ARN) || !ContainsString(attached, sqsARN) {
+		t.Fatalf("expected the s3 and sqs policies to be attached, got %v", attached)
+	}
+	if _, ok := fake.policies[snsARN]; ok {
+		t.Fatal("expected the created sns policy to be deleted")
+	}
+	if s3 := fake.policies[s3ARN]; s3.versions[s3.defaultVersion] != testS3Policy {
+		t.Fatalf("expected the previous s3 policy version to be restored, got %s", s3.versions[s3.defaultVersion])
+	}
+	if sqs, ok := fake.policies[sqsARN]; !ok || sqs.versions[sqs.defaultVersion] != testSQSPolicy || !hasTag(sqs.tags, RoleOwnerTag) {
+		t.Fatal("expected the deleted sqs policy to be created again")
+	}
+	if role := fake.roles["app"]; len(role.policies) != 1 || role.policies["legacy"] != testS3Policy {
+		t.Fatalf("expected the inline policies to be kept, got %v", role.policies)
+	}
+}
+
+func TestDeleteNotOwned(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	if _, err := fake.CreateRole(ctx, &iam.CreateRoleInput{RoleName: aws.String("app"), AssumeRolePolicyDocument: aws.String(testTrustPolicy)}); err != nil {
+		t.Fatal(err)
+	}
+	fake.roles["app"].policies["s3"] = testS3Policy
+
+	if err := c.Delete(ctx, "app"); !IsOwnershipError(err) {
+		t.Fatalf("expected an OwnershipError, got %v", err)
+	}
+	if role, ok := fake.roles["app"]; !ok || len(role.policies) != 1 {
+		t.Fatal("expected the role not owned by the operator to be left untouched")
+	}
+}
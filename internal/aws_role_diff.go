@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DesiredRoleState returns the state of a role managed by the operator with a trust policy, inline policies, its
+// own managed policies and tags. Tags holds only the provided tags, as Upsert leaves any other tags of the role alone
+// (and only checks the owner tag is present), and AttachedPolicies is empty, as other attached managed policies are
+// left alone too.
+func DesiredRoleState(trustPolicy string, inlinePolicies map[string]string, managedPolicies map[string]string, tags map[string]string) *RoleState {
+	return &RoleState{
+		TrustPolicy:      trustPolicy,
+		InlinePolicies:   inlinePolicies,
+		Tags:             tags,
+		ManagedPolicies:  managedPolicies,
+		AttachedPolicies: []string{},
+	}
+}
+
+// DiffRoleStates returns a unified diff of the changes Upsert would make to a role in the current state to reach the
+// desired state, as planned by PlanUpsert: its trust policy, each inline policy, each of the operator's managed
+// policies and the tags it adds or changes. Policies are compared semantically, so formatting differences are not
+// reported. Tags and attached managed policies which the operator does not manage are not drift, and are not
+// reported (see ForeignPolicies). A nil current state is a role that does not exist. The diff is empty when the
+// states match.
+func DiffRoleStates(name string, current, desired *RoleState) string {
+	var out strings.Builder
+	for _, change := range planChanges(name+"/", current, desired) {
+		out.WriteString(change.Diff)
+	}
+	return out.String()
+}
+
+// ForeignPolicies returns the ARNs of the managed policies attached to a role which the operator does not manage,
+// sorted. Upsert leaves them attached, but they grant the role permissions beyond those of its Role.
+func ForeignPolicies(current *RoleState) []string {
+	policies := []string{}
+	if current != nil {
+		policies = append(policies, current.AttachedPolicies...)
+	}
+	sort.Strings(policies)
+	return policies
+}
+
+// withTags returns a copy of a role's tags with tags added (or changed), as Upsert tags a role
+func withTags(current map[string]string, tags map[string]string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range current {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	return merged
+}
+
+// formatTags returns tags as sorted key=value lines
+func formatTags(tags map[string]string) string {
+	lines := []string{}
+	for _, k := range SortedKeys(tags) {
+		lines = append(lines, fmt.Sprintf("%s=%s", k, tags[k]))
+	}
+	return formatLines(lines)
+}
+
+// formatLines returns values as sorted lines
+func formatLines(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "\n") + "\n"
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDiffRoleStates(t *testing.T) {
+	desired := DesiredRoleState(testTrustPolicy, map[string]string{"s3": testS3Policy}, map[string]string{}, map[string]string{"team": "payments"})
+
+	tests := []struct {
+		name     string
+		current  *RoleState
+		expected []string
+	}{
+		{
+			name: "in sync with foreign tags and policies",
+			current: &RoleState{
+				TrustPolicy:      testTrustPolicy,
+				InlinePolicies:   map[string]string{"s3": testS3Policy},
+				Tags:             map[string]string{RoleOwnerTag: "true", "team": "payments", "cost-centre": "42"},
+				AttachedPolicies: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"},
+			},
+			expected: nil,
+		},
+		{
+			name: "changed tag",
+			current: &RoleState{
+				TrustPolicy:    testTrustPolicy,
+				InlinePolicies: map[string]string{"s3": testS3Policy},
+				Tags:           map[string]string{RoleOwnerTag: "true", "team": "search", "cost-centre": "42"},
+			},
+			expected: []string{"-team=search", "+team=payments"},
+		},
+		{
+			name: "changed inline policy",
+			current: &RoleState{
+				TrustPolicy:    testTrustPolicy,
+				InlinePolicies: map[string]string{"s3": testNewS3Policy},
+				Tags:           map[string]string{RoleOwnerTag: "true", "team": "payments"},
+			},
+			expected: []string{"app/inline-policies/s3", "s3:PutObject"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diff := DiffRoleStates("app", test.current, desired)
+			if len(test.expected) == 0 && diff != "" {
+				t.Fatalf("expected no diff, got\n%s", diff)
+			}
+			for _, v := range test.expected {
+				if !strings.Contains(diff, v) {
+					t.Fatalf("expected the diff to contain %q, got\n%s", v, diff)
+				}
+			}
+			if strings.Contains(diff, "-cost-centre") || strings.Contains(diff, "+cost-centre") {
+				t.Fatalf("expected tags the operator does not set to be left out, got\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestForeignPolicies(t *testing.T) {
+	current := &RoleState{AttachedPolicies: []string{"arn:b", "arn:a"}}
+	if policies := ForeignPolicies(current); len(policies) != 2 || policies[0] != "arn:a" {
+		t.Fatalf("expected the sorted foreign policies, got %v", policies)
+	}
+	if policies := ForeignPolicies(nil); len(policies) != 0 {
+		t.Fatalf("expected no foreign policies for a missing role, got %v", policies)
+	}
+}
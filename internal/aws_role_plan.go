@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// Actions of the changes in a plan
+const (
+	PlanActionCreateRole          = "CreateRole"
+	PlanActionUpdateTrustPolicy   = "UpdateTrustPolicy"
+	PlanActionTagRole             = "TagRole"
+	PlanActionPutInlinePolicy     = "PutInlinePolicy"
+	PlanActionDeleteInlinePolicy  = "DeleteInlinePolicy"
+	PlanActionPutManagedPolicy    = "PutManagedPolicy"
+	PlanActionDeleteManagedPolicy = "DeleteManagedPolicy"
+	PlanActionDeleteRole          = "DeleteRole"
+)
+
+// PlannedChange is a change that Upsert or Delete would make to a role. Diff is a unified diff of the current and
+// desired policy document, for changes to the trust policy or an inline policy.
+type PlannedChange struct {
+	Action string
+	Policy string
+	Diff   string
+}
+
+// RoleState is the trust policy, inline policies, tags and managed policies of an IAM role. The operator's own
+// managed policies (keyed by policy name, with the document of their default version) are kept apart from the ARNs
+// of any other attached managed policies.
+type RoleState struct {
+	Role             *types.Role
+	TrustPolicy      string
+	InlinePolicies   map[string]string
+	Tags             map[string]string
+	ManagedPolicies  map[string]string
+	AttachedPolicies []string
+}
+
+// PlanUpsert returns the changes Upsert would make to a role, without modifying it. Only read-only IAM APIs are
+// called.
+func (c *AWSRoleClient) PlanUpsert(ctx context.Context, name string, trustPolicy string, inlinePolicies map[string]string, managedPolicies map[string]string, tags map[string]string) ([]PlannedChange, error) {
+	current, err := c.GetRoleState(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if current != nil && !roleHasTag(current.Role, RoleOwnerTag) {
+		return nil, &OwnershipError{Resource: "IAM role", Name: name}
+	}
+
+	return planChanges("", current, DesiredRoleState(trustPolicy, inlinePolicies, managedPolicies, tags)), nil
+}
+
+// planChanges returns the changes Upsert makes to a role in the current state (nil if it does not exist) to reach
+// the desired state, with the diffs labelled by prefix and the policy changed. Only the tags of the desired state
+// which are missing are added, and attached policies which the operator does not manage are left alone.
+func planChanges(prefix string, current, desired *RoleState) []PlannedChange {
+	changes := []PlannedChange{}
+	if current == nil {
+		changes = append(changes, PlannedChange{Action: PlanActionCreateRole, Diff: DiffPolicies(prefix+"trust-policy", "", desired.TrustPolicy)})
+		for _, policy := range SortedKeys(desired.InlinePolicies) {
+			changes = append(changes, PlannedChange{Action: PlanActionPutInlinePolicy, Policy: policy, Diff: DiffPolicies(prefix+"inline-policies/"+policy, "", desired.InlinePolicies[policy])})
+		}
+		for _, policy := range SortedKeys(desired.ManagedPolicies) {
+			changes = append(changes, PlannedChange{Action: PlanActionPutManagedPolicy, Policy: policy, Diff: DiffPolicies(prefix+"managed-policies/"+policy, "", desired.ManagedPolicies[policy])})
+		}
+		return changes
+	}
+
+	if !PoliciesEqual(current.TrustPolicy, desired.TrustPolicy) {
+		changes = append(changes, PlannedChange{Action: PlanActionUpdateTrustPolicy, Diff: DiffPolicies(prefix+"trust-policy", current.TrustPolicy, desired.TrustPolicy)})
+	}
+	missing := map[string]string{}
+	for k, v := range desired.Tags {
+		if existing, ok := current.Tags[k]; !ok || existing != v {
+			missing[k] = v
+		}
+	}
+	if len(missing) > 0 {
+		changes = append(changes, PlannedChange{Action: PlanActionTagRole, Diff: UnifiedDiff("a/"+prefix+"tags", "b/"+prefix+"tags", formatTags(current.Tags), formatTags(withTags(current.Tags, missing)))})
+	}
+	for _, policy := range SortedKeys(desired.InlinePolicies) {
+		existing, ok := current.InlinePolicies[policy]
+		if ok && PoliciesEqual(existing, desired.InlinePolicies[policy]) {
+			continue
+		}
+		changes = append(changes, PlannedChange{Action: PlanActionPutInlinePolicy, Policy: policy, Diff: DiffPolicies(prefix+"inline-policies/"+policy, existing, desired.InlinePolicies[policy])})
+	}
+	for _, policy := range SortedKeys(current.InlinePolicies) {
+		if _, keep := desired.InlinePolicies[policy]; !keep {
+			changes = append(changes, PlannedChange{Action: PlanActionDeleteInlinePolicy, Policy: policy, Diff: DiffPolicies(prefix+"inline-policies/"+policy, current.InlinePolicies[policy], "")})
+		}
+	}
+	for _, policy := range SortedKeys(desired.ManagedPolicies) {
+		existing, ok := current.ManagedPolicies[policy]
+		if ok && PoliciesEqual(existing, desired.ManagedPolicies[policy]) {
+			continue
+		}
+		changes = append(changes, PlannedChange{Action: PlanActionPutManagedPolicy, Policy: policy, Diff: DiffPolicies(prefix+"managed-policies/"+policy, existing, desired.ManagedPolicies[policy])})
+	}
+	for _, policy := range SortedKeys(current.ManagedPolicies) {
+		if _, keep := desired.ManagedPolicies[policy]; !keep {
+			changes = append(changes, PlannedChange{Action: PlanActionDeleteManagedPolicy, Policy: policy, Diff: DiffPolicies(prefix+"managed-policies/"+policy, current.ManagedPolicies[policy], "")})
+		}
+	}
+
+	return changes
+}
+
+// PlanDelete returns the changes Delete would make, without modifying the role. Only read-only IAM APIs are called.
+func (c *AWSRoleClient) PlanDelete(ctx context.Context, name string) ([]PlannedChange, error) {
+	current, err := c.GetRoleState(ctx, name)
+	if err != nil || current == nil {
+		return nil, err
+	}
+	if _, owned := current.Tags[RoleOwnerTag]; !owned {
+		return nil, &OwnershipError{Resource: "IAM role", Name: name}
+	}
+
+	changes := []PlannedChange{}
+	for _, policy := range SortedKeys(current.InlinePolicies) {
+		changes = append(changes, PlannedChange{Action: PlanActionDeleteInlinePolicy, Policy: policy, Diff: DiffPolicies(policy, current.InlinePolicies[policy], "")})
+	}
+	for _, policy := range SortedKeys(current.ManagedPolicies) {
+		changes = append(changes, PlannedChange{Action: PlanActionDeleteManagedPolicy, Policy: policy, Diff: DiffPolicies(policy, current.ManagedPolicies[policy], "")})
+	}
+	changes = append(changes, PlannedChange{Action: PlanActionDeleteRole, Diff: DiffPolicies("trust-policy", current.TrustPolicy, "")})
+
+	return changes, nil
+}
+
+// GetRoleState returns the current trust policy, inline policies, tags and managed policies of a role, or nil if the
+// role does not exist
+func (c *AWSRoleClient) GetRoleState(ctx context.Context, name string) (*RoleState, error) {
+	role, err := c.getRole(ctx, name)
+	if err != nil || role == nil {
+		return nil, err
+	}
+
+	// IAM returns policy documents URL encoded
+	trustPolicy, err := url.QueryUnescape(aws.ToString(role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := c.getRoleInlinePolicies(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	inlinePolicies := map[string]string{}
+	for _, policy := range names {
+		if inlinePolicies[policy], err = c.getRoleInlinePolicy(ctx, name, policy); err != nil {
+			return nil, err
+		}
+	}
+
+	tags := map[string]string{}
+	for _, v := range role.Tags {
+		tags[aws.ToString(v.Key)] = aws.ToString(v.Value)
+	}
+
+	attached, err := c.ListAttachedPolicies(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	managedPolicies := map[string]string{}
+	attachedPolicies := []string{}
+	for _, arn := range attached {
+		if !isOperatorManagedPolicy(arn) {
+			attachedPolicies = append(attachedPolicies, arn)
+			continue
+		}
+		policy, doc, err := c.getManagedPolicy(ctx, arn)
+		if IsOwnershipError(err) {
+			attachedPolicies = append(attachedPolicies, arn)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if policy != nil {
+			managedPolicies[managedPolicyName(arn)] = doc
+		}
+	}
+
+	return &RoleState{
+		Role:             role,
+		TrustPolicy:      trustPolicy,
+		InlinePolicies:   inlinePolicies,
+		Tags:             tags,
+		ManagedPolicies:  managedPolicies,
+		AttachedPolicies: attachedPolicies,
+	}, nil
+}
+
+// ListAttachedPolicies returns the ARNs of the managed policies attached to a role, sorted
+func (c *AWSRoleClient) ListAttachedPolicies(ctx context.Context, name string) ([]string, error) {
+	arns := []string{}
+	paginator := iam.NewListAttachedRolePoliciesPaginator(c.iam, &iam.ListAttachedRolePoliciesInput{RoleName: aws.String(name)})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range out.AttachedPolicies {
+			arns = append(arns, aws.ToString(v.PolicyArn))
+		}
+	}
+	sort.Strings(arns)
+	return arns, nil
+}
+
+// ListRoleNames returns the names of the IAM roles whose name starts with a prefix and ends with a suffix
+func (c *AWSRoleClient) ListRoleNames(ctx context.Context, prefix string, suffix string) ([]string, error) {
+	names := []string{}
+	paginator := iam.NewListRolesPaginator(c.iam, &iam.ListRolesInput{})
+	for paginator.HasMorePages() {
+		out, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range out.Roles {
+			if name := aws.ToString(v.RoleName); strings.HasPrefix(name, prefix) && strings.HasSuffix(name, suffix) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names, nil
+}
+
+// OwnedRole is an IAM role owned by the operator
+type OwnedRole struct {
+	Name       string
+	Tags       map[string]string
+	CreateDate time.Time
+}
+
+// FilterRolesByTags returns the names of the roles which have all of the provided tags, reading only the role
+// itself rather than its policies. Roles which do not exist are dropped.
+func (c *AWSRoleClient) FilterRolesByTags(ctx context.Context, names []string, tags map[string]string) ([]string, error) {
+	matched := []string{}
+	for _, name := range names {
+		role, err := c.getRole(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if role != nil && len(missingTags(role.Tags, tags)) == 0 {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// OwnedRoles returns the roles of the provided names which have the owner tag and all of the provided tags, reading
+// only the role itself rather than its policies. Roles which do not exist are dropped. ListRoles does not return
+// the tags of roles, so each role is read, and callers should narrow the names down first.
+func (c *AWSRoleClient) OwnedRoles(ctx context.Context, names []string, tags map[string]string) ([]OwnedRole, error) {
+	owned := []OwnedRole{}
+	for _, name := range names {
+		role, err := c.getRole(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if role == nil || !roleHasTag(role, RoleOwnerTag) || len(missingTags(role.Tags, tags)) > 0 {
+			continue
+		}
+
+		roleTags := map[string]string{}
+		for _, v := range role.Tags {
+			roleTags[aws.ToString(v.Key)] = aws.ToString(v.Value)
+		}
+		owned = append(owned, OwnedRole{Name: name, Tags: roleTags, CreateDate: aws.ToTime(role.CreateDate)})
+	}
+	return owned, nil
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numberedLines returns the lines 1 to n, with the lines in changes replaced
+func numberedLines(n int, changes map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if v, ok := changes[i]; ok {
+			b.WriteString(v + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return b.String()
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		expected string
+	}{
+		{
+			name:     "no change",
+			from:     numberedLines(5, nil),
+			to:       numberedLines(5, nil),
+			expected: "",
+		},
+		{
+			name:     "empty from",
+			from:     "",
+			to:       "a\nb\n",
+			expected: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "empty to",
+			from:     "a\nb\n",
+			to:       "",
+			expected: "--- a\n+++ b\n@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "context lines",
+			from: numberedLines(10, nil),
+			to:   numberedLines(10, map[int]string{5: "x"}),
+			expected: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n" +
+				" 2\n 3\n 4\n-5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			name: "nearby changes merge into one hunk",
+			from: numberedLines(20, nil),
+			to:   numberedLines(20, map[int]string{3: "x", 9: "y"}),
+			expected: "--- a\n+++ b\n@@ -1,12 +1,12 @@\n" +
+				" 1\n 2\n-3\n+x\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n 11\n 12\n",
+		},
+		{
+			name: "distant changes are separate hunks",
+			from: numberedLines(20, nil),
+			to:   numberedLines(20, map[int]string{3: "x", 15: "y"}),
+			expected: "--- a\n+++ b\n@@ -1,6 +1,6 @@\n" +
+				" 1\n 2\n-3\n+x\n 4\n 5\n 6\n" +
+				"@@ -12,7 +12,7 @@\n" +
+				" 12\n 13\n 14\n-15\n+y\n 16\n 17\n 18\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := UnifiedDiff("a", "b", tt.from, tt.to); diff != tt.expected {
+				t.Fatalf("expected diff:\n%s\ngot:\n%s", tt.expected, diff)
+			}
+		})
+	}
+}
+
+func TestDiffPolicies(t *testing.T) {
+	// Documents which only differ in formatting and key order are equal
+	if diff := DiffPolicies("s3", `{"Version":"2012-10-17","Statement":[]}`, `{ "Statement": [], "Version": "2012-10-17" }`); diff != "" {
+		t.Fatalf("expected no diff, got:\n%s", diff)
+	}
+
+	// A policy being added is diffed against an empty document
+	diff := DiffPolicies("s3", "", `{"Version":"2012-10-17"}`)
+	expected := "--- a/s3\n+++ b/s3\n@@ -0,0 +1,3 @@\n+{\n+  \"Version\": \"2012-10-17\"\n+}\n"
+	if diff != expected {
+		t.Fatalf("expected diff:\n%s\ngot:\n%s", expected, diff)
+	}
+}
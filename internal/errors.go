@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// OwnershipError is returned when an AWS resource that the operator would modify exists, but does not have the
+// operator owner tag
+type OwnershipError struct {
+	Resource string
+	Name     string
+}
+
+func (e *OwnershipError) Error() string {
+	return fmt.Sprintf("Not modifying %s %s as it does not have the operator owner tag", e.Resource, e.Name)
+}
+
+// IsOwnershipError returns true if the error is (or wraps) an OwnershipError
+func IsOwnershipError(err error) bool {
+	var ownershipErr *OwnershipError
+	return errors.As(err, &ownershipErr)
+}
+
+// RollbackError is returned when changing an AWS resource failed part way, after the changes already made were
+// rolled back. RollbackErr is set if the rollback failed too, leaving the resource partially changed.
+type RollbackError struct {
+	Err         error
+	RollbackErr error
+}
+
+func (e *RollbackError) Error() string {
+	if e.RollbackErr != nil {
+		return fmt.Sprintf("%v (rollback failed: %v)", e.Err, e.RollbackErr)
+	}
+	return fmt.Sprintf("%v (changes rolled back)", e.Err)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// IsThrottlingError returns true if the error is an AWS API error caused by request rate limiting
+func IsThrottlingError(err error) bool {
+	switch ErrorCode(err) {
+	case "Throttling", "ThrottlingException", "RequestLimitExceeded", "TooManyRequestsException":
+		return true
+	}
+	return false
+}
+
+// IsTerminalError returns true if the error cannot be resolved by retrying the same request, e.g. an invalid
+// policy document, an IAM quota being reached, missing permissions, or a resource not owned by the operator
+func IsTerminalError(err error) bool {
+	if IsOwnershipError(err) {
+		return true
+	}
+
+	switch ErrorCode(err) {
+	case "MalformedPolicyDocument", "LimitExceeded", "AccessDenied", "AccessDeniedException", "InvalidInput", "ValidationError":
+		return true
+	}
+	return false
+}
+
+// IsServerError returns true if the error is an AWS API response with a 5xx status code
+func IsServerError(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() >= 500
+}
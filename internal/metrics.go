@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	awsAPICalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eks_iam_operator_aws_api_calls_total",
+		Help: "Number of AWS API calls, by service and operation",
+	}, []string{"service", "operation"})
+
+	awsAPIErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "eks_iam_operator_aws_api_errors_total",
+		Help: "Number of failed AWS API calls, by service, operation and AWS error code (e.g. Throttling, NoSuchEntity, LimitExceeded, MalformedPolicyDocument)",
+	}, []string{"service", "operation", "code"})
+
+	awsAPILatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "eks_iam_operator_aws_api_call_duration_seconds",
+		Help:    "Duration of AWS API calls including retries, by service and operation",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"service", "operation"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(awsAPICalls, awsAPIErrors, awsAPILatency)
+}
+
+// addAPIMetricsMiddleware adds a middleware to an AWS SDK client stack which records call counts, errors and latency
+// for every API operation
+func addAPIMetricsMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("EKSIAMOperatorAPIMetrics", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+		start := time.Now()
+		out, metadata, err := next.HandleInitialize(ctx, in)
+
+		service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+		awsAPICalls.WithLabelValues(service, operation).Inc()
+		awsAPILatency.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+		if err != nil {
+			awsAPIErrors.WithLabelValues(service, operation, ErrorCode(err)).Inc()
+		}
+
+		return out, metadata, err
+	}), middleware.After)
+}
+
+// ErrorCode returns the AWS error code of an error returned by the AWS SDK, or "Unknown" for other errors
+func ErrorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return "Unknown"
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// openIDConfigurationPath is the API server endpoint serving the service account issuer discovery document
+const openIDConfigurationPath = "/.well-known/openid-configuration"
+
+// DiscoverOIDCIssuerFromCluster calls the AWS EKS API to return the OIDC issuer URL of an EKS cluster
+func (c *AWSRoleClient) DiscoverOIDCIssuerFromCluster(ctx context.Context, cluster string) (string, error) {
+	c.log.Info("Discovering OIDC issuer from EKS cluster", "cluster", cluster)
+	out, err := c.eks.DescribeCluster(ctx, &eks.DescribeClusterInput{Name: aws.String(cluster)})
+	if err != nil {
+		return "", err
+	}
+
+	if out.Cluster == nil || out.Cluster.Identity == nil || out.Cluster.Identity.Oidc == nil || out.Cluster.Identity.Oidc.Issuer == nil {
+		return "", fmt.Errorf("EKS cluster %s does not have an OIDC issuer", cluster)
+	}
+
+	return *out.Cluster.Identity.Oidc.Issuer, nil
+}
+
+// FindOIDCProviderARN calls the AWS IAM API to return the ARN of the IAM OIDC provider registered for an issuer
+func (c *AWSRoleClient) FindOIDCProviderARN(ctx context.Context, issuerURL string) (string, error) {
+	c.log.Info("Discovering IAM OIDC provider", "issuer", issuerURL)
+	out, err := c.iam.ListOpenIDConnectProviders(ctx, &iam.ListOpenIDConnectProvidersInput{})
+	if err != nil {
+		return "", err
+	}
+
+	// Provider ARNs are of the form arn:aws:iam::<account>:oidc-provider/<issuer without scheme>
+	suffix := ":oidc-provider/" + strings.TrimPrefix(issuerURL, "https://")
+	for _, v := range out.OpenIDConnectProviderList {
+		if strings.HasSuffix(aws.ToString(v.Arn), suffix) {
+			return aws.ToString(v.Arn), nil
+		}
+	}
+
+	return "", &oidcProviderNotFoundError{issuerURL: issuerURL}
+}
+
+// oidcProviderNotFoundError is returned when no IAM OIDC provider is registered for an issuer
+type oidcProviderNotFoundError struct {
+	issuerURL string
+}
+
+func (e *oidcProviderNotFoundError) Error() string {
+	return fmt.Sprintf("no IAM OIDC provider found for issuer %s", e.issuerURL)
+}
+
+// DiscoverOIDCIssuerFromAPIServer returns the service account token issuer advertised by the Kubernetes API server
+func DiscoverOIDCIssuerFromAPIServer(ctx context.Context, cfg *rest.Config) (string, error) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := clientset.Discovery().RESTClient().Get().AbsPath(openIDConfigurationPath).DoRaw(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	doc := struct {
+		Issuer string `json:"issuer"`
+	}{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return "", err
+	}
+
+	if len(doc.Issuer) == 0 {
+		return "", fmt.Errorf("%s does not contain an issuer", openIDConfigurationPath)
+	}
+
+	return doc.Issuer, nil
+}
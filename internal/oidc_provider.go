@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+)
+
+// DefaultOIDCAudience is the service account token audience expected by AWS STS
+const DefaultOIDCAudience = "sts.amazonaws.com"
+
+// EnsureOIDCProvider creates the IAM OIDC provider for an issuer if it does not exist, or verifies that the
+// existing provider accepts the client IDs. Missing client IDs are only added to providers owned by the operator.
+// The ARN of the provider is returned.
+func (c *AWSRoleClient) EnsureOIDCProvider(ctx context.Context, issuerURL string, clientIDs []string) (string, error) {
+	arn, err := c.FindOIDCProviderARN(ctx, issuerURL)
+	var notFound *oidcProviderNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		return "", err
+	}
+
+	if err != nil {
+		return c.createOIDCProvider(ctx, issuerURL, clientIDs)
+	}
+
+	existing, err := c.iam.GetOpenIDConnectProvider(ctx, &iam.GetOpenIDConnectProviderInput{OpenIDConnectProviderArn: aws.String(arn)})
+	if err != nil {
+		return "", err
+	}
+
+	for _, id := range clientIDs {
+		if ContainsString(existing.ClientIDList, id) {
+			continue
+		}
+		if !hasTag(existing.Tags, RoleOwnerTag) {
+			return "", fmt.Errorf("IAM OIDC provider %s does not accept client ID %s, and does not have the operator owner tag", arn, id)
+		}
+
+		c.log.Info("Adding client ID to IAM OIDC provider", "provider", arn, "clientId", id)
+		if _, err := c.iam.AddClientIDToOpenIDConnectProvider(ctx, &iam.AddClientIDToOpenIDConnectProviderInput{
+			OpenIDConnectProviderArn: aws.String(arn),
+			ClientID:                 aws.String(id),
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	return arn, nil
+}
+
+// createOIDCProvider calls the AWS IAM API to create an OIDC provider for the issuer, using the thumbprint of the
+// issuer's JWKS endpoint certificate
+func (c *AWSRoleClient) createOIDCProvider(ctx context.Context, issuerURL string, clientIDs []string) (string, error) {
+	thumbprint, err := OIDCThumbprint(ctx, issuerURL)
+	if err != nil {
+		return "", err
+	}
+
+	c.log.Info("Creating IAM OIDC provider", "issuer", issuerURL)
+	out, err := c.iam.CreateOpenIDConnectProvider(ctx, &iam.CreateOpenIDConnectProviderInput{
+		Url:            aws.String(issuerURL),
+		ClientIDList:   clientIDs,
+		ThumbprintList: []string{thumbprint},
+		Tags: []types.Tag{{
+			Key:   aws.String(RoleOwnerTag),
+			Value: aws.String("true"),
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(out.OpenIDConnectProviderArn), nil
+}
+
+// OIDCThumbprint returns the SHA-1 thumbprint IAM expects for an OIDC provider, i.e. that of the top certificate in
+// the chain served by the issuer's JWKS endpoint
+func OIDCThumbprint(ctx context.Context, issuerURL string) (string, error) {
+	return oidcThumbprint(ctx, issuerURL, &tls.Config{})
+}
+
+// oidcThumbprint returns the thumbprint for an OIDC provider, connecting to the issuer with a TLS config
+func oidcThumbprint(ctx context.Context, issuerURL string, tlsConfig *tls.Config) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(issuerURL, "/")+openIDConfigurationPath, nil)
+	if err != nil {
+		return "", err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to fetch OIDC configuration for %s: %s", issuerURL, resp.Status)
+	}
+
+	doc := struct {
+		JWKSURI string `json:"jwks_uri"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+
+	jwks, err := url.Parse(doc.JWKSURI)
+	if err != nil {
+		return "", err
+	}
+
+	host := jwks.Host
+	if len(jwks.Port()) == 0 {
+		host = net.JoinHostPort(jwks.Hostname(), "443")
+	}
+
+	config := tlsConfig.Clone()
+	config.ServerName = jwks.Hostname()
+	dialer := &tls.Dialer{Config: config}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	certs := conn.(*tls.Conn).ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return "", fmt.Errorf("no certificates served by %s", host)
+	}
+
+	sum := sha1.Sum(certs[len(certs)-1].Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/iam/types"
+	"github.com/go-logr/logr"
+)
+
+const testOIDCProviderARN = "arn:aws:iam::111111111111:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+
+func (f *fakeIAM) ListOpenIDConnectProviders(ctx context.Context, params *iam.ListOpenIDConnectProvidersInput, optFns ...func(*iam.Options)) (*iam.ListOpenIDConnectProvidersOutput, error) {
+	out := &iam.ListOpenIDConnectProvidersOutput{}
+	for arn := range f.oidcProviders {
+		out.OpenIDConnectProviderList = append(out.OpenIDConnectProviderList, types.OpenIDConnectProviderListEntry{Arn: aws.String(arn)})
+	}
+	return out, nil
+}
+
+func (f *fakeIAM) GetOpenIDConnectProvider(ctx context.Context, params *iam.GetOpenIDConnectProviderInput, optFns ...func(*iam.Options)) (*iam.GetOpenIDConnectProviderOutput, error) {
+	provider, ok := f.oidcProviders[aws.ToString(params.OpenIDConnectProviderArn)]
+	if !ok {
+		return nil, &types.NoSuchEntityException{}
+	}
+	return provider, nil
+}
+
+func (f *fakeIAM) AddClientIDToOpenIDConnectProvider(ctx context.Context, params *iam.AddClientIDToOpenIDConnectProviderInput, optFns ...func(*iam.Options)) (*iam.AddClientIDToOpenIDConnectProviderOutput, error) {
+	provider := f.oidcProviders[aws.ToString(params.OpenIDConnectProviderArn)]
+	provider.ClientIDList = append(provider.ClientIDList, aws.ToString(params.ClientID))
+	f.calls = append(f.calls, "AddClientIDToOpenIDConnectProvider "+aws.ToString(params.ClientID))
+	return &iam.AddClientIDToOpenIDConnectProviderOutput{}, nil
+}
+
+func TestEnsureOIDCProviderClientIDs(t *testing.T) {
+	owned := []types.Tag{{Key: aws.String(RoleOwnerTag), Value: aws.String("true")}}
+
+	tests := []struct {
+		name      string
+		clientIDs []string
+		tags      []types.Tag
+		expected  []string
+		fails     bool
+	}{
+		{name: "accepted client IDs", clientIDs: []string{DefaultOIDCAudience}, tags: owned, expected: []string{DefaultOIDCAudience}},
+		{name: "accepted client IDs of a provider not owned", clientIDs: []string{DefaultOIDCAudience}, tags: nil, expected: []string{DefaultOIDCAudience}},
+		{name: "missing client ID", clientIDs: []string{DefaultOIDCAudience, "vault"}, tags: owned, expected: []string{DefaultOIDCAudience, "vault"}},
+		// Providers the operator did not create are never changed
+		{name: "missing client ID of a provider not owned", clientIDs: []string{DefaultOIDCAudience, "vault"}, tags: nil, expected: []string{DefaultOIDCAudience}, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeIAM()
+			fake.oidcProviders[testOIDCProviderARN] = &iam.GetOpenIDConnectProviderOutput{ClientIDList: []string{DefaultOIDCAudience}, Tags: tt.tags}
+			c := NewAWSRoleClientFromAPIs(fake, newFakeEKS(), logr.Discard())
+
+			arn, err := c.EnsureOIDCProvider(context.Background(), "https://oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE", tt.clientIDs)
+			if (err != nil) != tt.fails {
+				t.Fatalf("expected an error: %t, got %v", tt.fails, err)
+			}
+			if err == nil && arn != testOIDCProviderARN {
+				t.Fatalf("expected provider %s, got %s", testOIDCProviderARN, arn)
+			}
+
+			clientIDs := fake.oidcProviders[testOIDCProviderARN].ClientIDList
+			sort.Strings(clientIDs)
+			if fmt.Sprint(clientIDs) != fmt.Sprint(tt.expected) {
+				t.Fatalf("expected client IDs %v, got %v", tt.expected, clientIDs)
+			}
+		})
+	}
+}
+
+func TestOIDCThumbprint(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case openIDConfigurationPath:
+			fmt.Fprintf(w, `{"issuer":%q,"jwks_uri":%q}`, server.URL, server.URL+"/keys")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	tlsConfig := &tls.Config{RootCAs: roots}
+
+	// The thumbprint is that of the top certificate served by the JWKS endpoint, here the only one
+	sum := sha1.Sum(server.Certificate().Raw)
+	thumbprint, err := oidcThumbprint(context.Background(), server.URL+"/", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected thumbprint %s, got %s", hex.EncodeToString(sum[:]), thumbprint)
+	}
+
+	if _, err := oidcThumbprint(context.Background(), server.URL+"/missing", tlsConfig); err == nil {
+		t.Fatal("expected an error for an issuer without OIDC configuration")
+	}
+
+	// The certificate of the issuer must be trusted
+	if _, err := oidcThumbprint(context.Background(), server.URL, &tls.Config{}); err == nil {
+		t.Fatal("expected an error for an untrusted issuer")
+	}
+}
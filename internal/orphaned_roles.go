@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"time"
+)
+
+// OrphanedRole is an IAM role owned by the operator which no Role manages
+type OrphanedRole struct {
+	OwnedRole
+
+	// When the role was first found orphaned
+	Since time.Time
+}
+
+// Expired returns true if the role has been orphaned, and has existed, for longer than the grace period
+func (o OrphanedRole) Expired(now time.Time, gracePeriod time.Duration) bool {
+	return now.Sub(o.Since) >= gracePeriod && now.Sub(o.CreateDate) >= gracePeriod
+}
+
+// FindOrphanedRoles returns the owned roles which are neither in roleNames (the IAM roles managed for the existing
+// Roles) nor tagged with a source in sources (the namespace/name of the existing Roles). Each is orphaned since the
+// time in previous (keyed by role name) if it was already found orphaned, otherwise since now.
+func FindOrphanedRoles(owned []OwnedRole, roleNames map[string]bool, sources map[string]bool, previous map[string]time.Time, now time.Time) []OrphanedRole {
+	orphaned := []OrphanedRole{}
+	for _, v := range owned {
+		if roleNames[v.Name] || sources[v.Tags[RoleSourceTag]] {
+			continue
+		}
+		since, ok := previous[v.Name]
+		if !ok {
+			since = now
+		}
+		orphaned = append(orphaned, OrphanedRole{OwnedRole: v, Since: since})
+	}
+	return orphaned
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/go-logr/logr"
+)
+
+func TestOwnedRoles(t *testing.T) {
+	ctx := context.Background()
+	fake := newFakeIAM()
+	c := NewAWSRoleClientFromAPIs(fake, nil, logr.Discard())
+
+	for _, v := range []struct {
+		name string
+		tags map[string]string
+	}{
+		{"eks-a", map[string]string{RoleOwnerTag: "true", RoleClusterTag: "prod"}},
+		{"eks-b", map[string]string{RoleOwnerTag: "true", RoleClusterTag: "staging"}},
+		{"eks-c", map[string]string{RoleClusterTag: "prod"}},
+		{"other", map[string]string{RoleOwnerTag: "true", RoleClusterTag: "prod"}},
+	} {
+		if _, err := fake.CreateRole(ctx, &iam.CreateRoleInput{RoleName: aws.String(v.name), Tags: toTags(v.tags)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names, err := c.ListRoleNames(ctx, "eks-", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 3 {
+		t.Fatalf("expected the roles with the prefix, got %v", names)
+	}
+	if suffixed, err := c.ListRoleNames(ctx, "eks-", "-c"); err != nil || len(suffixed) != 1 || suffixed[0] != "eks-c" {
+		t.Fatalf("expected only the role with the prefix and suffix, got %v (%v)", suffixed, err)
+	}
+	owned, err := c.OwnedRoles(ctx, names, map[string]string{RoleClusterTag: "prod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(owned) != 1 || owned[0].Name != "eks-a" || owned[0].Tags[RoleClusterTag] != "prod" {
+		t.Fatalf("expected only eks-a to be owned for the cluster, got %+v", owned)
+	}
+}
+
+func TestFindOrphanedRoles(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	created := now.Add(-48 * time.Hour)
+	owned := []OwnedRole{
+		{Name: "managed", CreateDate: created},
+		{Name: "renamed", Tags: map[string]string{RoleSourceTag: "default/app"}, CreateDate: created},
+		{Name: "new-orphan", Tags: map[string]string{RoleSourceTag: "default/gone"}, CreateDate: created},
+		{Name: "old-orphan", Tags: map[string]string{RoleSourceTag: "default/gone"}, CreateDate: created},
+		{Name: "young-orphan", CreateDate: now.Add(-time.Hour)},
+	}
+	previous := map[string]time.Time{
+		"old-orphan":   now.Add(-25 * time.Hour),
+		"young-orphan": now.Add(-25 * time.Hour),
+		"managed":      now.Add(-25 * time.Hour),
+	}
+
+	orphaned := FindOrphanedRoles(owned, map[string]bool{"managed": true}, map[string]bool{"default/app": true}, previous, now)
+
+	expected := []struct {
+		name    string
+		since   time.Time
+		expired bool
+	}{
+		{"new-orphan", now, false},
+		{"old-orphan", now.Add(-25 * time.Hour), true},
+		{"young-orphan", now.Add(-25 * time.Hour), false},
+	}
+	if len(orphaned) != len(expected) {
+		t.Fatalf("expected %d orphaned roles, got %+v", len(expected), orphaned)
+	}
+	for i, v := range expected {
+		if orphaned[i].Name != v.name || !orphaned[i].Since.Equal(v.since) {
+			t.Errorf("expected %s orphaned since %s, got %s since %s", v.name, v.since, orphaned[i].Name, orphaned[i].Since)
+		}
+		if expired := orphaned[i].Expired(now, 24*time.Hour); expired != v.expired {
+			t.Errorf("expected %s expired to be %t, got %t", v.name, v.expired, expired)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Rules of the policy linter
+const (
+	LintRuleWildcardResourceWrite = "WildcardResourceWrite"
+	LintRuleUnrestrictedPassRole  = "UnrestrictedPassRole"
+	LintRuleServiceWildcard       = "ServiceWildcard"
+	LintRuleAssumeAnyRole         = "AssumeAnyRole"
+	LintRulePrivilegeEscalation   = "PrivilegeEscalation"
+)
+
+// LintRules lists every rule of the policy linter
+var LintRules = []string{
+	LintRuleWildcardResourceWrite,
+	LintRuleUnrestrictedPassRole,
+	LintRuleServiceWildcard,
+	LintRuleAssumeAnyRole,
+	LintRulePrivilegeEscalation,
+}
+
+// LintFinding is a risky permission found in a policy. Policy is empty for findings across the policies of a role.
+type LintFinding struct {
+	Rule    string
+	Policy  string
+	Message string
+}
+
+// Action names which start with these verbs only read, everything else (including wildcards matching other verbs)
+// is treated as a write
+var readOnlyActionVerbs = []string{"get", "list", "describe", "batchget", "query", "scan", "search", "lookup", "filter", "view", "select", "head"}
+
+// Write actions which do not support resource-level permissions, so must be granted on "*"
+var wildcardResourceOnlyActions = map[string]bool{
+	"cloudwatch:putmetricdata": true,
+	"xray:puttracesegments":    true,
+	"xray:puttelemetryrecords": true,
+}
+
+// Sets of actions which together allow a principal to escalate its privileges, e.g. by changing its own policies or
+// passing a more privileged role to a compute service
+var privilegeEscalations = [][]string{
+	{"iam:CreatePolicyVersion"},
+	{"iam:SetDefaultPolicyVersion"},
+	{"iam:AttachRolePolicy"},
+	{"iam:AttachUserPolicy"},
+	{"iam:AttachGroupPolicy"},
+	{"iam:PutRolePolicy"},
+	{"iam:PutUserPolicy"},
+	{"iam:PutGroupPolicy"},
+	{"iam:AddUserToGroup"},
+	{"iam:CreateAccessKey"},
+	{"iam:CreateLoginProfile"},
+	{"iam:UpdateLoginProfile"},
+	{"iam:UpdateAssumeRolePolicy", "sts:AssumeRole"},
+	{"iam:PassRole", "ec2:RunInstances"},
+	{"iam:PassRole", "lambda:CreateFunction", "lambda:InvokeFunction"},
+	{"iam:PassRole", "lambda:CreateFunction", "lambda:CreateEventSourceMapping"},
+	{"iam:PassRole", "cloudformation:CreateStack"},
+	{"iam:PassRole", "glue:CreateDevEndpoint"},
+	{"iam:PassRole", "ecs:RunTask"},
+	{"lambda:UpdateFunctionCode"},
+}
+
+// LintPolicies inspects JSON policy documents (keyed by policy name) for overly broad permissions: write actions on
+// any resource, iam:PassRole of any role, service-wide action wildcards, sts:AssumeRole of any role, and
+// combinations of actions which allow privilege escalation. Findings are returned in policy and statement order.
+func LintPolicies(policies map[string]string) ([]LintFinding, error) {
+	findings := []LintFinding{}
+	granted := []string{}
+
+	for _, name := range SortedKeys(policies) {
+		var doc AWSPolicyDocument
+		if err := json.Unmarshal([]byte(policies[name]), &doc); err != nil {
+			return nil, fmt.Errorf("policy %s: %w", name, err)
+		}
+
+		for i, stmt := range doc.Statement {
+			if stmt.Effect != "Allow" {
+				continue
+			}
+			actions := valueList(stmt.Actions)
+			resources := valueList(stmt.Resources)
+			granted = append(granted, actions...)
+
+			for _, v := range lintStatement(actions, resources) {
+				findings = append(findings, LintFinding{Rule: v.Rule, Policy: name, Message: fmt.Sprintf("statement %d: %s", i, v.Message)})
+			}
+		}
+	}
+
+	for _, combination := range privilegeEscalations {
+		if grantsAll(granted, combination) {
+			findings = append(findings, LintFinding{
+				Rule:    LintRulePrivilegeEscalation,
+				Message: fmt.Sprintf("%s allows privilege escalation", strings.Join(combination, " with ")),
+			})
+		}
+	}
+
+	return findings, nil
+}
+
+// lintStatement returns the findings for the actions and resources of an Allow statement
+func lintStatement(actions, resources []string) []LintFinding {
+	findings := []LintFinding{}
+
+	anyResource := ContainsString(resources, "*")
+
+	wildcards := []string{}
+	for _, action := range actions {
+		if action == "*" || strings.HasSuffix(action, ":*") {
+			wildcards = append(wildcards, action)
+		}
+	}
+	if len(wildcards) > 0 {
+		findings = append(findings, LintFinding{Rule: LintRuleServiceWildcard, Message: fmt.Sprintf("action wildcards %s grant every action of a service", strings.Join(wildcards, ", "))})
+	}
+
+	if anyResource {
+		writes := []string{}
+		for _, action := range actions {
+			if !isReadOnlyAction(action) && !wildcardResourceOnlyActions[strings.ToLower(action)] {
+				writes = append(writes, action)
+			}
+		}
+		if len(writes) > 0 {
+			findings = append(findings, LintFinding{Rule: LintRuleWildcardResourceWrite, Message: fmt.Sprintf("write actions %s are allowed on every resource", strings.Join(writes, ", "))})
+		}
+	}
+
+	if grantsAll(actions, []string{"iam:PassRole"}) && anyRoleResource(resources) {
+		findings = append(findings, LintFinding{Rule: LintRuleUnrestrictedPassRole, Message: "iam:PassRole is allowed for any role"})
+	}
+
+	if grantsAll(actions, []string{"sts:AssumeRole"}) && anyRoleResource(resources) {
+		findings = append(findings, LintFinding{Rule: LintRuleAssumeAnyRole, Message: "sts:AssumeRole is allowed for any role"})
+	}
+
+	return findings
+}
+
+// isReadOnlyAction returns true if an action (or every action matched by a wildcard action) only reads
+func isReadOnlyAction(action string) bool {
+	_, name, found := strings.Cut(strings.ToLower(action), ":")
+	if !found {
+		return false
+	}
+	for _, verb := range readOnlyActionVerbs {
+		if strings.HasPrefix(name, verb) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantsAll returns true if a list of actions (which may contain wildcards) grants every one of a set of actions
+func grantsAll(granted []string, actions []string) bool {
+	for _, action := range actions {
+		matched := false
+		for _, pattern := range granted {
+			if WildcardMatch(strings.ToLower(pattern), strings.ToLower(action)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// anyRoleResource returns true if a list of resources includes every IAM role of an account (or any account)
+func anyRoleResource(resources []string) bool {
+	for _, v := range resources {
+		if v == "*" || strings.HasSuffix(v, ":role/*") {
+			return true
+		}
+	}
+	return false
+}
+
+// LintRuleCounts returns the number of findings of each rule, with every rule present
+func LintRuleCounts(findings []LintFinding) map[string]int {
+	counts := map[string]int{}
+	for _, rule := range LintRules {
+		counts[rule] = 0
+	}
+	for _, v := range findings {
+		counts[v.Rule]++
+	}
+	return counts
+}
+
+// FilterLintFindings returns the findings whose rule is not ignored
+func FilterLintFindings(findings []LintFinding, ignoredRules []string) []LintFinding {
+	filtered := []LintFinding{}
+	for _, v := range findings {
+		if !ContainsString(ignoredRules, v.Rule) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
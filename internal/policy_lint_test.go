@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies map[string]string
+		want     []string
+	}{
+		{
+			name: "allows scoped and read-only permissions",
+			policies: map[string]string{
+				"app": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:GetObject","s3:PutObject"],"Resource":"arn:aws:s3:::a/*"},{"Effect":"Allow","Action":["ec2:Describe*","cloudwatch:PutMetricData"],"Resource":"*"}]}`,
+			},
+			want: []string{},
+		},
+		{
+			name: "flags write actions and service wildcards on any resource",
+			policies: map[string]string{
+				"app": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["s3:*","sqs:SendMessage"],"Resource":"*"}]}`,
+			},
+			want: []string{LintRuleServiceWildcard, LintRuleWildcardResourceWrite},
+		},
+		{
+			name: "flags unrestricted iam:PassRole and sts:AssumeRole",
+			policies: map[string]string{
+				"app": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":["iam:PassRole","sts:AssumeRole"],"Resource":"arn:aws:iam::111111111111:role/*"}]}`,
+			},
+			want: []string{LintRuleUnrestrictedPassRole, LintRuleAssumeAnyRole},
+		},
+		{
+			name: "flags privilege escalation across policies",
+			policies: map[string]string{
+				"a": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"iam:PassRole","Resource":"arn:aws:iam::111111111111:role/worker"}]}`,
+				"b": `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Action":"lambda:*Function","Resource":"arn:aws:lambda:eu-west-1:111111111111:function:*"}]}`,
+			},
+			want: []string{LintRulePrivilegeEscalation},
+		},
+		{
+			name: "ignores deny statements",
+			policies: map[string]string{
+				"app": `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Action":"*","Resource":"*"}]}`,
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings, err := LintPolicies(tt.policies)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules := []string{}
+			for _, v := range findings {
+				rules = append(rules, v.Rule)
+			}
+			if !reflect.DeepEqual(rules, tt.want) {
+				t.Errorf("LintPolicies() rules = %v, want %v (%v)", rules, tt.want, findings)
+			}
+		})
+	}
+}
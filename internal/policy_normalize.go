@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// NormalizeStatements returns the smallest equivalent set of policy statements, in a stable order, so that the
+// rendered policy is byte-stable across reconciles. Actions and resources are deduped and sorted, actions and
+// resources matched by a wildcard in the same statement are dropped, and statements which only differ in their
+// actions (or only in their resources) are merged.
+func NormalizeStatements(stmts []AWSPolicyDocumentStatement) []AWSPolicyDocumentStatement {
+	normalized := make([]AWSPolicyDocumentStatement, 0, len(stmts))
+	for _, stmt := range stmts {
+		normalized = append(normalized, normalizeStatement(stmt))
+	}
+
+	// Merging on resources can make statements identical in their actions, and the other way around
+	for {
+		merged := mergeStatements(normalized, func(s AWSPolicyDocumentStatement) interface{} { return s.Resources }, mergeActions)
+		merged = mergeStatements(merged, func(s AWSPolicyDocumentStatement) interface{} { return s.Actions }, mergeResources)
+		if len(merged) == len(normalized) {
+			break
+		}
+		normalized = merged
+	}
+
+	keys := make(map[int]string, len(normalized))
+	for i, stmt := range normalized {
+		keys[i] = statementKey(stmt, nil)
+	}
+	order := make([]int, len(normalized))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+	sorted := make([]AWSPolicyDocumentStatement, 0, len(normalized))
+	for _, i := range order {
+		sorted = append(sorted, normalized[i])
+	}
+	return sorted
+}
+
+// normalizeStatement dedupes, sorts and minimizes the actions and resources of a statement. Actions are compared
+// case-insensitively, as in IAM.
+func normalizeStatement(stmt AWSPolicyDocumentStatement) AWSPolicyDocumentStatement {
+	if stmt.Actions != nil {
+		stmt.Actions = singleOrList(minimizePatterns(valueList(stmt.Actions), true))
+	}
+	if stmt.Resources != nil {
+		stmt.Resources = singleOrList(minimizePatterns(valueList(stmt.Resources), false))
+	}
+	return stmt
+}
+
+// mergeStatements merges the statements which are identical apart from one field, keeping the position of the
+// first statement of each group
+func mergeStatements(stmts []AWSPolicyDocumentStatement, field func(AWSPolicyDocumentStatement) interface{}, merge func(a, b AWSPolicyDocumentStatement) AWSPolicyDocumentStatement) []AWSPolicyDocumentStatement {
+	merged := []AWSPolicyDocumentStatement{}
+	index := map[string]int{}
+	for _, stmt := range stmts {
+		key := statementKey(stmt, field)
+		if i, ok := index[key]; ok {
+			merged[i] = merge(merged[i], stmt)
+			continue
+		}
+		index[key] = len(merged)
+		merged = append(merged, stmt)
+	}
+	return merged
+}
+
+// mergeActions returns a statement with the actions of both statements
+func mergeActions(a, b AWSPolicyDocumentStatement) AWSPolicyDocumentStatement {
+	a.Actions = append(valueList(a.Actions), valueList(b.Actions)...)
+	return normalizeStatement(a)
+}
+
+// mergeResources returns a statement with the resources of both statements
+func mergeResources(a, b AWSPolicyDocumentStatement) AWSPolicyDocumentStatement {
+	a.Resources = append(valueList(a.Resources), valueList(b.Resources)...)
+	return normalizeStatement(a)
+}
+
+// statementKey returns a key identifying a statement by its sid, effect, principal, condition and the fields in
+// includes. A nil includes identifies the whole statement.
+func statementKey(stmt AWSPolicyDocumentStatement, includes func(AWSPolicyDocumentStatement) interface{}) string {
+	var key interface{} = stmt
+	if includes != nil {
+		key = []interface{}{stmt.Sid, stmt.Effect, stmt.Principal, stmt.Condition, includes(stmt)}
+	}
+	// Maps are marshalled with sorted keys, so the key is stable
+	j, _ := json.Marshal(key)
+	return string(j)
+}
+
+// minimizePatterns dedupes and sorts a list of actions or resources, dropping any value matched by a wildcard
+// pattern in the list. Values containing policy variables are never treated as patterns.
+func minimizePatterns(values []string, caseInsensitive bool) []string {
+	fold := func(s string) string {
+		if caseInsensitive {
+			return strings.ToLower(s)
+		}
+		return s
+	}
+
+	unique := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		if !seen[fold(v)] {
+			seen[fold(v)] = true
+			unique = append(unique, v)
+		}
+	}
+
+	minimized := []string{}
+	for _, v := range unique {
+		subsumed := false
+		for _, pattern := range unique {
+			if fold(pattern) != fold(v) && strings.ContainsAny(pattern, "*?") && !strings.Contains(pattern, "${") && WildcardMatch(fold(pattern), fold(v)) {
+				subsumed = true
+				break
+			}
+		}
+		if !subsumed {
+			minimized = append(minimized, v)
+		}
+	}
+
+	sort.Slice(minimized, func(i, j int) bool {
+		if fold(minimized[i]) != fold(minimized[j]) {
+			return fold(minimized[i]) < fold(minimized[j])
+		}
+		return minimized[i] < minimized[j]
+	})
+	return minimized
+}
+
+// WildcardMatch returns true if a value matches an IAM wildcard pattern, where * matches any sequence of characters
+// (including none) and ? matches any single character
+func WildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, match := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			v = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// valueList returns the values of an action or resource element, which is a string or a list of strings
+func valueList(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return append([]string{}, v...)
+	case []interface{}:
+		values := []string{}
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// singleOrList returns a single value as a string and several as a list, as IAM renders them
+func singleOrList(values []string) interface{} {
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestNormalizeStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		in   []AWSPolicyDocumentStatement
+		want string
+	}{
+		{
+			name: "dedupes and sorts actions and resources",
+			in: []AWSPolicyDocumentStatement{
+				{Effect: "Allow", Actions: []string{"s3:PutObject", "s3:GetObject", "S3:getobject"}, Resources: []string{"arn:aws:s3:::b/*", "arn:aws:s3:::a/*", "arn:aws:s3:::a/*"}},
+			},
+			want: `[{"Effect":"Allow","Resource":["arn:aws:s3:::a/*","arn:aws:s3:::b/*"],"Action":["s3:GetObject","s3:PutObject"]}]`,
+		},
+		{
+			name: "drops actions and resources matched by wildcards",
+			in: []AWSPolicyDocumentStatement{
+				{Effect: "Allow", Actions: []string{"s3:Get*", "s3:GetObject", "s3:ListBucket"}, Resources: []string{"arn:aws:s3:::a/*", "arn:aws:s3:::a/key", "arn:aws:s3:::a/${aws:username}"}},
+			},
+			want: `[{"Effect":"Allow","Resource":"arn:aws:s3:::a/*","Action":["s3:Get*","s3:ListBucket"]}]`,
+		},
+		{
+			name: "merges statements on the same resources",
+			in: []AWSPolicyDocumentStatement{
+				{Effect: "Allow", Actions: "s3:GetObject", Resources: "arn:aws:s3:::a/*"},
+				{Effect: "Allow", Actions: "s3:PutObject", Resources: "arn:aws:s3:::a/*"},
+				{Effect: "Allow", Actions: "s3:GetObject", Resources: "arn:aws:s3:::a/*"},
+			},
+			want: `[{"Effect":"Allow","Resource":"arn:aws:s3:::a/*","Action":["s3:GetObject","s3:PutObject"]}]`,
+		},
+		{
+			name: "merges statements with the same actions",
+			in: []AWSPolicyDocumentStatement{
+				{Effect: "Allow", Actions: "sqs:SendMessage", Resources: "arn:aws:sqs:eu-west-1:1:b"},
+				{Effect: "Allow", Actions: "sqs:SendMessage", Resources: "arn:aws:sqs:eu-west-1:1:a"},
+			},
+			want: `[{"Effect":"Allow","Resource":["arn:aws:sqs:eu-west-1:1:a","arn:aws:sqs:eu-west-1:1:b"],"Action":"sqs:SendMessage"}]`,
+		},
+		{
+			name: "keeps statements with different conditions apart, in a stable order",
+			in: []AWSPolicyDocumentStatement{
+				{Effect: "Allow", Actions: "s3:GetObject", Resources: "*", Condition: map[string]map[string][]string{"Bool": {"aws:SecureTransport": {"true"}}}},
+				{Effect: "Allow", Actions: "s3:PutObject", Resources: "*"},
+			},
+			want: `[{"Effect":"Allow","Resource":"*","Action":"s3:GetObject","Condition":{"Bool":{"aws:SecureTransport":["true"]}}},{"Effect":"Allow","Resource":"*","Action":"s3:PutObject"}]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := json.Marshal(NormalizeStatements(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(j) != tt.want {
+				t.Errorf("got %s, want %s", j, tt.want)
+			}
+		})
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "s3:GetObject", true},
+		{"s3:Get*", "s3:GetObject", true},
+		{"s3:Get*", "s3:PutObject", false},
+		{"s3:?etObject", "s3:GetObject", true},
+		{"arn:aws:s3:::a/*/b", "arn:aws:s3:::a/x/y/b", true},
+		{"arn:aws:s3:::a/*/b", "arn:aws:s3:::a/x/y/c", false},
+	}
+
+	for _, tt := range tests {
+		if got := WildcardMatch(tt.pattern, tt.value); got != tt.want {
+			t.Errorf("WildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+		}
+	}
+}
@@ -17,26 +17,64 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	eksiamoperatorv1beta1 "github.com/neilmcgibbon/eks-iam-operator/api/v1beta1"
 	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
 	//+kubebuilder:scaffold:imports
 )
 
+const (
+	// defaultRoleRenameGracePeriod is how long a previously named IAM role is kept when no grace period is configured
+	defaultRoleRenameGracePeriod = time.Hour
+
+	// defaultPropagationSettlePeriod is how long a Role is Propagating after an IAM change when no period is configured
+	defaultPropagationSettlePeriod = 10 * time.Second
+
+	// defaultResyncInterval is how often every Role is reconciled again when no interval is configured
+	defaultResyncInterval = 10 * time.Minute
+
+	// defaultRevisionHistoryLimit is how many RoleRevisions are kept for each Role when no limit is configured
+	defaultRevisionHistoryLimit = 10
+
+	// Defaults for garbage collection of orphaned IAM roles
+	defaultGarbageCollectionInterval    = time.Hour
+	defaultGarbageCollectionGracePeriod = 24 * time.Hour
+
+	// defaultShardLeaseDuration is how long the shard Lease of a replica is valid when no duration is configured
+	defaultShardLeaseDuration = 30 * time.Second
+
+	// serviceAccountNamespaceFile holds the namespace the operator runs in
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+	// defaultOperatorConfigName is the name of the OperatorConfig applied when the config file does not name one
+	defaultOperatorConfigName = "default"
+)
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -50,6 +88,18 @@ func init() {
 }
 
 func main() {
+	// Offline and CLI subcommands, the operator runs when no subcommand is given
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		case "diff":
+			os.Exit(runDiff(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
 	var err error
 	var configFlag string
 	flag.StringVar(&configFlag, "config", "", "The controller will load its configuration from this file.")
@@ -60,39 +110,137 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
-	ctrlConfig := eksiamoperatorv1beta1.Config{}
-	options := ctrl.Options{Scheme: scheme}
-	if options, err = options.AndFrom(ctrl.ConfigFile().AtPath(configFlag).OfKind(&ctrlConfig)); err != nil {
+	ctrlConfig, options, err := loadConfig(configFlag)
+	if err != nil {
 		setupLog.Error(err, "unable to load the config file")
 		os.Exit(1)
 	}
 
-	if err := validateConfig(ctrlConfig); err != nil {
+	if err := validateStartupConfig(ctrlConfig); err != nil {
 		setupLog.Error(err, "invalid config")
 		os.Exit(1)
 	}
+	if err := scopeCache(&options, ctrlConfig.Scope); err != nil {
+		setupLog.Error(err, "unable to scope the manager cache")
+		os.Exit(1)
+	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
+	// Every replica reconciles its share of the Roles when sharding, rather than the leader alone
+	if ctrlConfig.Sharding.Enabled {
+		options.LeaderElection = false
+	}
+
+	ctx := ctrl.SetupSignalHandler()
+	restConfig := ctrl.GetConfigOrDie()
+
+	mgr, err := ctrl.NewManager(restConfig, options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controllers.RoleReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-		Log:    ctrl.Log.WithName("eks-iam-controller"),
+	// Settings from the OperatorConfig take the place of those in the config file, while it exists and is valid
+	operatorConfigName := ctrlConfig.OperatorConfigName
+	if len(operatorConfigName) == 0 {
+		operatorConfigName = defaultOperatorConfigName
+	}
+	operatorConfig, err := getOperatorConfig(ctx, mgr.GetAPIReader(), operatorConfigName)
+	if err != nil {
+		setupLog.Error(err, "unable to read the OperatorConfig", "name", operatorConfigName)
+		os.Exit(1)
+	}
+	// initialSpec is the spec applied at startup, before it is completed and defaulted
+	var initialConfig eksiamoperatorv1beta1.Config
+	initialSpec := ctrlConfig.ConfigSpec
+	if operatorConfig != nil {
+		initialConfig = ctrlConfig
+		initialConfig.ConfigSpec = *operatorConfig.Spec.DeepCopy()
+		if err := prepareConfig(ctx, &initialConfig, restConfig); err != nil {
+			setupLog.Error(err, "invalid OperatorConfig, using the config file", "name", operatorConfigName)
+			operatorConfig = nil
+		} else {
+			setupLog.Info("using OperatorConfig", "name", operatorConfigName)
+			initialSpec = operatorConfig.Spec
+		}
+	}
+	if operatorConfig == nil {
+		initialConfig = ctrlConfig
+		if err := prepareConfig(ctx, &initialConfig, restConfig); err != nil {
+			setupLog.Error(err, "invalid config")
+			os.Exit(1)
+		}
+	}
 
-		RolePrefix:         ctrlConfig.RoleNameOptions.Prefix,
-		RoleSuffix:         ctrlConfig.RoleNameOptions.Suffix,
-		InlinePolicyPrefix: ctrlConfig.InlinePolicyNameOptions.Prefix,
-		InlinePolicySuffix: ctrlConfig.InlinePolicyNameOptions.Suffix,
-		OIDCIssuerURL:      ctrlConfig.OIDC.IssuerURL,
-		OIDCProviderARN:    ctrlConfig.OIDC.ProviderARN,
-	}).SetupWithManager(mgr); err != nil {
+	reconciler, err := newRoleReconciler(initialConfig)
+	if err != nil {
+		setupLog.Error(err, "unable to configure the Role reconciler")
+		os.Exit(1)
+	}
+	reconciler.Client = mgr.GetClient()
+	reconciler.Scheme = mgr.GetScheme()
+	reconciler.Log = ctrl.Log.WithName("eks-iam-controller")
+	if ctrlConfig.Sharding.Enabled {
+		if reconciler.Shards, err = newRoleShards(mgr, ctrlConfig.Sharding); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+		if err = mgr.Add(reconciler.Shards); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+	}
+	if ctrlConfig.Scope.NamespaceSelector != nil {
+		// validated by validateStartupConfig
+		reconciler.NamespaceSelector, _ = metav1.LabelSelectorAsSelector(ctrlConfig.Scope.NamespaceSelector)
+	}
+
+	if err = reconciler.SetupWithManager(ctx, mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Role")
 		os.Exit(1)
 	}
+
+	// The garbage collector always runs, as its policy can be changed by the OperatorConfig
+	garbageCollector := &controllers.RoleGarbageCollector{
+		Reader:     mgr.GetAPIReader(),
+		Reconciler: reconciler,
+		Log:        ctrl.Log.WithName("eks-iam-garbage-collector"),
+		Shards:     reconciler.Shards,
+
+		Policy:      initialConfig.GarbageCollection.Policy,
+		Interval:    initialConfig.GarbageCollection.Interval.Duration,
+		GracePeriod: initialConfig.GarbageCollection.GracePeriod.Duration,
+	}
+	if err = mgr.Add(garbageCollector); err != nil {
+		setupLog.Error(err, "unable to create garbage collector")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.OperatorConfigReconciler{
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("eks-iam-config-controller"),
+		Name:    operatorConfigName,
+		Default: ctrlConfig.ConfigSpec,
+		Initial: initialSpec,
+		Apply: func(ctx context.Context, spec eksiamoperatorv1beta1.ConfigSpec) error {
+			next := ctrlConfig
+			next.ConfigSpec = spec
+			if err := prepareConfig(ctx, &next, restConfig); err != nil {
+				return err
+			}
+			nextReconciler, err := newRoleReconciler(next)
+			if err != nil {
+				return fmt.Errorf("%w: %v", controllers.ErrInvalidConfig, err)
+			}
+			if err := reconciler.Reconfigure(ctx, nextReconciler); err != nil {
+				return err
+			}
+			garbageCollector.Configure(next.GarbageCollection.Policy, next.GarbageCollection.Interval.Duration, next.GarbageCollection.GracePeriod.Duration)
+			return nil
+		},
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OperatorConfig")
+		os.Exit(1)
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -105,22 +253,338 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
 
+// loadConfig loads the operator config file, returning the config and the manager options it sets
+func loadConfig(path string) (eksiamoperatorv1beta1.Config, ctrl.Options, error) {
+	ctrlConfig := eksiamoperatorv1beta1.Config{}
+	options, err := ctrl.Options{Scheme: scheme}.AndFrom(ctrl.ConfigFile().AtPath(path).OfKind(&ctrlConfig))
+	return ctrlConfig, options, err
+}
+
+// prepareConfig completes the settings of a config (discovering or creating the OIDC provider when configured to)
+// and validates them, setting the defaults of unset durations. Invalid settings return an error wrapping
+// controllers.ErrInvalidConfig.
+func prepareConfig(ctx context.Context, cfg *eksiamoperatorv1beta1.Config, restConfig *rest.Config) error {
+	if cfg.OIDC.Discover || cfg.OIDC.ManageProvider {
+		if err := configureOIDC(ctx, cfg, restConfig); err != nil {
+			return fmt.Errorf("unable to configure OIDC: %w", err)
+		}
+	}
+
+	if err := validateConfig(*cfg); err != nil {
+		return fmt.Errorf("%w: %v", controllers.ErrInvalidConfig, err)
+	}
+
+	if cfg.RoleNameOptions.RenameGracePeriod.Duration == 0 {
+		cfg.RoleNameOptions.RenameGracePeriod.Duration = defaultRoleRenameGracePeriod
+	}
+	if cfg.PropagationSettlePeriod.Duration == 0 {
+		cfg.PropagationSettlePeriod.Duration = defaultPropagationSettlePeriod
+	}
+	if cfg.ResyncInterval.Duration == 0 {
+		cfg.ResyncInterval.Duration = defaultResyncInterval
+	}
+	if cfg.RevisionHistoryLimit == 0 {
+		cfg.RevisionHistoryLimit = defaultRevisionHistoryLimit
+	}
+	if cfg.GarbageCollection.Interval.Duration == 0 {
+		cfg.GarbageCollection.Interval.Duration = defaultGarbageCollectionInterval
+	}
+	if cfg.GarbageCollection.GracePeriod.Duration == 0 {
+		cfg.GarbageCollection.GracePeriod.Duration = defaultGarbageCollectionGracePeriod
+	}
+	return nil
+}
+
+// scopeCache restricts the manager cache to the namespaces and Roles in the scope of the operator
+func scopeCache(options *ctrl.Options, scope eksiamoperatorv1beta1.ScopeOptions) error {
+	cacheOptions := cache.Options{}
+	if scope.RoleSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(scope.RoleSelector)
+		if err != nil {
+			return err
+		}
+		cacheOptions.SelectorsByObject = cache.SelectorsByObject{&eksiamoperatorv1beta1.Role{}: {Label: selector}}
+	}
+
+	switch len(scope.Namespaces) {
+	case 0, 1:
+		if len(scope.Namespaces) == 1 {
+			options.Namespace = scope.Namespaces[0]
+		}
+		if cacheOptions.SelectorsByObject != nil {
+			options.NewCache = cache.BuilderWithOptions(cacheOptions)
+		}
+	default:
+		options.NewCache = func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+			opts.SelectorsByObject = cacheOptions.SelectorsByObject
+			return cache.MultiNamespacedCacheBuilder(scope.Namespaces)(config, opts)
+		}
+	}
+	return nil
+}
+
+// newRoleShards returns the shards of Roles of this replica, identified by its hostname (the pod name)
+func newRoleShards(mgr ctrl.Manager, sharding eksiamoperatorv1beta1.ShardingOptions) (*controllers.RoleShards, error) {
+	identity, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace := sharding.LeaseNamespace
+	if len(namespace) == 0 {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("sharding.leaseNamespace must be set when not running in a cluster: %w", err)
+		}
+		namespace = strings.TrimSpace(string(data))
+	}
+
+	duration := sharding.LeaseDuration.Duration
+	if duration == 0 {
+		duration = defaultShardLeaseDuration
+	}
+
+	return &controllers.RoleShards{
+		Reader:        mgr.GetAPIReader(),
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("eks-iam-shards"),
+		Identity:      identity,
+		Namespace:     namespace,
+		LeaseDuration: duration,
+	}, nil
+}
+
+// getOperatorConfig reads the named OperatorConfig, returning nil if it (or its CRD) does not exist
+func getOperatorConfig(ctx context.Context, reader client.Reader, name string) (*eksiamoperatorv1beta1.OperatorConfig, error) {
+	var config eksiamoperatorv1beta1.OperatorConfig
+	if err := reader.Get(ctx, client.ObjectKey{Name: name}, &config); err != nil {
+		if apierrors.IsNotFound(err) || meta.IsNoMatchError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &config, nil
+}
+
+// newRoleReconciler returns a Role reconciler configured from the operator config, without any clients set
+func newRoleReconciler(ctrlConfig eksiamoperatorv1beta1.Config) (*controllers.RoleReconciler, error) {
+	reconciler := &controllers.RoleReconciler{
+		RolePrefix:         ctrlConfig.RoleNameOptions.Prefix,
+		RoleSuffix:         ctrlConfig.RoleNameOptions.Suffix,
+		InlinePolicyPrefix: ctrlConfig.InlinePolicyNameOptions.Prefix,
+		InlinePolicySuffix: ctrlConfig.InlinePolicyNameOptions.Suffix,
+		OIDCIssuerURL:      ctrlConfig.OIDC.IssuerURL,
+		OIDCProviderARN:    ctrlConfig.OIDC.ProviderARN,
+		OIDCAudiences:      ctrlConfig.OIDC.Audiences,
+
+		AdditionalOIDCProviders: ctrlConfig.OIDC.AdditionalProviders,
+
+		RoleRenameGracePeriod: ctrlConfig.RoleNameOptions.RenameGracePeriod.Duration,
+
+		PropagationSettlePeriod: ctrlConfig.PropagationSettlePeriod.Duration,
+		ResyncInterval:          ctrlConfig.ResyncInterval.Duration,
+		RevisionHistoryLimit:    ctrlConfig.RevisionHistoryLimit,
+
+		ClusterName:  ctrlConfig.ClusterName,
+		IdentityMode: ctrlConfig.IdentityMode,
+
+		DryRun: ctrlConfig.DryRun,
+
+		StrictActionValidation: ctrlConfig.ActionValidation.Policy == eksiamoperatorv1beta1.ActionValidationStrict,
+
+		PolicyLint:          ctrlConfig.PolicyLint.Policy != eksiamoperatorv1beta1.PolicyLintDisabled,
+		BlockPolicyFindings: ctrlConfig.PolicyLint.Policy == eksiamoperatorv1beta1.PolicyLintBlock,
+		IgnoredLintRules:    ctrlConfig.PolicyLint.IgnoreRules,
+	}
+
+	var err error
+	switch {
+	case ctrlConfig.ActionValidation.Policy == eksiamoperatorv1beta1.ActionValidationDisabled:
+	case len(ctrlConfig.ActionValidation.CatalogPath) > 0:
+		reconciler.ActionCatalog, err = internal.LoadActionCatalog(ctrlConfig.ActionValidation.CatalogPath)
+	default:
+		reconciler.ActionCatalog, err = internal.DefaultActionCatalog()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the IAM action catalog: %w", err)
+	}
+	if reconciler.ActionCatalog != nil {
+		services, catalogued := reconciler.ActionCatalog.Coverage()
+		setupLog.Info("Loaded IAM action catalog", "services", services, "servicesWithActions", catalogued)
+	}
+	return reconciler, nil
+}
+
+// configureOIDC fills in the OIDC issuer URL and provider ARN when they are not set in the config. The issuer is
+// discovered from EKS if the cluster name is known, otherwise from the API server. The provider is then either
+// created/verified (when managed by the operator) or looked up in IAM. Values set in the config are always kept.
+func configureOIDC(ctx context.Context, cfg *eksiamoperatorv1beta1.Config, restConfig *rest.Config) error {
+	awsClient, err := internal.NewAWSRoleClient(ctx, setupLog)
+	if err != nil {
+		return err
+	}
+
+	if cfg.OIDC.Discover && len(cfg.OIDC.IssuerURL) == 0 {
+		if len(cfg.ClusterName) > 0 {
+			cfg.OIDC.IssuerURL, err = awsClient.DiscoverOIDCIssuerFromCluster(ctx, cfg.ClusterName)
+		} else {
+			cfg.OIDC.IssuerURL, err = internal.DiscoverOIDCIssuerFromAPIServer(ctx, restConfig)
+		}
+		if err != nil {
+			return err
+		}
+		setupLog.Info("discovered OIDC issuer", "issuerUrl", cfg.OIDC.IssuerURL)
+	}
+
+	if cfg.OIDC.ManageProvider {
+		if len(cfg.OIDC.IssuerURL) == 0 {
+			return errors.New("<config> oidc.issuerUrl must be set (or discovered) when oidc.manageProvider is true")
+		}
+
+		clientIDs := cfg.OIDC.Audiences
+		if len(clientIDs) == 0 {
+			clientIDs = []string{internal.DefaultOIDCAudience}
+		}
+
+		arn, err := awsClient.EnsureOIDCProvider(ctx, cfg.OIDC.IssuerURL, clientIDs)
+		if err != nil {
+			return err
+		}
+		setupLog.Info("IAM OIDC provider ready", "providerArn", arn)
+
+		if len(cfg.OIDC.ProviderARN) == 0 {
+			cfg.OIDC.ProviderARN = arn
+		}
+		return nil
+	}
+
+	if cfg.OIDC.Discover && len(cfg.OIDC.ProviderARN) == 0 {
+		if cfg.OIDC.ProviderARN, err = awsClient.FindOIDCProviderARN(ctx, cfg.OIDC.IssuerURL); err != nil {
+			return err
+		}
+		setupLog.Info("discovered OIDC provider", "providerArn", cfg.OIDC.ProviderARN)
+	}
+
+	return nil
+}
+
+// validateStartupConfig checks the settings only read from the config file at startup: the scope and sharding
+func validateStartupConfig(cfg eksiamoperatorv1beta1.Config) error {
+	scope := cfg.Scope
+	for i, v := range scope.Namespaces {
+		if len(v) == 0 {
+			return fmt.Errorf("<config> scope.namespaces[%d] must not be empty", i)
+		}
+	}
+	if _, err := metav1.LabelSelectorAsSelector(scope.RoleSelector); err != nil {
+		return fmt.Errorf("<config> scope.roleSelector must be a valid label selector: %v", err)
+	}
+	if _, err := metav1.LabelSelectorAsSelector(scope.NamespaceSelector); err != nil {
+		return fmt.Errorf("<config> scope.namespaceSelector must be a valid label selector: %v", err)
+	}
+
+	// check sharding
+	if cfg.Sharding.LeaseDuration.Duration < 0 {
+		return errors.New("<config> sharding.leaseDuration must not be negative")
+	}
+	return nil
+}
+
 func validateConfig(cfg eksiamoperatorv1beta1.Config) error {
 
-	// check OIDC provider ARN
-	if len(cfg.OIDC.ProviderARN) == 0 {
-		return errors.New("<config> oidc.providerArn must be set")
+	// the cluster OIDC provider is not needed if every role uses EKS Pod Identity by default
+	if cfg.IdentityMode != eksiamoperatorv1beta1.IdentityModePodIdentity {
+
+		// check OIDC provider ARN
+		if len(cfg.OIDC.ProviderARN) == 0 {
+			return errors.New("<config> oidc.providerArn must be set")
+		}
+
+		// check OIDC issuer URL
+		if len(cfg.OIDC.IssuerURL) == 0 {
+			return errors.New("<config> oidc.issuerURL must be set")
+		}
+	}
+
+	// check additional OIDC providers
+	for i, v := range cfg.OIDC.AdditionalProviders {
+		if len(v.ProviderARN) == 0 || len(v.IssuerURL) == 0 {
+			return fmt.Errorf("<config> oidc.additionalProviders[%d] must set providerArn and issuerUrl", i)
+		}
+	}
+
+	// check identity mode
+	switch cfg.IdentityMode {
+	case "", eksiamoperatorv1beta1.IdentityModeIRSA:
+	case eksiamoperatorv1beta1.IdentityModePodIdentity:
+		if len(cfg.ClusterName) == 0 {
+			return errors.New("<config> clusterName must be set when identityMode is PodIdentity")
+		}
+	default:
+		return fmt.Errorf("<config> identityMode must be one of IRSA or PodIdentity, got %q", cfg.IdentityMode)
+	}
+
+	// check garbage collection
+	switch cfg.GarbageCollection.Policy {
+	case "", eksiamoperatorv1beta1.GarbageCollectionDisabled:
+	case eksiamoperatorv1beta1.GarbageCollectionReport, eksiamoperatorv1beta1.GarbageCollectionDelete:
+		if len(cfg.ClusterName) == 0 {
+			return errors.New("<config> clusterName must be set when garbageCollection.policy is Report or Delete")
+		}
+	default:
+		return fmt.Errorf("<config> garbageCollection.policy must be one of Disabled, Report or Delete, got %q", cfg.GarbageCollection.Policy)
+	}
+	if cfg.GarbageCollection.Interval.Duration < 0 || cfg.GarbageCollection.GracePeriod.Duration < 0 {
+		return errors.New("<config> garbageCollection.interval and garbageCollection.gracePeriod must not be negative")
+	}
+
+	// check action validation
+	switch cfg.ActionValidation.Policy {
+	case "", eksiamoperatorv1beta1.ActionValidationDisabled, eksiamoperatorv1beta1.ActionValidationWarn, eksiamoperatorv1beta1.ActionValidationStrict:
+	default:
+		return fmt.Errorf("<config> actionValidation.policy must be one of Disabled, Warn or Strict, got %q", cfg.ActionValidation.Policy)
+	}
+
+	// check policy lint
+	switch cfg.PolicyLint.Policy {
+	case "", eksiamoperatorv1beta1.PolicyLintDisabled, eksiamoperatorv1beta1.PolicyLintWarn, eksiamoperatorv1beta1.PolicyLintBlock:
+	default:
+		return fmt.Errorf("<config> policyLint.policy must be one of Disabled, Warn or Block, got %q", cfg.PolicyLint.Policy)
+	}
+	rules := map[string]bool{}
+	for _, rule := range internal.LintRules {
+		rules[rule] = true
+	}
+	for _, rule := range cfg.PolicyLint.IgnoreRules {
+		if !rules[rule] {
+			return fmt.Errorf("<config> policyLint.ignoreRules must only contain lint rules, got %q", rule)
+		}
+	}
+
+	// check propagation settle period
+	if cfg.PropagationSettlePeriod.Duration < 0 {
+		return errors.New("<config> propagationSettlePeriod must not be negative")
+	}
+
+	// check resync interval
+	if cfg.ResyncInterval.Duration < 0 {
+		return errors.New("<config> resyncInterval must not be negative")
+	}
+
+	// check revision history limit
+	if cfg.RevisionHistoryLimit < 0 {
+		return errors.New("<config> revisionHistoryLimit must not be negative")
 	}
 
-	// check OIDC issuer URL
-	if len(cfg.OIDC.IssuerURL) == 0 {
-		return errors.New("<config> oidc.issuerURL must be set")
+	// check role rename grace period
+	if cfg.RoleNameOptions.RenameGracePeriod.Duration < 0 {
+		return errors.New("<config> roleNameOptions.renameGracePeriod must not be negative")
 	}
 
 	return nil
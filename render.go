@@ -0,0 +1,289 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+)
+
+// Output formats of the render subcommand
+const (
+	renderFormatJSON           = "json"
+	renderFormatCloudFormation = "cloudformation"
+	renderFormatTerraform      = "terraform"
+)
+
+// renderedRoleOutput is a rendered Role in the default JSON output format
+type renderedRoleOutput struct {
+	Namespace      string                     `json:"namespace"`
+	Name           string                     `json:"name"`
+	RoleName       string                     `json:"roleName"`
+	TrustPolicy    json.RawMessage            `json:"trustPolicy"`
+	InlinePolicies map[string]json.RawMessage `json:"inlinePolicies"`
+	// Customer managed policies for the statements which do not fit inline
+	ManagedPolicies map[string]json.RawMessage `json:"managedPolicies,omitempty"`
+}
+
+// runRender implements the render subcommand, printing the IAM roles the operator would create for Role manifests,
+// without calling any AWS or Kubernetes APIs. It returns the process exit code.
+func runRender(args []string) int {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: manager render -config <file> [-output json|cloudformation|terraform] <role.yaml>...")
+		flags.PrintDefaults()
+	}
+	configFlag := flags.String("config", "", "The operator config file used to render the roles.")
+	outputFlag := flags.String("output", renderFormatJSON, "Output format: json, cloudformation or terraform.")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	var write func(io.Writer, []roleRendering) error
+	switch *outputFlag {
+	case renderFormatJSON:
+		write = writeRenderJSON
+	case renderFormatCloudFormation:
+		write = writeRenderCloudFormation
+	case renderFormatTerraform:
+		write = writeRenderTerraform
+	default:
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", *outputFlag)
+		return exitUsage
+	}
+
+	ctrlConfig, err := loadCLIConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load the config file: %v\n", err)
+		return exitError
+	}
+
+	reconciler, err := newRoleReconciler(ctrlConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure the Role reconciler: %v\n", err)
+		return exitError
+	}
+
+	renderings, err := renderRoleManifests(reconciler, flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	for _, v := range renderings {
+		for _, warning := range v.Role.Warnings {
+			fmt.Fprintf(os.Stderr, "warning: role %s/%s: %s\n", v.Namespace, v.Name, warning)
+		}
+		for _, finding := range v.Role.Findings {
+			if len(finding.Policy) > 0 {
+				fmt.Fprintf(os.Stderr, "warning: role %s/%s: %s: policy %s %s\n", v.Namespace, v.Name, finding.Rule, finding.Policy, finding.Message)
+			} else {
+				fmt.Fprintf(os.Stderr, "warning: role %s/%s: %s: %s\n", v.Namespace, v.Name, finding.Rule, finding.Message)
+			}
+		}
+	}
+
+	if err := write(os.Stdout, renderings); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// roleRendering is a Role manifest and the IAM role rendered for it
+type roleRendering struct {
+	Namespace string
+	Name      string
+	Role      *controllers.RenderedRole
+}
+
+// renderRoleManifests reads Role manifests and renders the IAM role for each
+func renderRoleManifests(reconciler *controllers.RoleReconciler, paths []string) ([]roleRendering, error) {
+	roles, err := readRoleManifests(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	renderings := []roleRendering{}
+	for i := range roles {
+		rendered, err := reconciler.Render(&roles[i])
+		if err == nil {
+			err = reconciler.CheckPolicyFindings(rendered)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("role %s/%s: %w", roles[i].Namespace, roles[i].Name, err)
+		}
+		renderings = append(renderings, roleRendering{Namespace: roles[i].Namespace, Name: roles[i].Name, Role: rendered})
+	}
+	return renderings, nil
+}
+
+// writeRenderJSON writes the rendered roles as a JSON array, with the policies as JSON objects
+func writeRenderJSON(w io.Writer, renderings []roleRendering) error {
+	out := []renderedRoleOutput{}
+	for _, v := range renderings {
+		policies := map[string]json.RawMessage{}
+		for name, doc := range v.Role.InlinePolicies {
+			policies[name] = json.RawMessage(doc)
+		}
+		var managed map[string]json.RawMessage
+		for name, doc := range v.Role.ManagedPolicies {
+			if managed == nil {
+				managed = map[string]json.RawMessage{}
+			}
+			managed[name] = json.RawMessage(doc)
+		}
+		out = append(out, renderedRoleOutput{
+			Namespace:       v.Namespace,
+			Name:            v.Name,
+			RoleName:        v.Role.Name,
+			TrustPolicy:     json.RawMessage(v.Role.TrustPolicy),
+			InlinePolicies:  policies,
+			ManagedPolicies: managed,
+		})
+	}
+	return writeIndentedJSON(w, out)
+}
+
+// writeRenderCloudFormation writes the rendered roles as a CloudFormation template of AWS::IAM::Role resources, with
+// AWS::IAM::ManagedPolicy resources for any managed policies
+func writeRenderCloudFormation(w io.Writer, renderings []roleRendering) error {
+	resources := map[string]interface{}{}
+	for _, v := range renderings {
+		policies := []interface{}{}
+		for _, name := range internal.SortedKeys(v.Role.InlinePolicies) {
+			policies = append(policies, map[string]interface{}{
+				"PolicyName":     name,
+				"PolicyDocument": json.RawMessage(v.Role.InlinePolicies[name]),
+			})
+		}
+
+		resources[resourceName(v, "")] = map[string]interface{}{
+			"Type": "AWS::IAM::Role",
+			"Properties": map[string]interface{}{
+				"RoleName":                 v.Role.Name,
+				"AssumeRolePolicyDocument": json.RawMessage(v.Role.TrustPolicy),
+				"Policies":                 policies,
+			},
+		}
+
+		for i, name := range internal.SortedKeys(v.Role.ManagedPolicies) {
+			resources[fmt.Sprintf("%sManagedPolicy%d", resourceName(v, ""), i+1)] = map[string]interface{}{
+				"Type": "AWS::IAM::ManagedPolicy",
+				"Properties": map[string]interface{}{
+					"ManagedPolicyName": name,
+					"Path":              internal.ManagedPolicyPath,
+					"PolicyDocument":    json.RawMessage(v.Role.ManagedPolicies[name]),
+					"Roles":             []interface{}{map[string]string{"Ref": resourceName(v, "")}},
+				},
+			}
+		}
+	}
+
+	return writeIndentedJSON(w, map[string]interface{}{
+		"AWSTemplateFormatVersion": "2010-09-09",
+		"Resources":                resources,
+	})
+}
+
+// writeRenderTerraform writes the rendered roles as Terraform JSON configuration of aws_iam_role resources, with
+// aws_iam_policy and aws_iam_role_policy_attachment resources for any managed policies
+func writeRenderTerraform(w io.Writer, renderings []roleRendering) error {
+	resources := map[string]interface{}{}
+	managed := map[string]interface{}{}
+	attachments := map[string]interface{}{}
+	for _, v := range renderings {
+		policies := []interface{}{}
+		for _, name := range internal.SortedKeys(v.Role.InlinePolicies) {
+			policies = append(policies, map[string]interface{}{
+				"name":   name,
+				"policy": v.Role.InlinePolicies[name],
+			})
+		}
+
+		resources[resourceName(v, "_")] = map[string]interface{}{
+			"name":               v.Role.Name,
+			"assume_role_policy": v.Role.TrustPolicy,
+			"inline_policy":      policies,
+		}
+
+		for i, name := range internal.SortedKeys(v.Role.ManagedPolicies) {
+			policy := fmt.Sprintf("%s_%d", resourceName(v, "_"), i+1)
+			managed[policy] = map[string]interface{}{
+				"name":   name,
+				"path":   internal.ManagedPolicyPath,
+				"policy": v.Role.ManagedPolicies[name],
+			}
+			attachments[policy] = map[string]interface{}{
+				"role":       fmt.Sprintf("${aws_iam_role.%s.name}", resourceName(v, "_")),
+				"policy_arn": fmt.Sprintf("${aws_iam_policy.%s.arn}", policy),
+			}
+		}
+	}
+
+	resource := map[string]interface{}{
+		"aws_iam_role": resources,
+	}
+	if len(managed) > 0 {
+		resource["aws_iam_policy"] = managed
+		resource["aws_iam_role_policy_attachment"] = attachments
+	}
+	return writeIndentedJSON(w, map[string]interface{}{
+		"resource": resource,
+	})
+}
+
+var nonAlphanumeric = regexp.MustCompile("[^A-Za-z0-9]+")
+
+// resourceName returns a template resource name for a Role, from its namespace and name. CloudFormation logical IDs
+// must be alphanumeric, so words are joined in CamelCase when separator is empty.
+func resourceName(v roleRendering, separator string) string {
+	words := nonAlphanumeric.Split(v.Namespace+" "+v.Name, -1)
+	if len(separator) == 0 {
+		for i, word := range words {
+			if len(word) > 0 {
+				words[i] = strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+	}
+	name := strings.Join(words, separator)
+
+	// Terraform names must not start with a digit
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "Role" + separator + name
+	}
+	return name
+}
+
+// writeIndentedJSON writes a value as indented JSON, with map keys sorted
+func writeIndentedJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
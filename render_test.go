@@ -0,0 +1,232 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neilmcgibbon/eks-iam-operator/controllers"
+	internal "github.com/neilmcgibbon/eks-iam-operator/internal"
+)
+
+const (
+	testIssuer      = "oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+	testProviderARN = "arn:aws:iam::111111111111:oidc-provider/oidc.eks.eu-west-1.amazonaws.com/id/EXAMPLE"
+
+	testConfig = `apiVersion: eks-iam-operator.neilmcgibbon.com/v1beta1
+kind: Config
+roleNameOptions:
+  prefix: eks-
+oidc:
+  providerArn: ` + testProviderARN + `
+  issuerUrl: https://` + testIssuer + `
+`
+
+	testRoleManifest = `apiVersion: eks-iam-operator.neilmcgibbon.com/v1beta1
+kind: Role
+metadata:
+  name: app
+  namespace: payments
+spec:
+  namespace: payments
+  serviceAccounts: [app]
+  statements:
+    s3:
+    - actions: [s3:GetObject]
+      resources: ["arn:aws:s3:::payments/*"]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+`
+)
+
+// writeTestFile writes a file in a temporary directory, returning its path
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunRenderExitCodes(t *testing.T) {
+	config := writeTestFile(t, "config.yaml", testConfig)
+	manifest := writeTestFile(t, "role.yaml", testRoleManifest)
+	invalid := writeTestFile(t, "config.yaml", "apiVersion: eks-iam-operator.neilmcgibbon.com/v1beta1\nkind: Config\n")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected int
+	}{
+		{name: "no manifests", args: []string{"-config", config}, expected: exitUsage},
+		{name: "unknown flag", args: []string{"-format", "json", manifest}, expected: exitUsage},
+		{name: "unknown output format", args: []string{"-config", config, "-output", "yaml", manifest}, expected: exitUsage},
+		{name: "no config", args: []string{manifest}, expected: exitError},
+		{name: "invalid config", args: []string{"-config", invalid, manifest}, expected: exitError},
+		{name: "missing manifest", args: []string{"-config", config, filepath.Join(t.TempDir(), "missing.yaml")}, expected: exitError},
+		{name: "rendered", args: []string{"-config", config, "-output", "terraform", manifest}, expected: exitOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runRender(tt.args); code != tt.expected {
+				t.Fatalf("expected exit code %d, got %d", tt.expected, code)
+			}
+		})
+	}
+}
+
+func TestRenderRoleManifests(t *testing.T) {
+	reconciler := &controllers.RoleReconciler{RolePrefix: "eks-", OIDCIssuerURL: "https://" + testIssuer, OIDCProviderARN: testProviderARN}
+	renderings, err := renderRoleManifests(reconciler, []string{writeTestFile(t, "role.yaml", testRoleManifest)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(renderings) != 1 || renderings[0].Namespace != "payments" || renderings[0].Name != "app" || renderings[0].Role.Name != "eks-app" {
+		t.Fatalf("expected only Role payments/app to be rendered, got %+v", renderings)
+	}
+}
+
+// testRendering returns a rendered Role with an inline policy and a managed policy
+func testRendering() roleRendering {
+	return roleRendering{
+		Namespace: "payments",
+		Name:      "app",
+		Role: &controllers.RenderedRole{
+			Name:            "eks-app",
+			TrustPolicy:     `{"Version":"2012-10-17","Statement":[]}`,
+			InlinePolicies:  map[string]string{"s3": `{"Version":"2012-10-17","Statement":[]}`},
+			ManagedPolicies: map[string]string{"eks-app-s3-1": `{"Version":"2012-10-17","Statement":[]}`},
+		},
+	}
+}
+
+// decodeRender writes rendered Roles with a render output format and decodes the JSON written
+func decodeRender(t *testing.T, write func(*bytes.Buffer) error) map[string]interface{} {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("expected JSON, got %s: %v", buf.String(), err)
+	}
+	return out
+}
+
+// lookup returns the value at a path of keys in decoded JSON, or nil if there is none
+func lookup(v interface{}, keys ...string) interface{} {
+	for _, key := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+	return v
+}
+
+func TestWriteRenderCloudFormation(t *testing.T) {
+	out := decodeRender(t, func(buf *bytes.Buffer) error {
+		return writeRenderCloudFormation(buf, []roleRendering{testRendering()})
+	})
+
+	if lookup(out, "Resources", "PaymentsApp", "Type") != "AWS::IAM::Role" || lookup(out, "Resources", "PaymentsApp", "Properties", "RoleName") != "eks-app" {
+		t.Fatalf("expected role resource PaymentsApp, got %v", out)
+	}
+	policy := lookup(out, "Resources", "PaymentsAppManagedPolicy1")
+	if lookup(policy, "Type") != "AWS::IAM::ManagedPolicy" || lookup(policy, "Properties", "Path") != internal.ManagedPolicyPath {
+		t.Fatalf("expected managed policy resource PaymentsAppManagedPolicy1, got %v", out)
+	}
+	if roles, _ := lookup(policy, "Properties", "Roles").([]interface{}); len(roles) != 1 || lookup(roles[0], "Ref") != "PaymentsApp" {
+		t.Fatalf("expected the managed policy to be attached to PaymentsApp, got %v", policy)
+	}
+}
+
+func TestWriteRenderTerraform(t *testing.T) {
+	out := decodeRender(t, func(buf *bytes.Buffer) error {
+		return writeRenderTerraform(buf, []roleRendering{testRendering()})
+	})
+
+	if lookup(out, "resource", "aws_iam_role", "payments_app", "name") != "eks-app" {
+		t.Fatalf("expected aws_iam_role.payments_app, got %v", out)
+	}
+	if lookup(out, "resource", "aws_iam_policy", "payments_app_1", "name") != "eks-app-s3-1" {
+		t.Fatalf("expected aws_iam_policy.payments_app_1, got %v", out)
+	}
+	attachment := lookup(out, "resource", "aws_iam_role_policy_attachment", "payments_app_1")
+	if lookup(attachment, "role") != "${aws_iam_role.payments_app.name}" || lookup(attachment, "policy_arn") != "${aws_iam_policy.payments_app_1.arn}" {
+		t.Fatalf("expected the managed policy to be attached to payments_app, got %v", attachment)
+	}
+
+	// Roles without managed policies have no policy or attachment resources
+	rendering := testRendering()
+	rendering.Role.ManagedPolicies = nil
+	out = decodeRender(t, func(buf *bytes.Buffer) error {
+		return writeRenderTerraform(buf, []roleRendering{rendering})
+	})
+	if lookup(out, "resource", "aws_iam_policy") != nil || lookup(out, "resource", "aws_iam_role_policy_attachment") != nil {
+		t.Fatalf("expected no managed policy resources, got %v", out)
+	}
+}
+
+func TestWriteRenderJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeRenderJSON(&buf, []roleRendering{testRendering()}); err != nil {
+		t.Fatal(err)
+	}
+	var out []map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0]["roleName"] != "eks-app" || lookup(out[0], "inlinePolicies", "s3", "Version") != "2012-10-17" {
+		t.Fatalf("expected role eks-app with its policies as JSON objects, got %v", out)
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	tests := []struct {
+		namespace string
+		name      string
+		separator string
+		expected  string
+	}{
+		{namespace: "payments", name: "app", separator: "", expected: "PaymentsApp"},
+		{namespace: "payments", name: "app", separator: "_", expected: "payments_app"},
+		{namespace: "payments", name: "my-app.v2", separator: "", expected: "PaymentsMyAppV2"},
+		{namespace: "payments", name: "my-app.v2", separator: "_", expected: "payments_my_app_v2"},
+		{namespace: "1st", name: "app", separator: "", expected: "Role1stApp"},
+		{namespace: "1st", name: "app", separator: "_", expected: "Role_1st_app"},
+	}
+
+	for _, tt := range tests {
+		if name := resourceName(roleRendering{Namespace: tt.namespace, Name: tt.name}, tt.separator); name != tt.expected {
+			t.Errorf("expected resource name %q for %s/%s, got %q", tt.expected, tt.namespace, tt.name, name)
+		}
+	}
+}